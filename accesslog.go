@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// accessLogFile 访问日志输出位置，"-"表示标准输出，空字符串表示不记录
+var accessLogFile string
+
+// accessLogWriter 访问日志输出目标
+var (
+	accessLogMu     sync.Mutex
+	accessLogWriter io.Writer
+)
+
+// setupAccessLog 打开访问日志输出
+func setupAccessLog() {
+	switch accessLogFile {
+	case "":
+		return
+	case "-":
+		accessLogWriter = os.Stdout
+	default:
+		f, err := os.OpenFile(accessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("无法打开访问日志文件: %v", err)
+		}
+		accessLogWriter = f
+	}
+	fmt.Printf("✅ 访问日志已启用: %s\n", accessLogFile)
+}
+
+// statusRecorder 记录响应状态码和写入的字节数
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 供http.ResponseController访问底层ResponseWriter
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// clientIP 返回请求的客户端IP地址；直接连接的对端是可信代理时使用转发头中的地址
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return forwardedClientIP(r, host)
+}
+
+// accessLogMiddleware 为请求附加共享信息，并以Combined Log Format记录访问日志
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, info := withRequestInfo(r)
+		if accessLogWriter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		user := "-"
+		if info.identity != nil && info.identity.Name != "" {
+			user = info.identity.Name
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		referer, userAgent := r.Referer(), r.UserAgent()
+		if referer == "" {
+			referer = "-"
+		}
+		if userAgent == "" {
+			userAgent = "-"
+		}
+
+		line := fmt.Sprintf("%s - %s [%s] %q %d %d %q %q\n",
+			info.clientIP, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.URL.RequestURI()+" "+r.Proto, status, rec.bytes, referer, userAgent)
+
+		accessLogMu.Lock()
+		accessLogWriter.Write([]byte(line))
+		accessLogMu.Unlock()
+	})
+}
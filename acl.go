@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// aclFilePath 访问控制列表配置文件路径
+var aclFilePath string
+
+// 访问控制的操作类别
+const (
+	aclRead  = "read"  // 读取和列出
+	aclWrite = "write" // 创建、修改、删除
+)
+
+// aclRule 单条访问控制规则
+//
+// 规则作用于Path及其下所有路径。用户和组均为空时匹配所有人；
+// 特殊用户 "*" 匹配所有人，"@authenticated" 匹配已认证用户，"@anonymous" 匹配匿名用户。
+// 指定CIDRs时客户端IP必须位于其中之一。
+type aclRule struct {
+	Path         string   `json:"path"`
+	Allow        []string `json:"allow,omitempty"`
+	Deny         []string `json:"deny,omitempty"`
+	Users        []string `json:"users,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+	ExceptUsers  []string `json:"except_users,omitempty"`
+	ExceptGroups []string `json:"except_groups,omitempty"`
+	CIDRs        []string `json:"cidrs,omitempty"`
+
+	nets []*net.IPNet
+}
+
+// aclConfig 访问控制列表
+//
+// 判定规则：在所有匹配当前用户、IP和操作的规则中，路径最具体（最长）的规则生效；
+// 同一路径上同时存在允许和拒绝时，拒绝优先。没有规则匹配时使用Default。
+type aclConfig struct {
+	Default string              `json:"default,omitempty"`
+	Groups  map[string][]string `json:"groups,omitempty"`
+	Rules   []*aclRule          `json:"rules"`
+}
+
+// acl 已加载的访问控制列表，未配置时为nil
+var acl *aclConfig
+
+// aclEnabled 判断是否启用了访问控制列表
+func aclEnabled() bool {
+	return acl != nil
+}
+
+// loadACL 读取并校验访问控制列表文件
+func loadACL(file string) (*aclConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &aclConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("访问控制列表格式错误: %v", err)
+	}
+
+	switch cfg.Default {
+	case "":
+		cfg.Default = "allow"
+	case "allow", "deny":
+	default:
+		return nil, fmt.Errorf("无效的默认策略: %s (可选: allow, deny)", cfg.Default)
+	}
+	for i, rule := range cfg.Rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("第%d条规则的路径必须以 / 开头: %s", i+1, rule.Path)
+		}
+		rule.Path = path.Clean(rule.Path)
+		for _, action := range append(append([]string{}, rule.Allow...), rule.Deny...) {
+			if action != aclRead && action != aclWrite {
+				return nil, fmt.Errorf("第%d条规则包含无效的操作: %s (可选: read, write)", i+1, action)
+			}
+		}
+		for _, cidr := range rule.CIDRs {
+			_, ipnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("第%d条规则的CIDR无效: %s", i+1, cidr)
+			}
+			rule.nets = append(rule.nets, ipnet)
+		}
+	}
+	return cfg, nil
+}
+
+// setupACL 加载访问控制列表
+func setupACL() {
+	if aclFilePath == "" {
+		return
+	}
+	cfg, err := loadACL(aclFilePath)
+	if err != nil {
+		log.Fatalf("无法加载访问控制列表: %v", err)
+	}
+	acl = cfg
+	fmt.Printf("✅ 访问控制列表已启用: %s (%d 条规则, 默认: %s)\n", aclFilePath, len(cfg.Rules), cfg.Default)
+}
+
+// pathWithin 判断p是否为prefix本身或其下的路径
+func pathWithin(p, prefix string) bool {
+	if prefix == "/" || p == prefix {
+		return true
+	}
+	return strings.HasPrefix(p, prefix+"/")
+}
+
+// userGroups 返回用户所属的全部组，包括认证方式提供的组和ACL文件中定义的组
+func (a *aclConfig) userGroups(id *identity) []string {
+	if id == nil {
+		return nil
+	}
+	groups := append([]string{}, id.Groups...)
+	for group, members := range a.Groups {
+		for _, member := range members {
+			if member == id.Name {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// subjectIn 判断用户是否出现在用户或组列表中
+func subjectIn(id *identity, groups, users, groupList []string) bool {
+	for _, u := range users {
+		switch {
+		case u == "*":
+			return true
+		case u == "@authenticated" && id != nil:
+			return true
+		case u == "@anonymous" && id == nil:
+			return true
+		case id != nil && u == id.Name:
+			return true
+		}
+	}
+	for _, g := range groupList {
+		for _, ug := range groups {
+			if g == ug {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches 判断规则是否适用于指定用户和IP
+func (rule *aclRule) matches(id *identity, groups []string, ip net.IP) bool {
+	if len(rule.Users) > 0 || len(rule.Groups) > 0 {
+		if !subjectIn(id, groups, rule.Users, rule.Groups) {
+			return false
+		}
+	}
+	if subjectIn(id, groups, rule.ExceptUsers, rule.ExceptGroups) {
+		return false
+	}
+	if len(rule.nets) > 0 {
+		if ip == nil {
+			return false
+		}
+		inRange := false
+		for _, n := range rule.nets {
+			if n.Contains(ip) {
+				inRange = true
+				break
+			}
+		}
+		if !inRange {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// decide 判定用户对路径的操作是否被允许，并返回起决定作用的规则（无规则匹配时为nil）
+func (a *aclConfig) decide(id *identity, ip string, p, action string) (bool, *aclRule) {
+	p = path.Clean("/" + p)
+	groups := a.userGroups(id)
+	clientAddr := net.ParseIP(ip)
+
+	var decisive *aclRule
+	allowed, bestLen := a.Default == "allow", -1
+	for _, rule := range a.Rules {
+		if !pathWithin(p, rule.Path) || !rule.matches(id, groups, clientAddr) {
+			continue
+		}
+		denies, allows := containsString(rule.Deny, action), containsString(rule.Allow, action)
+		if !denies && !allows {
+			continue
+		}
+		switch {
+		case len(rule.Path) > bestLen:
+			bestLen, allowed, decisive = len(rule.Path), !denies, rule
+		case len(rule.Path) == bestLen && denies && allowed:
+			// 同一路径上拒绝优先
+			allowed, decisive = false, rule
+		}
+	}
+	return allowed, decisive
+}
+
+// allowed 判定操作是否被允许；同时检查路径下是否存在更具体的拒绝规则（subtree为true时）
+func (a *aclConfig) allowed(id *identity, ip string, p, action string, subtree bool) bool {
+	if ok, _ := a.decide(id, ip, p, action); !ok {
+		return false
+	}
+	if !subtree {
+		return true
+	}
+	p = path.Clean("/" + p)
+	for _, rule := range a.Rules {
+		if rule.Path != p && pathWithin(rule.Path, p) {
+			if ok, _ := a.decide(id, ip, rule.Path, action); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// aclAllowed 判定请求对路径的操作是否被允许，未启用访问控制时总是允许
+func aclAllowed(r *http.Request, p, action string) bool {
+	if !aclEnabled() {
+		return true
+	}
+	return acl.allowed(requestIdentity(r), requestClientIP(r), p, action, false)
+}
+
+// aclDenied 返回访问被拒绝的响应：匿名用户要求登录，不可读的资源返回404以隐藏其存在
+func aclDenied(w http.ResponseWriter, r *http.Request, p string) {
+	if requestIdentity(r) == nil && authEnabled() {
+		requestAuthentication(w, r)
+		return
+	}
+	if !aclAllowed(r, p, aclRead) {
+		http.NotFound(w, r)
+		return
+	}
+	http.Error(w, "没有访问权限", http.StatusForbidden)
+}
+
+// aclStaticHandler 按访问控制列表提供静态文件，并在目录列表中隐藏不可读的条目
+func aclStaticHandler(root http.FileSystem) http.Handler {
+	fileServer := http.FileServer(root)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !aclEnabled() {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		if !aclAllowed(r, r.URL.Path, aclRead) {
+			aclDenied(w, r, r.URL.Path)
+			return
+		}
+		http.FileServer(aclHTTPFileSystem{root: root, r: r}).ServeHTTP(w, r)
+	})
+}
+
+// aclHTTPFileSystem 为单个请求过滤http.FileSystem中不可读的文件
+type aclHTTPFileSystem struct {
+	root http.FileSystem
+	r    *http.Request
+}
+
+func (fs aclHTTPFileSystem) Open(name string) (http.File, error) {
+	if !aclAllowed(fs.r, name, aclRead) {
+		return nil, os.ErrNotExist
+	}
+	f, err := fs.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return aclHTTPFile{File: f, fs: fs, dir: path.Clean("/" + name)}, nil
+}
+
+type aclHTTPFile struct {
+	http.File
+	fs  aclHTTPFileSystem
+	dir string
+}
+
+func (f aclHTTPFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	filtered := infos[:0]
+	for _, fi := range infos {
+		if aclAllowed(f.fs.r, path.Join(f.dir, fi.Name()), aclRead) {
+			filtered = append(filtered, fi)
+		}
+	}
+	return filtered, err
+}
+
+// webdavACLMiddleware 在处理WebDAV请求前检查请求路径和MOVE/COPY目标路径的权限
+func webdavACLMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !aclEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		action := aclWrite
+		if isReadMethod(r.Method) || r.Method == "COPY" {
+			action = aclRead
+		}
+		if !aclAllowed(r, r.URL.Path, action) {
+			aclDenied(w, r, r.URL.Path)
+			return
+		}
+		if r.Method == "MOVE" || r.Method == "COPY" {
+			if u, err := url.Parse(r.Header.Get("Destination")); err == nil && u.Path != "" {
+				if !aclAllowed(r, u.Path, aclWrite) {
+					aclDenied(w, r, u.Path)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// aclFS 在webdav.FileSystem层面执行访问控制，过滤PROPFIND结果并保护子目录中受限的资源
+type aclFS struct {
+	webdav.FileSystem
+	prefix string // WebDAV挂载前缀，用于将文件系统路径还原为URL路径
+}
+
+func (fs *aclFS) check(ctx context.Context, name, action string, subtree bool) bool {
+	return acl.allowed(contextIdentity(ctx), contextClientIP(ctx), path.Join(fs.prefix, name), action, subtree)
+}
+
+// denyError 根据读取权限返回合适的错误：不可读时伪装为不存在
+func (fs *aclFS) denyError(ctx context.Context, name string) error {
+	if !fs.check(ctx, name, aclRead, false) {
+		return os.ErrNotExist
+	}
+	return os.ErrPermission
+}
+
+func (fs *aclFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if !fs.check(ctx, name, aclWrite, false) {
+		return fs.denyError(ctx, name)
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *aclFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		if !fs.check(ctx, name, aclWrite, false) {
+			return nil, fs.denyError(ctx, name)
+		}
+	} else if !fs.check(ctx, name, aclRead, false) {
+		return nil, os.ErrNotExist
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &aclFile{File: f, fs: fs, ctx: ctx, dir: name}, nil
+}
+
+func (fs *aclFS) RemoveAll(ctx context.Context, name string) error {
+	if !fs.check(ctx, name, aclWrite, true) {
+		return fs.denyError(ctx, name)
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fs *aclFS) Rename(ctx context.Context, oldName, newName string) error {
+	if !fs.check(ctx, oldName, aclWrite, true) {
+		return fs.denyError(ctx, oldName)
+	}
+	if !fs.check(ctx, newName, aclWrite, true) {
+		return fs.denyError(ctx, newName)
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs *aclFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if !fs.check(ctx, name, aclRead, false) {
+		return nil, os.ErrNotExist
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+// aclFile 在列出目录时隐藏不可读的条目
+type aclFile struct {
+	webdav.File
+	fs  *aclFS
+	ctx context.Context
+	dir string
+}
+
+func (f *aclFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	filtered := infos[:0]
+	for _, fi := range infos {
+		if f.fs.check(f.ctx, path.Join(f.dir, fi.Name()), aclRead, false) {
+			filtered = append(filtered, fi)
+		}
+	}
+	return filtered, err
+}
+
+// runACLCommand 实现 "sweb acl test" 子命令，测试规则对指定路径的判定结果
+func runACLCommand(args []string) {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Println("用法: sweb acl test -acl-file <文件> [-user 用户] [-groups 组1,组2] [-ip 地址] <路径> [read|write]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("acl test", flag.ExitOnError)
+	file := fs.String("acl-file", "acl.json", "访问控制列表文件")
+	user := fs.String("user", "", "用户名 (为空表示匿名用户)")
+	groups := fs.String("groups", "", "用户所属的组，多个用逗号分隔")
+	ip := fs.String("ip", "127.0.0.1", "客户端IP地址")
+	fs.Parse(args[1:])
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadACL(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var id *identity
+	if *user != "" {
+		id = &identity{Name: *user}
+		for _, g := range strings.Split(*groups, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				id.Groups = append(id.Groups, g)
+			}
+		}
+	}
+
+	actions := []string{aclRead, aclWrite}
+	if fs.NArg() == 2 {
+		actions = []string{fs.Arg(1)}
+	}
+	target := fs.Arg(0)
+	for _, action := range actions {
+		ok, rule := cfg.decide(id, *ip, target, action)
+		result := "拒绝"
+		if ok {
+			result = "允许"
+		}
+		reason := "默认策略 (" + cfg.Default + ")"
+		if rule != nil {
+			for i, r := range cfg.Rules {
+				if r == rule {
+					reason = fmt.Sprintf("第%d条规则 (path: %s)", i+1, rule.Path)
+				}
+			}
+		}
+		fmt.Printf("%-5s %s: %s - %s\n", action, target, result, reason)
+	}
+}
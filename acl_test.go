@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestACLDecide(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.json")
+	err := os.WriteFile(file, []byte(`{
+		"default": "deny",
+		"groups": {"staff": ["alice", "carol"]},
+		"rules": [
+			{"path": "/", "allow": ["read"]},
+			{"path": "/webdav", "allow": ["read", "write"], "users": ["@authenticated"]},
+			{"path": "/webdav/private", "deny": ["read", "write"]},
+			{"path": "/webdav/private", "allow": ["read"], "users": ["alice"]},
+			{"path": "/webdav/private/alice", "allow": ["read", "write"], "users": ["alice"]},
+			{"path": "/webdav/team", "deny": ["write"], "except_groups": ["staff"]},
+			{"path": "/webdav/team/shared/", "allow": ["write"], "groups": ["admins"]},
+			{"path": "/webdav/lan", "allow": ["write"], "cidrs": ["10.0.0.0/8"]},
+			{"path": "/webdav/lan", "deny": ["write"], "users": ["@anonymous"]}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadACL(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &identity{Name: "alice", Method: "basic"}
+	bob := &identity{Name: "bob", Method: "basic"}
+	admin := &identity{Name: "dave", Method: "oidc", Groups: []string{"admins"}}
+	tests := []struct {
+		name   string
+		id     *identity
+		ip     string
+		path   string
+		action string
+		want   bool
+	}{
+		{"匿名用户可以读取根目录", nil, "", "/index.html", aclRead, true},
+		{"没有规则匹配时使用默认策略", nil, "", "/index.html", aclWrite, false},
+		{"已认证用户可以写入", bob, "", "/webdav/a.txt", aclWrite, true},
+		{"匿名用户不匹配@authenticated", nil, "", "/webdav/a.txt", aclWrite, false},
+		{"更具体的拒绝覆盖上级的允许", bob, "", "/webdav/private/x", aclRead, false},
+		{"同一路径上拒绝优先", alice, "", "/webdav/private/x", aclRead, false},
+		{"最长路径的允许生效", alice, "", "/webdav/private/alice/x", aclWrite, true},
+		{"其他用户不能访问更具体的路径", bob, "", "/webdav/private/alice/x", aclRead, false},
+		{"路径前缀按目录边界匹配", bob, "", "/webdav/privateer", aclRead, true},
+		{"路径先规范化", bob, "", "/webdav/public/../private", aclRead, false},
+		{"except_groups排除ACL文件中的组", alice, "", "/webdav/team/a", aclWrite, true},
+		{"未排除的用户被拒绝", bob, "", "/webdav/team/a", aclWrite, false},
+		{"认证方式提供的组匹配更具体的规则", admin, "", "/webdav/team/shared/a", aclWrite, true},
+		{"不在排除组中的组被拒绝", admin, "", "/webdav/team/a", aclWrite, false},
+		{"只拒绝写入时仍可读取", bob, "", "/webdav/team/a", aclRead, true},
+		{"CIDR内的客户端", bob, "10.1.2.3", "/webdav/lan/a", aclWrite, true},
+		{"CIDR外的客户端使用上级规则", bob, "192.168.1.1", "/webdav/lan/a", aclWrite, true},
+		{"CIDR内的匿名用户被拒绝", nil, "10.1.2.3", "/webdav/lan/a", aclWrite, false},
+		{"没有IP时不匹配CIDR规则", nil, "", "/webdav/lan/a", aclWrite, false},
+	}
+	for _, tt := range tests {
+		if got, _ := cfg.decide(tt.id, tt.ip, tt.path, tt.action); got != tt.want {
+			t.Errorf("%s: decide(%s, %s) = %v, 期望 %v", tt.name, tt.path, tt.action, got, tt.want)
+		}
+	}
+
+	if ok, rule := cfg.decide(alice, "", "/webdav/private/alice/x", aclWrite); !ok || rule == nil || rule.Path != "/webdav/private/alice" {
+		t.Errorf("起决定作用的规则 = %+v, 期望路径 /webdav/private/alice", rule)
+	}
+	if _, rule := cfg.decide(nil, "", "/index.html", aclWrite); rule != nil {
+		t.Errorf("使用默认策略时起决定作用的规则应为nil, 实际为 %+v", rule)
+	}
+	if cfg.allowed(bob, "", "/webdav", aclWrite, false) != true {
+		t.Error("不检查子路径时应允许写入 /webdav")
+	}
+	if cfg.allowed(bob, "", "/webdav", aclWrite, true) != false {
+		t.Error("子路径中存在拒绝规则时不应允许整体删除或移动 /webdav")
+	}
+	if cfg.allowed(alice, "", "/webdav/private/alice", aclWrite, true) != true {
+		t.Error("子路径中没有拒绝规则时应允许")
+	}
+}
+
+func TestLoadACLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"格式错误", `{"rules": [`},
+		{"无效的默认策略", `{"default": "maybe", "rules": []}`},
+		{"相对路径", `{"rules": [{"path": "webdav", "allow": ["read"]}]}`},
+		{"无效的操作", `{"rules": [{"path": "/webdav", "allow": ["delete"]}]}`},
+		{"无效的CIDR", `{"rules": [{"path": "/webdav", "allow": ["read"], "cidrs": ["10.0.0.0/33"]}]}`},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		file := filepath.Join(dir, "acl.json")
+		if err := os.WriteFile(file, []byte(tt.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadACL(file); err == nil {
+			t.Errorf("%s: 期望加载失败", tt.name)
+		}
+	}
+}
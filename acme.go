@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACME自动证书相关配置
+var (
+	acmeDomains   string
+	acmeEmail     string
+	acmeDirectory string
+	acmeCARoot    string
+)
+
+// acmeManager 在启用ACME时保存证书管理器，供HTTP-01验证和状态API使用
+var acmeManager *autocert.Manager
+
+// acmeEnabled 判断是否启用了ACME自动证书
+func acmeEnabled() bool {
+	return acmeDomains != ""
+}
+
+// acmeDomainList 解析逗号分隔的域名列表
+func acmeDomainList() []string {
+	var domains []string
+	for _, d := range strings.Split(acmeDomains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// acmeCertStatus 记录单个域名最近一次获取证书的结果
+type acmeCertStatus struct {
+	NotAfter  time.Time
+	LastError string
+	ErrorTime time.Time
+}
+
+var (
+	acmeStatusMu sync.Mutex
+	acmeStatuses = make(map[string]*acmeCertStatus)
+)
+
+// setupACME 创建autocert管理器并返回对应的TLS配置
+func setupACME() (*tls.Config, error) {
+	domains := acmeDomainList()
+	if len(domains) == 0 {
+		return nil, errors.New("-acme-domains 未指定有效域名")
+	}
+
+	client := &acme.Client{DirectoryURL: acmeDirectory}
+	if acmeCARoot != "" {
+		// 用于信任本地测试CA (如Pebble) 的ACME接口证书
+		pemData, err := os.ReadFile(acmeCARoot)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取ACME根证书: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("ACME根证书无效: %s", acmeCARoot)
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+
+	acmeManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(filepath.Join(dataDir, "acme")),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Client:     client,
+		Email:      acmeEmail,
+	}
+
+	if tlsRedirectPort == 0 {
+		fmt.Println("ℹ️ 未指定 -tls-redirect-port，ACME仅使用TLS-ALPN-01验证")
+	}
+
+	tlsConfig := acmeManager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := acmeManager.GetCertificate(hello)
+		if !isACMEChallengeHello(hello) {
+			recordACMEResult(hello.ServerName, cert, err)
+		}
+		return cert, err
+	}
+	return tlsConfig, nil
+}
+
+// isACMEChallengeHello 判断握手是否为TLS-ALPN-01验证请求
+func isACMEChallengeHello(hello *tls.ClientHelloInfo) bool {
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			return true
+		}
+	}
+	return false
+}
+
+// recordACMEResult 记录证书获取结果，供状态API展示；
+// 只记录配置的域名，客户端在SNI中发送的其他名称会被忽略，以免状态表无限增长
+func recordACMEResult(serverName string, cert *tls.Certificate, err error) {
+	domain := ""
+	for _, d := range acmeDomainList() {
+		if strings.EqualFold(strings.TrimSuffix(serverName, "."), d) {
+			domain = d
+			break
+		}
+	}
+	if domain == "" {
+		return
+	}
+	acmeStatusMu.Lock()
+	defer acmeStatusMu.Unlock()
+
+	status, ok := acmeStatuses[domain]
+	if !ok {
+		status = &acmeCertStatus{}
+		acmeStatuses[domain] = status
+	}
+	if err != nil {
+		status.LastError = err.Error()
+		status.ErrorTime = time.Now()
+		return
+	}
+	if cert != nil && cert.Leaf != nil {
+		status.NotAfter = cert.Leaf.NotAfter
+		status.LastError = ""
+	}
+}
+
+// cachedACMECertExpiry 从磁盘缓存中读取域名证书的过期时间
+func cachedACMECertExpiry(domain string) (time.Time, bool) {
+	data, err := acmeManager.Cache.Get(context.Background(), domain)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// 缓存内容为私钥PEM块后跟证书链PEM块
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return time.Time{}, false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return cert.NotAfter, true
+	}
+}
+
+// acmeStatus 返回ACME证书状态，用于状态API
+func acmeStatus() map[string]interface{} {
+	if !acmeEnabled() || acmeManager == nil {
+		return map[string]interface{}{
+			"enabled": false,
+		}
+	}
+
+	var certs []map[string]interface{}
+	for _, domain := range acmeDomainList() {
+		entry := map[string]interface{}{
+			"domain": domain,
+			"status": "pending",
+		}
+
+		acmeStatusMu.Lock()
+		status := acmeStatuses[domain]
+		var notAfter time.Time
+		if status != nil {
+			notAfter = status.NotAfter
+			if status.LastError != "" {
+				entry["status"] = "error"
+				entry["error"] = status.LastError
+				entry["error_time"] = status.ErrorTime
+			}
+		}
+		acmeStatusMu.Unlock()
+
+		if notAfter.IsZero() {
+			notAfter, _ = cachedACMECertExpiry(domain)
+		}
+		if !notAfter.IsZero() {
+			entry["not_after"] = notAfter
+			if entry["status"] == "pending" {
+				entry["status"] = "valid"
+				if time.Now().After(notAfter) {
+					entry["status"] = "expired"
+				}
+			}
+		}
+		certs = append(certs, entry)
+	}
+
+	return map[string]interface{}{
+		"enabled":      true,
+		"directory":    acmeDirectory,
+		"certificates": certs,
+	}
+}
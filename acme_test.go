@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// 只记录配置的域名，SNI中的其他名称不会进入状态表
+func TestRecordACMEResult(t *testing.T) {
+	oldDomains := acmeDomains
+	acmeDomains = "example.com, www.example.com"
+	acmeStatuses = make(map[string]*acmeCertStatus)
+	defer func() {
+		acmeDomains = oldDomains
+		acmeStatuses = make(map[string]*acmeCertStatus)
+	}()
+
+	tests := []struct {
+		serverName string
+		want       string // 期望记录的域名，为空表示不记录
+	}{
+		{"example.com", "example.com"},
+		{"WWW.Example.COM", "www.example.com"},
+		{"example.com.", "example.com"},
+		{"evil.example.com", ""},
+		{"192.0.2.1", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		acmeStatuses = make(map[string]*acmeCertStatus)
+		recordACMEResult(tt.serverName, nil, errors.New("失败"))
+		_, recorded := acmeStatuses[tt.want]
+		if tt.want == "" {
+			if len(acmeStatuses) != 0 {
+				t.Errorf("%q: 不应记录，状态表 = %v", tt.serverName, acmeStatuses)
+			}
+		} else if !recorded || len(acmeStatuses) != 1 {
+			t.Errorf("%q: 应记录为 %q，状态表 = %v", tt.serverName, tt.want, acmeStatuses)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API令牌的权限范围
+const (
+	tokenScopeRead  = "read"  // 只读：GET、HEAD、PROPFIND等
+	tokenScopeWrite = "write" // 读写：上传、修改和删除文件
+	tokenScopeAdmin = "admin" // 管理：读写，并可使用令牌所有者的管理员权限和管理API令牌
+)
+
+// apiTokenPrefix API令牌的前缀，用于区分令牌和普通密码
+const apiTokenPrefix = "sweb_"
+
+// apiTokenTouchInterval 最后使用时间写回磁盘的最小间隔
+const apiTokenTouchInterval = time.Minute
+
+// apiToken 持久化的API令牌记录，只保存令牌密钥的哈希
+type apiToken struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	Owner      string    `json:"owner"`           // 令牌所有者，以其身份进行授权检查
+	Basic      bool      `json:"basic,omitempty"` // 所有者是htpasswd用户，从htpasswd文件中删除后令牌失效
+	Scope      string    `json:"scope"`
+	PathPrefix string    `json:"path_prefix,omitempty"` // 只允许访问该URL路径及其子路径
+	Hash       string    `json:"hash"`                  // 令牌密钥的SHA-256
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires,omitempty"` // 零值表示永不过期
+	LastUsed   time.Time `json:"last_used,omitempty"`
+	LastIP     string    `json:"last_ip,omitempty"`
+}
+
+// expired 判断令牌是否已过期
+func (t *apiToken) expired() bool {
+	return !t.Expires.IsZero() && time.Now().After(t.Expires)
+}
+
+// view 返回API中展示的令牌信息，不包含哈希
+func (t *apiToken) view() map[string]interface{} {
+	v := map[string]interface{}{
+		"id":      t.ID,
+		"name":    t.Name,
+		"owner":   t.Owner,
+		"scope":   t.Scope,
+		"created": t.Created,
+		"expired": t.expired(),
+	}
+	if t.PathPrefix != "" {
+		v["path_prefix"] = t.PathPrefix
+	}
+	if !t.Expires.IsZero() {
+		v["expires"] = t.Expires
+	}
+	if !t.LastUsed.IsZero() {
+		v["last_used"] = t.LastUsed
+		v["last_ip"] = t.LastIP
+	}
+	return v
+}
+
+// validTokenScope 判断权限范围是否有效
+func validTokenScope(scope string) bool {
+	switch scope {
+	case tokenScopeRead, tokenScopeWrite, tokenScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// permits 判断令牌是否允许该请求：检查请求方法是否在权限范围内，以及请求路径（和WebDAV目标路径）是否在路径前缀内
+func (t *apiToken) permits(r *http.Request) bool {
+	if t.Scope == tokenScopeRead && !isReadMethod(r.Method) {
+		return false
+	}
+	if t.PathPrefix == "" {
+		return true
+	}
+	if !pathWithin(path.Clean("/"+r.URL.Path), t.PathPrefix) {
+		return false
+	}
+	if dest := r.Header.Get("Destination"); dest != "" {
+		u, err := url.Parse(dest)
+		if err != nil || !pathWithin(path.Clean("/"+u.Path), t.PathPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// newAPIToken 生成令牌ID和完整的令牌字符串，令牌形如 sweb_<ID>_<密钥>
+func newAPIToken() (id, token, hash string) {
+	id = newShareLinkID()
+	secret := randomToken()
+	return id, apiTokenPrefix + id + "_" + secret, hashTokenSecret(secret)
+}
+
+// hashTokenSecret 计算令牌密钥的哈希；密钥是高熵随机串，无需加盐和慢哈希
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitAPIToken 将令牌拆分为ID和密钥
+func splitAPIToken(token string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(strings.TrimPrefix(token, apiTokenPrefix), "_")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+// apiTokenStore 持久化的API令牌，保存在 <data-dir>/api-tokens.json；
+// 文件被其他进程（如 sweb token revoke）修改后自动重新加载
+type apiTokenStore struct {
+	path string
+
+	mu        sync.Mutex
+	tokens    map[string]*apiToken
+	modTime   time.Time
+	lastCheck time.Time
+	lastSave  time.Time
+}
+
+// apiTokens 服务器使用的API令牌，未启用认证时为nil
+var apiTokens *apiTokenStore
+
+func openAPITokenStore(file string) (*apiTokenStore, error) {
+	s := &apiTokenStore{path: file}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *apiTokenStore) reload() error {
+	var list []*apiToken
+	if err := readJSONFile(s.path, &list); err != nil {
+		return fmt.Errorf("无法读取API令牌: %v", err)
+	}
+	s.tokens = make(map[string]*apiToken, len(list))
+	for _, t := range list {
+		s.tokens[t.ID] = t
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// refresh 在文件变更后重新加载令牌，调用者需持有锁
+func (s *apiTokenStore) refresh() {
+	now := time.Now()
+	if now.Sub(s.lastCheck) < shareLinkCheckInterval {
+		return
+	}
+	s.lastCheck = now
+	if fi, err := os.Stat(s.path); err == nil && !fi.ModTime().Equal(s.modTime) {
+		if err := s.reload(); err != nil {
+			log.Printf("重新加载API令牌失败，继续使用旧数据: %v", err)
+		}
+	}
+}
+
+// save 写回令牌文件，调用者需持有锁
+func (s *apiTokenStore) save() error {
+	list := make([]*apiToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	if err := writeJSONFile(s.path, list); err != nil {
+		return err
+	}
+	s.lastSave = time.Now()
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+func (s *apiTokenStore) add(t *apiToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = time.Time{}
+	s.refresh()
+	s.tokens[t.ID] = t
+	return s.save()
+}
+
+// get 返回令牌记录的副本
+func (s *apiTokenStore) get(id string) (apiToken, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	if t, ok := s.tokens[id]; ok {
+		return *t, true
+	}
+	return apiToken{}, false
+}
+
+// revoke 删除令牌，令牌不存在时返回错误
+func (s *apiTokenStore) revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = time.Time{}
+	s.refresh()
+	if _, ok := s.tokens[id]; !ok {
+		return fmt.Errorf("令牌不存在: %s", id)
+	}
+	delete(s.tokens, id)
+	return s.save()
+}
+
+// list 返回令牌列表，owner为空时返回全部
+func (s *apiTokenStore) list(owner string) []apiToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	var result []apiToken
+	for _, t := range s.tokens {
+		if owner == "" || t.Owner == owner {
+			result = append(result, *t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+	return result
+}
+
+// verify 校验令牌并记录使用时间，返回令牌记录的副本
+func (s *apiTokenStore) verify(token, ip string) (*apiToken, error) {
+	id, secret, ok := splitAPIToken(token)
+	if !ok {
+		return nil, errors.New("令牌格式错误")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	t, ok := s.tokens[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hashTokenSecret(secret))) != 1 {
+		return nil, errors.New("令牌无效或已被撤销")
+	}
+	if t.expired() {
+		return nil, errors.New("令牌已过期")
+	}
+	now := time.Now()
+	t.LastUsed, t.LastIP = now, ip
+	if now.Sub(s.lastSave) >= apiTokenTouchInterval {
+		if err := s.save(); err != nil {
+			log.Printf("无法保存API令牌: %v", err)
+		}
+	}
+	copied := *t
+	return &copied, nil
+}
+
+// setupAPITokens 加载API令牌；令牌属于用户，因此只在启用了认证时可用
+func setupAPITokens() {
+	if !authEnabled() {
+		return
+	}
+	store, err := openAPITokenStore(dataPath("api-tokens.json"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	apiTokens = store
+	fmt.Printf("✅ API令牌认证已启用 - %d 个令牌\n", len(store.tokens))
+}
+
+// requestAPIToken 取得请求携带的API令牌：Authorization: Bearer 或 Basic认证的密码，
+// 后者供只支持用户名和密码的WebDAV客户端使用
+func requestAPIToken(r *http.Request) (user, token string, basic, ok bool) {
+	if apiTokens == nil {
+		return "", "", false, false
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return "", strings.TrimSpace(auth[7:]), false, true
+	}
+	if user, password, ok := r.BasicAuth(); ok && strings.HasPrefix(password, apiTokenPrefix) {
+		return user, password, true, true
+	}
+	return "", "", false, false
+}
+
+// authenticateAPIToken 校验API令牌并设置请求身份，失败时写出错误响应并返回false
+func authenticateAPIToken(w http.ResponseWriter, r *http.Request, user, token string, basic bool) bool {
+	ip := requestClientIP(r)
+	if authThrottled(w, r, user) {
+		return false
+	}
+	t, err := apiTokens.verify(token, ip)
+	if err == nil && basic && user != "" && user != t.Owner {
+		err = errors.New("用户名与令牌所有者不符")
+	}
+	if err == nil && !apiTokenOwnerExists(t) {
+		err = errors.New("令牌所有者已不存在")
+	}
+	if err != nil {
+		throttle.failure(user, ip, "API令牌: "+err.Error())
+		if basic {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, authRealm))
+		} else {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, error="invalid_token"`, authRealm))
+		}
+		http.Error(w, "API令牌无效", http.StatusUnauthorized)
+		return false
+	}
+	if !t.permits(r) {
+		if !basic {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, error="insufficient_scope"`, authRealm))
+		}
+		http.Error(w, "API令牌权限不足", http.StatusForbidden)
+		return false
+	}
+	// 所属组在每次请求时由访问控制列表解析，所有者被移出组后令牌随之失去相应权限
+	setRequestIdentity(r, &identity{Name: t.Owner, Method: "token", Token: t})
+	return true
+}
+
+// apiTokenOwnerExists 判断令牌所有者是否仍然存在：htpasswd用户被删除后其令牌失效；
+// 只使用htpasswd认证时所有令牌的所有者都必须是htpasswd用户
+func apiTokenOwnerExists(t *apiToken) bool {
+	if htpasswdUsers == nil || htpasswdUsers.exists(t.Owner) {
+		return true
+	}
+	return !t.Basic && (oidcEnabled() || mtlsEnabled())
+}
+
+// apiTokenRequest 创建API令牌的请求参数
+type apiTokenRequest struct {
+	Name       string `json:"name"`
+	Scope      string `json:"scope"`
+	PathPrefix string `json:"path_prefix"`
+	ExpiresIn  string `json:"expires_in"` // 有效期，如 "720h"，为空表示永不过期
+	User       string `json:"user"`       // 令牌所有者，仅管理员可以为其他用户创建
+}
+
+// newAPITokenRecord 校验参数并生成令牌记录，返回记录和完整的令牌字符串
+func newAPITokenRecord(owner, name, scope, prefix, expiresIn string) (*apiToken, string, error) {
+	if scope == "" {
+		scope = tokenScopeWrite
+	}
+	if !validTokenScope(scope) {
+		return nil, "", fmt.Errorf("无效的权限范围: %s (可选: read, write, admin)", scope)
+	}
+	if prefix != "" {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, "", errors.New("路径前缀必须以 / 开头")
+		}
+		prefix = path.Clean(prefix)
+		if prefix == "/" {
+			prefix = ""
+		}
+	}
+	var expires time.Time
+	if expiresIn != "" {
+		ttl, err := parseShareTTL(expiresIn)
+		if err != nil {
+			return nil, "", err
+		}
+		expires = time.Now().Add(ttl).Truncate(time.Second)
+	}
+	id, token, hash := newAPIToken()
+	t := &apiToken{ID: id, Name: name, Owner: owner, Basic: htpasswdUsers.exists(owner), Scope: scope, PathPrefix: prefix,
+		Hash: hash, Created: time.Now(), Expires: expires}
+	return t, token, nil
+}
+
+// apiTokensAPIHandler 处理 /api/tokens：
+// GET 列出当前用户的令牌（管理员列出全部），POST 创建令牌，DELETE ?id= 撤销令牌
+func apiTokensAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id := authenticatedUser(r)
+	if id == nil {
+		requestAuthentication(w, r)
+		return
+	}
+	if apiTokens == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if id.Token != nil && id.Token.Scope != tokenScopeAdmin {
+		http.Error(w, "管理API令牌需要admin权限范围的令牌", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		owner := id.Name
+		if isAdmin(id) {
+			owner = ""
+		}
+		result := []map[string]interface{}{}
+		for _, t := range apiTokens.list(owner) {
+			result = append(result, t.view())
+		}
+		writeJSON(w, http.StatusOK, result)
+
+	case http.MethodPost:
+		var req apiTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		owner := id.Name
+		if req.User != "" && req.User != id.Name {
+			if !isAdmin(id) {
+				http.Error(w, "只有管理员可以为其他用户创建令牌", http.StatusForbidden)
+				return
+			}
+			owner = req.User
+		}
+		t, token, err := newAPITokenRecord(owner, req.Name, req.Scope, req.PathPrefix, req.ExpiresIn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := apiTokens.add(t); err != nil {
+			log.Printf("无法保存API令牌: %v", err)
+			http.Error(w, "无法创建API令牌", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("用户 %s 创建了API令牌 %s (所有者: %s, 权限: %s)", id.Name, t.ID, t.Owner, t.Scope)
+		v := t.view()
+		v["token"] = token
+		writeJSON(w, http.StatusCreated, v)
+
+	case http.MethodDelete:
+		tokenID := r.URL.Query().Get("id")
+		t, ok := apiTokens.get(tokenID)
+		if !ok || (!isAdmin(id) && t.Owner != id.Name) {
+			http.NotFound(w, r)
+			return
+		}
+		if err := apiTokens.revoke(tokenID); err != nil {
+			log.Printf("无法撤销API令牌: %v", err)
+			http.Error(w, "无法撤销API令牌", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("用户 %s 撤销了API令牌 %s", id.Name, tokenID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// runTokenCommand 实现 "sweb token" 子命令：创建、列出和撤销API令牌
+func runTokenCommand(args []string) {
+	action := ""
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", ".sweb", "程序状态数据目录")
+	user := fs.String("user", "", "令牌所有者")
+	name := fs.String("name", "", "令牌名称或用途说明")
+	scope := fs.String("scope", tokenScopeWrite, "权限范围: read, write 或 admin")
+	prefix := fs.String("prefix", "", "只允许访问该URL路径及其子路径，如 /webdav/backup")
+	expires := fs.String("expires", "", "有效期，如 720h 或秒数 (为空表示永不过期)")
+	fs.Usage = func() {
+		fmt.Println("用法:")
+		fmt.Println("  sweb token create [-data-dir 目录] -user 用户 [-name 名称] [-scope 范围] [-prefix 路径] [-expires 时长]")
+		fmt.Println("  sweb token list [-data-dir 目录] [-user 用户]")
+		fmt.Println("  sweb token revoke [-data-dir 目录] <令牌ID>")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	store, err := openAPITokenStore(dataPath("api-tokens.json"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch action {
+	case "create":
+		if *user == "" || fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		t, token, err := newAPITokenRecord(*user, *name, *scope, *prefix, *expires)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := store.add(t); err != nil {
+			fmt.Fprintf(os.Stderr, "无法保存API令牌: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("已创建API令牌 %s (所有者: %s, 权限: %s)\n", t.ID, t.Owner, t.Scope)
+		fmt.Println("令牌只显示这一次，请妥善保存:")
+		fmt.Println(token)
+
+	case "list":
+		for _, t := range store.list(*user) {
+			expires, used, limit := "永不过期", "从未使用", "全部路径"
+			if !t.Expires.IsZero() {
+				expires = t.Expires.Format("2006-01-02 15:04")
+				if t.expired() {
+					expires += " (已过期)"
+				}
+			}
+			if !t.LastUsed.IsZero() {
+				used = t.LastUsed.Format("2006-01-02 15:04") + " " + t.LastIP
+			}
+			if t.PathPrefix != "" {
+				limit = t.PathPrefix
+			}
+			fmt.Printf("%s  %-5s %-10s %s  过期: %s  最后使用: %s  %s\n", t.ID, t.Scope, t.Owner, limit, expires, used, t.Name)
+		}
+
+	case "revoke":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		if err := store.revoke(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "无法撤销API令牌: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("已撤销API令牌 %s\n", fs.Arg(0))
+
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 令牌所有者从htpasswd文件中删除后令牌失效
+func TestAPITokenOwnerExists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	hash, _ := hashPassword("sha256", "pw")
+	if err := os.WriteFile(file, []byte("alice:"+hash+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := loadHtpasswdFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldUsers, oldIssuer := htpasswdUsers, oidcIssuer
+	defer func() { htpasswdUsers, oidcIssuer = oldUsers, oldIssuer }()
+
+	tests := []struct {
+		name   string
+		users  *htpasswdFile
+		oidc   bool
+		token  apiToken
+		exists bool
+	}{
+		{"未启用htpasswd", nil, false, apiToken{Owner: "bob"}, true},
+		{"htpasswd用户", users, false, apiToken{Owner: "alice", Basic: true}, true},
+		{"已删除的htpasswd用户", users, false, apiToken{Owner: "bob", Basic: true}, false},
+		{"只有htpasswd认证时的未知用户", users, false, apiToken{Owner: "bob"}, false},
+		{"OpenID Connect用户", users, true, apiToken{Owner: "carol"}, true},
+		{"同时启用OpenID Connect时已删除的htpasswd用户", users, true, apiToken{Owner: "bob", Basic: true}, false},
+	}
+	for _, tt := range tests {
+		htpasswdUsers, oidcIssuer = tt.users, ""
+		if tt.oidc {
+			oidcIssuer = "https://idp.example.com"
+		}
+		if got := apiTokenOwnerExists(&tt.token); got != tt.exists {
+			t.Errorf("%s: apiTokenOwnerExists = %v, 期望 %v", tt.name, got, tt.exists)
+		}
+	}
+}
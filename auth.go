@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Basic认证相关配置
+var (
+	htpasswdPath string
+	authStatic   string
+	authUpload   string
+	authWebDAV   string
+	adminUsers   string
+)
+
+// htpasswdUsers 已加载的htpasswd用户文件，未启用认证时为nil
+var htpasswdUsers *htpasswdFile
+
+// authRealm Basic认证的领域名称
+const authRealm = "sweb"
+
+// 认证要求级别
+const (
+	authNone  = "none"  // 不要求认证
+	authWrite = "write" // 仅写操作要求认证
+	authAll   = "all"   // 所有操作都要求认证
+)
+
+// authEnabled 判断是否启用了任一认证方式
+func authEnabled() bool {
+	return basicAuthEnabled() || mtlsEnabled() || oidcEnabled()
+}
+
+// basicAuthEnabled 判断是否启用了Basic认证
+func basicAuthEnabled() bool {
+	return htpasswdUsers != nil
+}
+
+// isAdmin 判断身份是否为管理员：在 -admin-users 列表中，或属于 admin 组
+func isAdmin(id *identity) bool {
+	if id == nil || id.Name == "" {
+		return false
+	}
+	if id.Token != nil && id.Token.Scope != tokenScopeAdmin {
+		// 非admin权限范围的令牌不能使用所有者的管理员权限
+		return false
+	}
+	for _, name := range strings.Split(adminUsers, ",") {
+		if strings.TrimSpace(name) == id.Name {
+			return true
+		}
+	}
+	groups := id.Groups
+	if aclEnabled() {
+		groups = acl.userGroups(id)
+	}
+	return containsString(groups, "admin")
+}
+
+// setupAuth 加载htpasswd文件并校验各挂载点的认证配置
+func setupAuth() {
+	for mount, level := range map[string]string{"static": authStatic, "upload": authUpload, "webdav": authWebDAV} {
+		switch level {
+		case authNone, authWrite, authAll:
+		default:
+			log.Fatalf("无效的 -auth-%s 取值: %s (可选: none, write, all)", mount, level)
+		}
+	}
+	if webdavUserHomes {
+		if htpasswdPath == "" && !mtlsEnabled() && !oidcEnabled() {
+			log.Fatal("WebDAV用户主目录需要启用认证 (-htpasswd、-tls-client-ca 或 -oidc-issuer)")
+		}
+		if authWebDAV != authAll {
+			// 主目录由用户身份决定，匿名请求无法访问任何内容
+			fmt.Println("ℹ️ 已启用WebDAV用户主目录，WebDAV认证要求调整为 all")
+			authWebDAV = authAll
+		}
+	}
+	if htpasswdPath == "" {
+		return
+	}
+
+	users, err := loadHtpasswdFile(htpasswdPath)
+	if err != nil {
+		log.Fatalf("无法加载htpasswd文件: %v", err)
+	}
+	htpasswdUsers = users
+	fmt.Printf("✅ Basic认证已启用 - 用户文件: %s (静态文件: %s, 上传: %s, WebDAV: %s)\n",
+		htpasswdPath, authStatic, authUpload, authWebDAV)
+}
+
+// authLevel 返回挂载点的认证要求级别
+func authLevel(mount string) string {
+	switch mount {
+	case "static":
+		return authStatic
+	case "upload":
+		return authUpload
+	case "webdav":
+		return authWebDAV
+	}
+	return authAll
+}
+
+// isReadMethod 判断HTTP方法是否属于只读操作
+func isReadMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PROPFIND", "REPORT", "SEARCH":
+		return true
+	}
+	return false
+}
+
+// authRequired 判断对挂载点的请求是否需要认证
+func authRequired(mount string, r *http.Request) bool {
+	if !authEnabled() {
+		return false
+	}
+	switch authLevel(mount) {
+	case authAll:
+		return true
+	case authWrite:
+		return !isReadMethod(r.Method)
+	}
+	return false
+}
+
+// authMiddleware 识别请求中携带的API令牌或Basic认证凭据
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, token, basic, ok := requestAPIToken(r); ok && requestIdentity(r) == nil {
+			if !authenticateAPIToken(w, r, user, token, basic) {
+				return
+			}
+		} else if basicAuthEnabled() && requestIdentity(r) == nil {
+			if user, password, ok := r.BasicAuth(); ok {
+				if authThrottled(w, r, user) {
+					return
+				}
+				if method, ok := verifyBasicPassword(user, password); ok {
+					throttle.success(user)
+					setRequestIdentity(r, &identity{Name: user, Method: method})
+				} else {
+					throttle.failure(user, requestClientIP(r), "Basic认证")
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuth 按挂载点的IP过滤规则和认证要求保护处理器
+func requireAuth(mount string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !mountIPAllowed(mount, r) {
+			http.Error(w, "不允许从当前地址访问", http.StatusForbidden)
+			return
+		}
+		if authRequired(mount, r) && requestIdentity(r) == nil {
+			requestAuthentication(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestAuthentication 要求客户端登录：浏览器跳转到登录页面，其他客户端返回401并要求Basic认证
+func requestAuthentication(w http.ResponseWriter, r *http.Request) {
+	if wantsLoginPage(r) {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, authRealm))
+	http.Error(w, "需要登录", http.StatusUnauthorized)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 暴力破解防护配置
+var (
+	lockoutUserThreshold int
+	lockoutIPThreshold   int
+	lockoutDuration      time.Duration
+	lockoutMaxDuration   time.Duration
+)
+
+// 内存中最多保留的失败记录和安全事件数量
+const (
+	authFailureLimit  = 10000
+	securityEventKeep = 500
+)
+
+// authFailure 单个用户或IP地址的认证失败记录
+type authFailure struct {
+	Failures     int       `json:"failures"`
+	LastFailure  time.Time `json:"last_failure"`
+	BlockedUntil time.Time `json:"blocked_until,omitempty"`
+	Blocks       int       `json:"blocks"` // 被锁定的次数，决定下一次锁定的时长
+}
+
+// blocked 判断当前是否处于锁定状态，返回剩余时间
+func (f *authFailure) blocked(now time.Time) (time.Duration, bool) {
+	if f == nil || !now.Before(f.BlockedUntil) {
+		return 0, false
+	}
+	return f.BlockedUntil.Sub(now), true
+}
+
+// securityEvent 安全事件，记录到日志并保存在内存中供管理API查看
+type securityEvent struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	User   string    `json:"user,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// authThrottle 按用户和来源IP统计认证失败次数；达到阈值后锁定，
+// 每次锁定的时长在上一次的基础上翻倍，直到上限
+type authThrottle struct {
+	mu     sync.Mutex
+	users  map[string]*authFailure
+	ips    map[string]*authFailure
+	events []securityEvent
+}
+
+var throttle = &authThrottle{users: make(map[string]*authFailure), ips: make(map[string]*authFailure)}
+
+// lockoutEnabled 判断是否启用了暴力破解防护
+func lockoutEnabled() bool {
+	return lockoutUserThreshold > 0 || lockoutIPThreshold > 0
+}
+
+// recordEvent 记录安全事件，调用者需持有锁
+func (t *authThrottle) recordEvent(kind, user, ip, detail string) {
+	ev := securityEvent{Time: time.Now(), Type: kind, User: user, IP: ip, Detail: detail}
+	t.events = append(t.events, ev)
+	if len(t.events) > securityEventKeep {
+		t.events = append([]securityEvent(nil), t.events[len(t.events)-securityEventKeep:]...)
+	}
+	log.Printf("🚨 安全事件 [%s] 用户: %s IP: %s %s", kind, user, ip, detail)
+}
+
+// event 记录安全事件
+func (t *authThrottle) event(kind, user, ip, detail string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recordEvent(kind, user, ip, detail)
+}
+
+// check 判断用户或IP是否处于锁定状态，返回剩余锁定时间
+func (t *authThrottle) check(user, ip string) (time.Duration, bool) {
+	if !lockoutEnabled() {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if d, ok := t.users[user].blocked(now); ok && user != "" {
+		return d, true
+	}
+	return t.ips[ip].blocked(now)
+}
+
+// prune 清理长时间没有失败的记录，调用者需持有锁
+func (t *authThrottle) prune(records map[string]*authFailure, now time.Time) {
+	if len(records) < authFailureLimit {
+		return
+	}
+	for key, f := range records {
+		if _, blocked := f.blocked(now); !blocked && now.Sub(f.LastFailure) > lockoutMaxDuration {
+			delete(records, key)
+		}
+	}
+}
+
+// addFailure 增加一次失败计数，达到阈值时锁定，返回本次锁定的时长（未锁定时为0），调用者需持有锁
+func (t *authThrottle) addFailure(records map[string]*authFailure, key string, threshold int, now time.Time) time.Duration {
+	if threshold <= 0 || key == "" {
+		return 0
+	}
+	t.prune(records, now)
+	f, ok := records[key]
+	if !ok {
+		f = &authFailure{}
+		records[key] = f
+	}
+	if now.Sub(f.LastFailure) > lockoutMaxDuration {
+		// 长时间没有失败后重新计数，但保留锁定次数的指数退避需要同样长的冷却期
+		f.Failures = 0
+		if now.Sub(f.LastFailure) > 2*lockoutMaxDuration {
+			f.Blocks = 0
+		}
+	}
+	f.Failures++
+	f.LastFailure = now
+	if f.Failures < threshold {
+		return 0
+	}
+	d := time.Duration(float64(lockoutDuration) * math.Pow(2, float64(f.Blocks)))
+	if d > lockoutMaxDuration || d <= 0 {
+		d = lockoutMaxDuration
+	}
+	f.Blocks++
+	f.Failures = 0
+	f.BlockedUntil = now.Add(d)
+	return d
+}
+
+// failure 记录一次认证失败，user为空时只统计IP地址
+func (t *authThrottle) failure(user, ip, detail string) {
+	if !lockoutEnabled() {
+		log.Printf("认证失败: 用户 %s 来自 %s %s", user, ip, detail)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.recordEvent("auth_failure", user, ip, detail)
+	if d := t.addFailure(t.users, user, lockoutUserThreshold, now); d > 0 {
+		t.recordEvent("user_locked", user, ip, fmt.Sprintf("锁定 %s", d))
+	}
+	if d := t.addFailure(t.ips, ip, lockoutIPThreshold, now); d > 0 {
+		t.recordEvent("ip_locked", user, ip, fmt.Sprintf("锁定 %s", d))
+	}
+}
+
+// success 认证成功后清除用户的失败计数；IP地址的计数保留，防止攻击者用自己的账号重置计数
+func (t *authThrottle) success(user string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f, ok := t.users[user]; ok {
+		if _, blocked := f.blocked(time.Now()); !blocked {
+			delete(t.users, user)
+		}
+	}
+}
+
+// unblock 解除对用户或IP地址的锁定，返回是否存在该记录
+func (t *authThrottle) unblock(user, ip, admin string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	found := false
+	if _, ok := t.users[user]; ok && user != "" {
+		delete(t.users, user)
+		found = true
+		t.recordEvent("user_unblocked", user, "", "操作者: "+admin)
+	}
+	if _, ok := t.ips[ip]; ok && ip != "" {
+		delete(t.ips, ip)
+		found = true
+		t.recordEvent("ip_unblocked", "", ip, "操作者: "+admin)
+	}
+	return found
+}
+
+// blockedEntry 管理API中展示的锁定记录
+type blockedEntry struct {
+	Name string `json:"name"`
+	authFailure
+}
+
+// blockedList 返回处于锁定状态或有失败记录的条目
+func (t *authThrottle) blockedList(records map[string]*authFailure, all bool) []blockedEntry {
+	now := time.Now()
+	result := []blockedEntry{}
+	for key, f := range records {
+		if _, blocked := f.blocked(now); blocked || (all && now.Sub(f.LastFailure) <= lockoutMaxDuration) {
+			result = append(result, blockedEntry{Name: key, authFailure: *f})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LastFailure.After(result[j].LastFailure) })
+	return result
+}
+
+// authThrottled 在校验凭据之前检查用户或IP是否已被锁定，已锁定时返回429
+func authThrottled(w http.ResponseWriter, r *http.Request, user string) bool {
+	ip := requestClientIP(r)
+	d, blocked := throttle.check(user, ip)
+	if !blocked {
+		return false
+	}
+	throttle.event("blocked_attempt", user, ip, r.Method+" "+r.URL.Path)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	http.Error(w, lockoutMessage(d), http.StatusTooManyRequests)
+	return true
+}
+
+// lockoutMessage 返回锁定提示
+func lockoutMessage(d time.Duration) string {
+	return fmt.Sprintf("认证失败次数过多，请在 %s 后重试", d.Round(time.Second))
+}
+
+// setupLockout 校验暴力破解防护配置
+func setupLockout() {
+	if !lockoutEnabled() || !authEnabled() {
+		return
+	}
+	if lockoutDuration <= 0 || lockoutMaxDuration < lockoutDuration {
+		log.Fatal("-lockout-duration 必须大于0且不超过 -lockout-max")
+	}
+	fmt.Printf("✅ 暴力破解防护已启用 - 用户 %d 次、IP %d 次失败后锁定 %s (最长 %s)\n",
+		lockoutUserThreshold, lockoutIPThreshold, lockoutDuration, lockoutMaxDuration)
+}
+
+// securityAPIHandler 处理 /api/security/blocked 和 /api/security/events（仅管理员）：
+// GET /api/security/blocked 列出被锁定的用户和IP（?all=1 同时列出未锁定但有失败记录的条目），
+// DELETE /api/security/blocked?user=&ip= 解除锁定，GET /api/security/events 列出最近的安全事件
+func securityAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id := authenticatedUser(r)
+	if id == nil {
+		requestAuthentication(w, r)
+		return
+	}
+	if !isAdmin(id) {
+		http.Error(w, "需要管理员权限", http.StatusForbidden)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/security/") {
+	case "blocked":
+		switch r.Method {
+		case http.MethodGet:
+			all := r.URL.Query().Get("all") != ""
+			throttle.mu.Lock()
+			result := map[string]interface{}{
+				"users": throttle.blockedList(throttle.users, all),
+				"ips":   throttle.blockedList(throttle.ips, all),
+			}
+			throttle.mu.Unlock()
+			writeJSON(w, http.StatusOK, result)
+		case http.MethodDelete:
+			q := r.URL.Query()
+			if q.Get("user") == "" && q.Get("ip") == "" {
+				http.Error(w, "需要指定 user 或 ip", http.StatusBadRequest)
+				return
+			}
+			if !throttle.unblock(q.Get("user"), q.Get("ip"), id.Name) {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+		}
+
+	case "events":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+			return
+		}
+		throttle.mu.Lock()
+		events := make([]securityEvent, len(throttle.events))
+		for i, ev := range throttle.events {
+			// 最新的事件在前
+			events[len(events)-1-i] = ev
+		}
+		throttle.mu.Unlock()
+		writeJSON(w, http.StatusOK, events)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
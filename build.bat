@@ -3,7 +3,7 @@ SETLOCAL
 
 :: Define project name and main file
 SET PROJECT_NAME=sweb
-SET MAIN_FILE=./main.go
+SET MAIN_FILE=.
 :: NOTE: If your main.go is in a subdirectory like 'cmd/sweb/main.go',
 ::       you'd change the above to: SET MAIN_FILE=./cmd/sweb/main.go
 
@@ -0,0 +1,864 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+// CalDAV/CardDAV 相关配置
+var (
+	caldavEnabled bool
+	caldavDir     string
+)
+
+const (
+	calDAVNS  = "urn:ietf:params:xml:ns:caldav"
+	cardDAVNS = "urn:ietf:params:xml:ns:carddav"
+	calSrvNS  = "http://calendarserver.org/ns/"
+
+	// CalDAV/CardDAV 的挂载前缀
+	pimPrefix = "/dav"
+	// 所有认证用户都能访问的共享主目录
+	pimSharedOwner = "shared"
+	// 单个日历对象或联系人的大小上限
+	pimObjectMaxBytes = 10 << 20
+)
+
+// 集合树的类型，对应存储目录下的一级目录
+const (
+	pimCalendars    = "calendars"
+	pimAddressbooks = "addressbooks"
+	pimPrincipals   = "principals"
+)
+
+// pimReports /dav 支持的REPORT
+var pimReports = newDAVReportSet(pimPrefix)
+
+// pimFileSystem CalDAV/CardDAV 使用的文件系统，未启用时为nil
+var pimFileSystem webdav.FileSystem
+
+// pimProps、pimLocks CalDAV/CardDAV 的属性存储和锁，与WebDAV使用相同的实现
+var (
+	pimProps *propStore
+	pimLocks *fileLockSystem
+)
+
+// pimPath 解析后的 /dav 路径：/<类型>/<所有者>/<集合>/<对象>
+type pimPath struct {
+	kind       string
+	owner      string
+	collection string
+	object     string
+	depth      int
+}
+
+func parsePIMPath(name string) pimPath {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return pimPath{}
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, "/"), "/", 4)
+	p := pimPath{depth: len(parts), kind: parts[0]}
+	if len(parts) > 1 {
+		p.owner = parts[1]
+	}
+	if len(parts) > 2 {
+		p.collection = parts[2]
+	}
+	if len(parts) > 3 {
+		p.object = parts[3]
+	}
+	return p
+}
+
+// nested 判断路径是否位于对象之下（日历和通讯录中不允许子目录）
+func (p pimPath) nested() bool {
+	return strings.Contains(p.object, "/")
+}
+
+// pimFS 将 /dav 映射到存储目录，每个用户只能访问自己的主目录和共享主目录：
+// 只能在主目录中创建日历或通讯录集合，只能在集合中创建对象
+type pimFS struct {
+	dir webdav.Dir
+
+	created sync.Map // 已确认存在主目录的用户
+}
+
+// ensureHomes 首次访问时为用户创建主目录、默认日历和默认通讯录
+func (fs *pimFS) ensureHomes(user string) error {
+	if _, ok := fs.created.Load(user); ok {
+		return nil
+	}
+	root := string(fs.dir)
+	dirs := []string{
+		filepath.Join(root, pimPrincipals, user),
+		filepath.Join(root, pimCalendars, pimSharedOwner),
+		filepath.Join(root, pimAddressbooks, pimSharedOwner),
+	}
+	// 默认集合只在主目录首次创建时生成，用户删除后不再重建
+	if _, err := os.Stat(filepath.Join(root, pimCalendars, user)); os.IsNotExist(err) {
+		dirs = append(dirs, filepath.Join(root, pimCalendars, user, "default"))
+	}
+	if _, err := os.Stat(filepath.Join(root, pimAddressbooks, user)); os.IsNotExist(err) {
+		dirs = append(dirs, filepath.Join(root, pimAddressbooks, user, "contacts"))
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	fs.created.Store(user, true)
+	return nil
+}
+
+// check 检查当前用户能否读取或修改路径
+func (fs *pimFS) check(ctx context.Context, p pimPath, write bool) error {
+	id := contextIdentity(ctx)
+	if id == nil || !validPathSegment(id.Name) {
+		return os.ErrPermission
+	}
+	if err := fs.ensureHomes(id.Name); err != nil {
+		return err
+	}
+	if p.depth == 0 {
+		if write {
+			return os.ErrPermission
+		}
+		return nil
+	}
+	switch p.kind {
+	case pimCalendars, pimAddressbooks:
+		if p.depth > 1 && p.owner != id.Name && p.owner != pimSharedOwner {
+			return os.ErrNotExist
+		}
+	case pimPrincipals:
+		if p.depth > 2 || (p.depth == 2 && p.owner != id.Name) {
+			return os.ErrNotExist
+		}
+	default:
+		return os.ErrNotExist
+	}
+	if p.nested() {
+		return os.ErrNotExist
+	}
+	// 根目录、类型目录、主目录和主体资源不能修改
+	if write && (p.depth < 3 || p.kind == pimPrincipals) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// realPath 返回路径对应的实际文件路径
+func (fs *pimFS) realPath(ctx context.Context, name string) (string, error) {
+	return filepath.Abs(filepath.Join(string(fs.dir), filepath.FromSlash(path.Clean("/"+name))))
+}
+
+func (fs *pimFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p := parsePIMPath(name)
+	if err := fs.check(ctx, p, true); err != nil {
+		return err
+	}
+	if p.depth != 3 {
+		return os.ErrPermission
+	}
+	return fs.dir.Mkdir(ctx, name, perm)
+}
+
+func (fs *pimFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := parsePIMPath(name)
+	write := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	if err := fs.check(ctx, p, write); err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE != 0 && p.depth != 4 {
+		return nil, os.ErrPermission
+	}
+	f, err := fs.dir.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if p.depth < 2 {
+		return &pimDirFile{File: f, allow: fs.listFilter(ctx, p)}, nil
+	}
+	return f, nil
+}
+
+// listFilter 返回列出根目录和类型目录时允许出现的条目
+func (fs *pimFS) listFilter(ctx context.Context, p pimPath) func(name string) bool {
+	user := contextIdentity(ctx).Name
+	if p.depth == 0 {
+		return func(name string) bool {
+			return name == pimCalendars || name == pimAddressbooks || name == pimPrincipals
+		}
+	}
+	return func(name string) bool {
+		return name == user || (name == pimSharedOwner && p.kind != pimPrincipals)
+	}
+}
+
+func (fs *pimFS) RemoveAll(ctx context.Context, name string) error {
+	if err := fs.check(ctx, parsePIMPath(name), true); err != nil {
+		return err
+	}
+	return fs.dir.RemoveAll(ctx, name)
+}
+
+func (fs *pimFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, newPath := parsePIMPath(oldName), parsePIMPath(newName)
+	if err := fs.check(ctx, oldPath, true); err != nil {
+		return err
+	}
+	if err := fs.check(ctx, newPath, true); err != nil {
+		return err
+	}
+	// 集合只能作为集合移动，对象只能移动到同类的集合中
+	if oldPath.kind != newPath.kind || oldPath.depth != newPath.depth {
+		return os.ErrPermission
+	}
+	return fs.dir.Rename(ctx, oldName, newName)
+}
+
+func (fs *pimFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := fs.check(ctx, parsePIMPath(name), false); err != nil {
+		return nil, err
+	}
+	return fs.dir.Stat(ctx, name)
+}
+
+// pimDirFile 在列出目录时隐藏其他用户的主目录
+type pimDirFile struct {
+	webdav.File
+	allow func(name string) bool
+}
+
+func (f *pimDirFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	filtered := infos[:0]
+	for _, fi := range infos {
+		if fi.IsDir() && f.allow(fi.Name()) {
+			filtered = append(filtered, fi)
+		}
+	}
+	return filtered, err
+}
+
+// 由服务器计算、不能通过PROPPATCH修改的属性
+var (
+	davResourceTypeProp         = xml.Name{Space: "DAV:", Local: "resourcetype"}
+	davCurrentUserPrincipalProp = xml.Name{Space: "DAV:", Local: "current-user-principal"}
+	davPrincipalURLProp         = xml.Name{Space: "DAV:", Local: "principal-URL"}
+	davPrincipalCollectionProp  = xml.Name{Space: "DAV:", Local: "principal-collection-set"}
+	davPrivilegeSetProp         = xml.Name{Space: "DAV:", Local: "current-user-privilege-set"}
+	calHomeSetProp              = xml.Name{Space: calDAVNS, Local: "calendar-home-set"}
+	calComponentSetProp         = xml.Name{Space: calDAVNS, Local: "supported-calendar-component-set"}
+	calDataProp                 = xml.Name{Space: calDAVNS, Local: "calendar-data"}
+	cardHomeSetProp             = xml.Name{Space: cardDAVNS, Local: "addressbook-home-set"}
+	cardSupportedDataProp       = xml.Name{Space: cardDAVNS, Local: "supported-address-data"}
+	cardDataProp                = xml.Name{Space: cardDAVNS, Local: "address-data"}
+	calSrvCTagProp              = xml.Name{Space: calSrvNS, Local: "getctag"}
+)
+
+var pimProtectedProps = map[xml.Name]bool{
+	davResourceTypeProp: true, davCurrentUserPrincipalProp: true, davPrincipalURLProp: true,
+	davPrincipalCollectionProp: true, davPrivilegeSetProp: true, supportedReportSetProp: true,
+	calHomeSetProp: true, calComponentSetProp: true, calDataProp: true,
+	cardHomeSetProp: true, cardSupportedDataProp: true, cardDataProp: true, calSrvCTagProp: true,
+}
+
+// pimDataKey 请求上下文中的标记：REPORT生成响应时附带日历或联系人数据
+type pimDataKey struct{}
+
+// pimPropsFS 为资源提供CalDAV/CardDAV的计算属性（RFC 4791、RFC 6352、RFC 5397）
+type pimPropsFS struct {
+	webdav.FileSystem
+	realPath func(ctx context.Context, name string) (string, error)
+}
+
+func (fs *pimPropsFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	real, err := fs.realPath(ctx, name)
+	if err != nil {
+		return f, nil
+	}
+	id := contextIdentity(ctx)
+	return &pimPropsFile{
+		File: f,
+		path: parsePIMPath(name),
+		real: real,
+		user: id.Name,
+		data: ctx.Value(pimDataKey{}) != nil,
+	}, nil
+}
+
+// pimPropsFile 在底层死属性之上附加计算属性
+type pimPropsFile struct {
+	webdav.File
+	path pimPath
+	real string
+	user string
+	data bool
+}
+
+// pimHref 返回 /dav 下路径的href元素
+func pimHref(elems ...string) string {
+	u := &url.URL{Path: path.Join(append([]string{pimPrefix}, elems...)...) + "/"}
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(u.EscapedPath()))
+	return `<href xmlns="DAV:">` + buf.String() + `</href>`
+}
+
+// pimPrivileges 返回 DAV:current-user-privilege-set 的内容
+func pimPrivileges(names ...string) string {
+	var buf strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&buf, `<privilege xmlns="DAV:"><%s/></privilege>`, name)
+	}
+	return buf.String()
+}
+
+func (f *pimPropsFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props, err := innerDeadProps(f.File)
+	if err != nil {
+		return nil, err
+	}
+	set := func(name xml.Name, inner string) {
+		props[name] = webdav.Property{XMLName: name, InnerXML: []byte(inner)}
+	}
+	set(davCurrentUserPrincipalProp, pimHref(pimPrincipals, f.user))
+	set(davPrincipalCollectionProp, pimHref(pimPrincipals))
+
+	fi, err := f.File.Stat()
+	if err != nil {
+		return props, nil
+	}
+	p := f.path
+	switch {
+	case p.kind == pimPrincipals && p.depth == 2:
+		set(davResourceTypeProp, `<collection xmlns="DAV:"/><principal xmlns="DAV:"/>`)
+		set(davPrincipalURLProp, pimHref(pimPrincipals, p.owner))
+		set(calHomeSetProp, pimHref(pimCalendars, p.owner)+pimHref(pimCalendars, pimSharedOwner))
+		set(cardHomeSetProp, pimHref(pimAddressbooks, p.owner)+pimHref(pimAddressbooks, pimSharedOwner))
+		set(davPrivilegeSetProp, pimPrivileges("read"))
+		if _, ok := props[xml.Name{Space: "DAV:", Local: "displayname"}]; !ok {
+			var name bytes.Buffer
+			xml.EscapeText(&name, []byte(p.owner))
+			set(xml.Name{Space: "DAV:", Local: "displayname"}, name.String())
+		}
+	case p.depth == 2 && fi.IsDir():
+		set(davPrivilegeSetProp, pimPrivileges("read", "bind", "unbind"))
+	case p.depth == 3 && fi.IsDir():
+		set(davPrivilegeSetProp, pimPrivileges("read", "write", "write-properties", "write-content", "bind", "unbind"))
+		set(supportedReportSetProp, string(pimReports.supportedReportSet()))
+		set(calSrvCTagProp, pimCTag(f.real))
+		if p.kind == pimCalendars {
+			set(davResourceTypeProp, `<collection xmlns="DAV:"/><calendar xmlns="`+calDAVNS+`"/>`)
+			set(calComponentSetProp, `<comp xmlns="`+calDAVNS+`" name="VEVENT"/><comp xmlns="`+calDAVNS+`" name="VTODO"/><comp xmlns="`+calDAVNS+`" name="VJOURNAL"/>`)
+		} else {
+			set(davResourceTypeProp, `<collection xmlns="DAV:"/><addressbook xmlns="`+cardDAVNS+`"/>`)
+			set(cardSupportedDataProp, `<address-data-type xmlns="`+cardDAVNS+`" content-type="text/vcard" version="3.0"/><address-data-type xmlns="`+cardDAVNS+`" content-type="text/vcard" version="4.0"/>`)
+		}
+	case p.depth == 4 && !fi.IsDir() && f.data:
+		// 只在REPORT请求中附带对象内容，避免allprop的PROPFIND读取所有对象
+		data, err := os.ReadFile(f.real)
+		if err != nil {
+			return props, nil
+		}
+		var buf bytes.Buffer
+		xml.EscapeText(&buf, data)
+		if p.kind == pimCalendars {
+			set(calDataProp, buf.String())
+		} else {
+			set(cardDataProp, buf.String())
+		}
+	}
+	return props, nil
+}
+
+// Patch 拒绝修改计算属性，其余属性交给底层保存
+func (f *pimPropsFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return patchWithProtected(f.File, patches, func(name xml.Name) bool { return pimProtectedProps[name] })
+}
+
+// pimCTag 根据集合中对象的名称、大小和修改时间计算集合标签（CS:getctag），
+// 客户端据此判断集合是否有变化
+func pimCTag(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	h := sha1.New()
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// pimMiddleware 要求认证用户，处理MKCALENDAR、扩展MKCOL（RFC 5689）和对象写入的校验，
+// 并在OPTIONS响应中声明CalDAV和CardDAV能力
+func pimMiddleware(next http.Handler) http.Handler {
+	inner := webdavACLMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "OPTIONS":
+			rw := &davHeaderWriter{ResponseWriter: w, fix: func(h http.Header) {
+				if dav := h.Get("DAV"); dav != "" {
+					h.Set("DAV", dav+", calendar-access, addressbook")
+				}
+				if allow := h.Get("Allow"); strings.Contains(allow, "MKCOL") {
+					h.Set("Allow", allow+", MKCALENDAR")
+				}
+			}}
+			next.ServeHTTP(rw, r)
+			rw.apply()
+		case "MKCALENDAR":
+			pimMakeCollection(w, r, pimCalendars)
+		case "MKCOL":
+			if r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			pimMakeCollection(w, r, pimAddressbooks)
+		case "PUT":
+			if pimCheckPrecondition(w, r) && pimCheckObject(w, r) {
+				next.ServeHTTP(w, r)
+			}
+		case "DELETE":
+			if pimCheckPrecondition(w, r) {
+				next.ServeHTTP(w, r)
+			}
+		default:
+			next.ServeHTTP(w, r)
+		}
+	}))
+	return requireAuth("webdav", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 日历和通讯录按用户划分，匿名访问和分享链接都不适用
+		if authenticatedUser(r) == nil {
+			requestAuthentication(w, r)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	}))
+}
+
+// pimCheckPrecondition 处理PUT和DELETE请求的If-Match和If-None-Match条件（RFC 7232），
+// 客户端据此避免覆盖在其他设备上修改过的对象；返回false时已写入412响应
+func pimCheckPrecondition(w http.ResponseWriter, r *http.Request) bool {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return true
+	}
+	etag := ""
+	fi, err := pimFileSystem.Stat(r.Context(), pimReports.name(r.URL.Path))
+	switch {
+	case err == nil:
+		if !fi.IsDir() {
+			etag = pimETag(fi)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		// 其他错误（如无权访问）由WebDAV处理器返回
+		return true
+	}
+	if (ifMatch != "" && !etagListMatch(ifMatch, etag)) || (ifNoneMatch != "" && etagListMatch(ifNoneMatch, etag)) {
+		http.Error(w, "资源已被修改", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// pimETag 返回与WebDAV处理器在GET、PUT响应和getetag属性中相同的ETag
+func pimETag(fi os.FileInfo) string {
+	return fmt.Sprintf(`"%x%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+// etagListMatch 判断If-Match或If-None-Match头部的ETag列表是否与资源的ETag匹配，
+// etag为空表示资源不存在，此时包括"*"在内都不匹配
+func etagListMatch(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// pimCheckObject 检查PUT的内容是否为有效的日历对象或联系人，并放回请求体；
+// 返回false时已写入错误响应
+func pimCheckObject(w http.ResponseWriter, r *http.Request) bool {
+	p := parsePIMPath(pimReports.name(r.URL.Path))
+	if p.depth != 4 || p.kind == pimPrincipals || p.nested() {
+		http.Error(w, "只能在日历或通讯录集合中创建对象", http.StatusForbidden)
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, pimObjectMaxBytes+1))
+	if err != nil {
+		http.Error(w, "无法读取请求", http.StatusBadRequest)
+		return false
+	}
+	if len(body) > pimObjectMaxBytes {
+		http.Error(w, "对象过大", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	root, err := parseICal(body)
+	switch {
+	case p.kind == pimCalendars && (err != nil || root.Name != "VCALENDAR" || len(root.Children) == 0):
+		writeDAVError(w, http.StatusForbidden, "valid-calendar-data", calDAVNS)
+		return false
+	case p.kind == pimAddressbooks && (err != nil || root.Name != "VCARD"):
+		writeDAVError(w, http.StatusForbidden, "valid-address-data", cardDAVNS)
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return true
+}
+
+// pimMakeCollection 创建日历（MKCALENDAR，RFC 4791 5.3.1节）或通讯录（扩展MKCOL），
+// 并保存请求体中设置的属性（如显示名称、描述、颜色）
+func pimMakeCollection(w http.ResponseWriter, r *http.Request, kind string) {
+	name := pimReports.name(r.URL.Path)
+	p := parsePIMPath(name)
+	if p.kind != kind || p.depth != 3 {
+		if kind == pimCalendars {
+			writeDAVError(w, http.StatusForbidden, "calendar-collection-location-ok", calDAVNS)
+		} else {
+			http.Error(w, "只能在通讯录主目录中创建通讯录", http.StatusForbidden)
+		}
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, davReportMaxBytes+1))
+	if err != nil || len(body) > davReportMaxBytes {
+		http.Error(w, "无法读取请求", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Set []struct {
+			Prop struct {
+				Props []struct {
+					XMLName  xml.Name
+					InnerXML []byte `xml:",innerxml"`
+				} `xml:",any"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: set"`
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	if err := pimFileSystem.Mkdir(ctx, name, 0755); err != nil {
+		switch {
+		case errors.Is(err, os.ErrExist):
+			writeDAVError(w, http.StatusMethodNotAllowed, "resource-must-be-null", "")
+		case errors.Is(err, os.ErrNotExist):
+			http.Error(w, "上级目录不存在", http.StatusConflict)
+		default:
+			http.Error(w, "无法创建集合", http.StatusForbidden)
+		}
+		return
+	}
+
+	var props []webdav.Property
+	for _, set := range req.Set {
+		for _, p := range set.Prop.Props {
+			// 资源类型和组件集合由集合所在位置决定
+			if !pimProtectedProps[p.XMLName] {
+				props = append(props, webdav.Property{XMLName: p.XMLName, InnerXML: p.InnerXML})
+			}
+		}
+	}
+	if len(props) > 0 {
+		if f, err := pimFileSystem.OpenFile(ctx, name, os.O_RDONLY, 0); err == nil {
+			if holder, ok := f.(webdav.DeadPropsHolder); ok {
+				holder.Patch([]webdav.Proppatch{{Props: props}})
+			}
+			f.Close()
+		}
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// pimMultigetRequest calendar-multiget 和 addressbook-multiget 请求
+type pimMultigetRequest struct {
+	Hrefs []string `xml:"DAV: href"`
+}
+
+// calendarQueryRequest calendar-query 请求（RFC 4791 7.8节）
+type calendarQueryRequest struct {
+	Filter struct {
+		CompFilter *calCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+// addressbookQueryRequest addressbook-query 请求（RFC 6352 8.6节）
+type addressbookQueryRequest struct {
+	Filter struct {
+		Test        string          `xml:"test,attr"`
+		PropFilters []davPropFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+	} `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Limit *struct {
+		NResults string `xml:"urn:ietf:params:xml:ns:carddav nresults"`
+	} `xml:"urn:ietf:params:xml:ns:carddav limit"`
+}
+
+// pimReportProps 返回REPORT请求的属性，未指定时只返回ETag
+func pimReportProps(w http.ResponseWriter, body []byte) ([]xml.Name, bool) {
+	props, err := davPropNames(body)
+	if err != nil {
+		http.Error(w, "无效的REPORT请求", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(props) == 0 {
+		props = []xml.Name{{Space: "DAV:", Local: "getetag"}}
+	}
+	return props, true
+}
+
+// pimMultiget 处理 calendar-multiget 和 addressbook-multiget，返回请求中列出的对象
+func pimMultiget(rs *davReportSet, w http.ResponseWriter, r *http.Request, body []byte) {
+	var req pimMultigetRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		http.Error(w, "无效的multiget请求", http.StatusBadRequest)
+		return
+	}
+	props, ok := pimReportProps(w, body)
+	if !ok {
+		return
+	}
+	sub := r.WithContext(context.WithValue(r.Context(), pimDataKey{}, true))
+	var buf bytes.Buffer
+	for _, href := range req.Hrefs {
+		u, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			continue
+		}
+		p := path.Clean(u.Path)
+		if strings.HasPrefix(p, rs.prefix+"/") && aclAllowed(r, p, aclRead) {
+			if resp, ok := rs.propResponse(sub, p, props); ok {
+				buf.Write(resp)
+				continue
+			}
+		}
+		buf.Write(davStatusResponse(p, http.StatusNotFound))
+	}
+	writeMultistatus(w, buf.Bytes())
+}
+
+// pimQuery 在请求的集合（或单个对象）中查找满足条件的对象并返回其属性；
+// limit 大于0时最多返回limit个结果
+func pimQuery(rs *davReportSet, w http.ResponseWriter, r *http.Request, body []byte, match func(*icalComponent) bool, limit int) {
+	props, ok := pimReportProps(w, body)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	name := rs.name(r.URL.Path)
+	fi, err := rs.fs.Stat(ctx, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	names := []string{name}
+	if fi.IsDir() {
+		names = nil
+		f, err := rs.fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		infos, _ := f.Readdir(0)
+		f.Close()
+		for _, info := range infos {
+			if !info.IsDir() {
+				names = append(names, path.Join(name, info.Name()))
+			}
+		}
+		sort.Strings(names)
+	}
+
+	sub := r.WithContext(context.WithValue(ctx, pimDataKey{}, true))
+	var buf bytes.Buffer
+	matched, truncated := 0, false
+	for _, n := range names {
+		f, err := rs.fs.OpenFile(ctx, n, os.O_RDONLY, 0)
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(f, pimObjectMaxBytes))
+		f.Close()
+		if err != nil {
+			continue
+		}
+		root, err := parseICal(data)
+		if err != nil || !match(root) {
+			continue
+		}
+		if limit > 0 && matched == limit {
+			truncated = true
+			break
+		}
+		href := path.Join(rs.prefix, n)
+		if resp, ok := rs.propResponse(sub, href, props); ok {
+			buf.Write(resp)
+			matched++
+		}
+	}
+	if truncated {
+		buf.WriteString("<D:response><D:href>")
+		xml.EscapeText(&buf, []byte((&url.URL{Path: path.Join(rs.prefix, name) + "/"}).EscapedPath()))
+		buf.WriteString("</D:href><D:status>HTTP/1.1 507 Insufficient Storage</D:status><D:error><D:number-of-matches-within-limits/></D:error></D:response>")
+	}
+	writeMultistatus(w, buf.Bytes())
+}
+
+// calendarQueryReport 处理 calendar-query，支持 comp-filter、prop-filter、
+// param-filter、text-match 和 time-range 过滤；calendar-data 总是返回完整对象
+func calendarQueryReport(rs *davReportSet, w http.ResponseWriter, r *http.Request, body []byte) {
+	var req calendarQueryRequest
+	if err := xml.Unmarshal(body, &req); err != nil || req.Filter.CompFilter == nil {
+		writeDAVError(w, http.StatusForbidden, "valid-filter", calDAVNS)
+		return
+	}
+	filter := req.Filter.CompFilter
+	pimQuery(rs, w, r, body, func(c *icalComponent) bool {
+		if c.Name != strings.ToUpper(filter.Name) {
+			return false
+		}
+		return filter.IsNotDefined == nil && filter.match(c)
+	}, 0)
+}
+
+// addressbookQueryReport 处理 addressbook-query，支持 prop-filter、param-filter、text-match 和 limit
+func addressbookQueryReport(rs *davReportSet, w http.ResponseWriter, r *http.Request, body []byte) {
+	var req addressbookQueryRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		writeDAVError(w, http.StatusForbidden, "valid-filter", cardDAVNS)
+		return
+	}
+	limit := 0
+	if req.Limit != nil {
+		n, err := strconv.Atoi(strings.TrimSpace(req.Limit.NResults))
+		if err != nil || n <= 0 {
+			http.Error(w, "无效的nresults", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	filters := req.Filter.PropFilters
+	pimQuery(rs, w, r, body, func(c *icalComponent) bool {
+		if c.Name != "VCARD" {
+			return false
+		}
+		results := make([]bool, len(filters))
+		for i := range filters {
+			results[i] = filters[i].match(c, "anyof")
+		}
+		return combineTests(results, req.Filter.Test, "anyof")
+	}, limit)
+}
+
+// wellKnownHandler 将CalDAV/CardDAV的服务发现地址（RFC 6764）重定向到 /dav/
+func wellKnownHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, pimPrefix+"/", http.StatusMovedPermanently)
+}
+
+// setupCalDAV 启用CalDAV和CardDAV服务：日历和通讯录保存在存储目录中，
+// 与WebDAV共用认证、访问控制、锁和属性存储的实现
+func setupCalDAV(webDir string) {
+	if !caldavEnabled {
+		return
+	}
+	if !authEnabled() {
+		log.Fatalf("CalDAV/CardDAV需要启用认证 (例如 -htpasswd)")
+	}
+	refuseInStaticDir(caldavDir, "日历和通讯录目录", webDir, "-caldav-dir")
+	for _, kind := range []string{pimCalendars, pimAddressbooks, pimPrincipals} {
+		if err := os.MkdirAll(filepath.Join(caldavDir, kind), 0755); err != nil {
+			log.Fatalf("无法创建CalDAV目录: %v", err)
+		}
+	}
+	mime.AddExtensionType(".ics", "text/calendar; charset=utf-8")
+	mime.AddExtensionType(".vcf", "text/vcard; charset=utf-8")
+
+	ps, err := newPropStore(dataPath("caldav-props.journal"))
+	if err != nil {
+		log.Fatalf("无法加载CalDAV属性: %v", err)
+	}
+	ls, err := newFileLockSystem(dataPath("caldav-locks.journal"))
+	if err != nil {
+		log.Fatalf("无法加载CalDAV锁: %v", err)
+	}
+	pimProps, pimLocks = ps, ls
+
+	base := &pimFS{dir: webdav.Dir(caldavDir)}
+	var fs webdav.FileSystem = base
+	if aclEnabled() {
+		fs = &aclFS{FileSystem: fs, prefix: pimPrefix}
+	}
+	fs = &propsFS{FileSystem: fs, store: ps, realPath: base.realPath}
+	pimFileSystem = &pimPropsFS{FileSystem: fs, realPath: base.realPath}
+
+	handler := &webdav.Handler{
+		Prefix:     pimPrefix,
+		FileSystem: pimFileSystem,
+		LockSystem: ls,
+		Logger:     webdavLogger,
+	}
+	pimReports.fs, pimReports.handler = pimFileSystem, handler
+	pimReports.reports[xml.Name{Space: calDAVNS, Local: "calendar-query"}] = calendarQueryReport
+	pimReports.reports[xml.Name{Space: calDAVNS, Local: "calendar-multiget"}] = pimMultiget
+	pimReports.reports[xml.Name{Space: cardDAVNS, Local: "addressbook-query"}] = addressbookQueryReport
+	pimReports.reports[xml.Name{Space: cardDAVNS, Local: "addressbook-multiget"}] = pimMultiget
+
+	dav := pimMiddleware(pimReports.middleware(handler))
+	http.Handle(pimPrefix+"/", dav)
+	http.Handle(pimPrefix, dav)
+	http.HandleFunc("/.well-known/caldav", wellKnownHandler)
+	http.HandleFunc("/.well-known/carddav", wellKnownHandler)
+	fmt.Printf("✅ CalDAV/CardDAV服务已启用: %s/ - 目录: %s\n", pimPrefix, caldavDir)
+}
+
+// caldavStatus 返回状态API中的CalDAV/CardDAV信息
+func caldavStatus() map[string]interface{} {
+	status := map[string]interface{}{"enabled": caldavEnabled}
+	if !caldavEnabled {
+		return status
+	}
+	status["directory"] = caldavDir
+	status["url"] = pimPrefix + "/"
+	status["properties"] = pimProps.count()
+	status["locks"] = pimLocks.count()
+	return status
+}
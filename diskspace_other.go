@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "errors"
+
+// diskSpace 当前平台不支持查询磁盘空间
+func diskSpace(path string) (free, used uint64, err error) {
+	return 0, 0, errors.New("当前平台不支持查询磁盘空间")
+}
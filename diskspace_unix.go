@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "syscall"
+
+// diskSpace 返回路径所在文件系统的可用空间和已用空间（字节）
+func diskSpace(path string) (free, used uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(st.Bsize)
+	return uint64(st.Bavail) * bsize, (uint64(st.Blocks) - uint64(st.Bfree)) * bsize, nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 投递箱相关配置
+var (
+	dropboxEnabled     bool
+	dropboxDir         string
+	dropboxMaxSizeSpec string
+)
+
+// dropboxMaxBytes 每次投递请求的最大字节数，0表示不限制
+var dropboxMaxBytes int64
+
+// dropboxHiddenPrefixes 位于静态文件或WebDAV目录中的投递箱对应的URL路径，禁止访问
+var dropboxHiddenPrefixes []string
+
+// dropboxNoteLimit 留言的最大长度（字节）
+const dropboxNoteLimit = 64 << 10
+
+// uploadMemoryLimit 解析上传表单时保存在内存中的最大字节数，超出部分写入临时文件
+const uploadMemoryLimit = 32 << 20
+
+// flagPassed 判断命令行中是否显式指定了参数
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// setupDropbox 创建投递箱目录，并在投递箱位于其他服务目录中时禁止通过URL访问
+func setupDropbox(webDir string) {
+	if !dropboxEnabled {
+		return
+	}
+	if err := os.MkdirAll(dropboxDir, 0700); err != nil {
+		log.Fatalf("无法创建投递箱目录: %v", err)
+	}
+	if dropboxMaxSizeSpec != "" && dropboxMaxSizeSpec != "0" {
+		n, err := parseByteSize(dropboxMaxSizeSpec)
+		if err != nil {
+			log.Fatalf("无效的 -dropbox-max-size: %v", err)
+		}
+		dropboxMaxBytes = n
+	}
+	uploadEnabled = true
+	if !flagPassed("auth-upload") {
+		// 投递箱面向外部人员，默认允许匿名上传
+		authUpload = authNone
+	}
+
+	mounts := map[string]string{"/": webDir}
+	if webdavEnabled {
+		mounts["/webdav"] = webdavDir
+	}
+	box, err := filepath.Abs(dropboxDir)
+	if err != nil {
+		log.Fatalf("无法解析投递箱目录: %v", err)
+	}
+	for prefix, dir := range mounts {
+		root, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, box); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			dropboxHiddenPrefixes = append(dropboxHiddenPrefixes, path.Join(prefix, filepath.ToSlash(rel)))
+		}
+	}
+	fmt.Printf("✅ 投递箱模式已启用 - 目录: %s (上传认证要求: %s)\n", dropboxDir, authUpload)
+}
+
+// dropboxHidden 判断URL路径是否指向投递箱目录
+func dropboxHidden(p string) bool {
+	p = path.Clean("/" + p)
+	for _, prefix := range dropboxHiddenPrefixes {
+		if pathWithin(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropboxGuard 禁止通过静态文件或WebDAV访问投递箱目录，包括作为COPY和MOVE的目标；
+// WebDAV文件系统另外隐藏投递箱目录，使其不出现在目录列表、搜索和同步报告中
+func dropboxGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dest := ""
+		if d := r.Header.Get("Destination"); d != "" {
+			if u, err := url.Parse(d); err == nil {
+				dest = u.Path
+			}
+		}
+		if dropboxHidden(r.URL.Path) || (dest != "" && dropboxHidden(dest)) {
+			http.Error(w, "禁止访问投递箱目录", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newReceiptID 生成投递回执编号，形如 20260102-150405-1a2b3c
+func newReceiptID() string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// dropboxFile 投递的单个文件
+type dropboxFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// dropboxReceipt 投递记录，保存为投递目录中的 receipt.json
+type dropboxReceipt struct {
+	Receipt  string        `json:"receipt"`
+	Time     time.Time     `json:"time"`
+	ClientIP string        `json:"client_ip"`
+	User     string        `json:"user,omitempty"`
+	Note     string        `json:"note,omitempty"`
+	Files    []dropboxFile `json:"files"`
+}
+
+var dropboxTemplate = template.Must(template.New("dropbox").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{if .Receipt}}投递成功{{else}}文件投递{{end}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 640px; margin: 40px auto; padding: 0 20px; color: #333; }
+        .box { background: #f8f9fa; border: 1px solid #dee2e6; border-radius: 8px; padding: 24px; }
+        .receipt { font-family: monospace; font-size: 1.3em; background: #fff; padding: 8px 12px; border: 1px dashed #999; }
+        textarea { width: 100%; height: 100px; }
+    </style>
+</head>
+<body>
+<div class="box">
+{{if .Receipt}}
+    <h2>✅ 投递成功</h2>
+    <p>回执编号：</p>
+    <p class="receipt">{{.Receipt.Receipt}}</p>
+    <p>请保存此编号，以便与接收方核对。已投递的文件：</p>
+    <ul>{{range .Receipt.Files}}<li>{{.Name}} ({{.Size}} 字节)</li>{{end}}</ul>
+    <p><a href="/upload">继续投递</a></p>
+{{else}}
+    <h2>📥 文件投递</h2>
+    <p>上传的文件只有服务器管理员可以查看。</p>
+    <form method="post" enctype="multipart/form-data">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <p><input type="file" name="file" multiple required></p>
+        <p>留言（可选）：</p>
+        <p><textarea name="note"></textarea></p>
+        <p><input type="submit" value="投递"></p>
+    </form>
+{{end}}
+</div>
+</body>
+</html>
+`))
+
+// dropboxSizeLimit 按 -dropbox-max-size 限制投递请求的大小。表单在CSRF校验读取令牌之前
+// 在限制下解析，超出部分不会写入临时文件，超出时返回413
+func dropboxSizeLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || dropboxMaxBytes <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tooLarge := func() {
+			http.Error(w, fmt.Sprintf("投递内容超过大小限制 (最大 %s)", formatByteSize(dropboxMaxBytes)), http.StatusRequestEntityTooLarge)
+		}
+		if r.ContentLength > dropboxMaxBytes {
+			tooLarge()
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, dropboxMaxBytes)
+		if err := r.ParseMultipartForm(uploadMemoryLimit); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				tooLarge()
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// dropboxHandler 处理投递箱模式下的 /upload：只能投递，不能查看已上传的内容
+func dropboxHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		dropboxTemplate.Execute(w, map[string]interface{}{"CSRF": csrfToken(w, r)})
+	case http.MethodPost:
+		if !aclAllowed(r, "/upload", aclWrite) {
+			aclDenied(w, r, "/upload")
+			return
+		}
+		receipt, err := saveDropboxSubmission(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("投递箱收到投递 %s: %d 个文件，来自 %s", receipt.Receipt, len(receipt.Files), receipt.ClientIP)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		dropboxTemplate.Execute(w, map[string]interface{}{"Receipt": receipt})
+	default:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}
+
+// saveDropboxSubmission 将一次投递的文件和留言保存到独立的投递目录
+func saveDropboxSubmission(r *http.Request) (*dropboxReceipt, error) {
+	if err := r.ParseMultipartForm(uploadMemoryLimit); err != nil {
+		return nil, fmt.Errorf("无法读取上传内容: %v", err)
+	}
+	note := strings.TrimSpace(r.FormValue("note"))
+	if len(note) > dropboxNoteLimit {
+		return nil, fmt.Errorf("留言过长")
+	}
+	receipt := &dropboxReceipt{Receipt: newReceiptID(), Time: time.Now(), ClientIP: requestClientIP(r), Note: note}
+	if id := requestIdentity(r); id != nil {
+		receipt.User = id.Name
+	}
+	dir := filepath.Join(dropboxDir, receipt.Receipt)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return nil, fmt.Errorf("无法创建投递目录")
+	}
+	fail := func(err error) (*dropboxReceipt, error) {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	for _, header := range r.MultipartForm.File["file"] {
+		name := filepath.Base(strings.ReplaceAll(header.Filename, "\\", "/"))
+		if !validPathSegment(name) || name == "receipt.json" || name == "note.txt" {
+			name = "file-" + fmt.Sprint(len(receipt.Files)+1)
+		}
+		for n := 1; ; n++ {
+			if _, err := os.Lstat(filepath.Join(dir, name)); os.IsNotExist(err) {
+				break
+			}
+			ext := filepath.Ext(name)
+			name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+		}
+		src, err := header.Open()
+		if err != nil {
+			return fail(fmt.Errorf("无法读取上传内容: %v", err))
+		}
+		dst, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+		if err != nil {
+			src.Close()
+			return fail(fmt.Errorf("无法保存文件"))
+		}
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(dst, hash), src)
+		dst.Close()
+		src.Close()
+		if err != nil {
+			return fail(fmt.Errorf("无法保存文件: %v", err))
+		}
+		receipt.Files = append(receipt.Files, dropboxFile{Name: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))})
+	}
+
+	if len(receipt.Files) == 0 {
+		return fail(fmt.Errorf("请至少选择一个文件"))
+	}
+	if receipt.Note != "" {
+		if err := os.WriteFile(filepath.Join(dir, "note.txt"), []byte(receipt.Note+"\n"), 0600); err != nil {
+			return fail(fmt.Errorf("无法保存留言"))
+		}
+	}
+	if err := writeJSONFile(filepath.Join(dir, "receipt.json"), receipt); err != nil {
+		return fail(fmt.Errorf("无法保存投递记录"))
+	}
+	recordSyncChange(dir, true)
+	return receipt, nil
+}
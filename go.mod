@@ -2,4 +2,9 @@ module sweb
 
 go 1.24.4
 
-require golang.org/x/net v0.41.0
+require (
+	golang.org/x/crypto v0.39.0
+	golang.org/x/net v0.41.0
+)
+
+require golang.org/x/text v0.26.0 // indirect
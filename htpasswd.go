@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswdFile 兼容Apache的htpasswd用户文件，文件变更后自动重新加载
+type htpasswdFile struct {
+	path string
+
+	mu        sync.Mutex
+	users     map[string]string
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// htpasswdCheckInterval 两次检查htpasswd文件是否变更的最小间隔
+const htpasswdCheckInterval = 2 * time.Second
+
+func loadHtpasswdFile(path string) (*htpasswdFile, error) {
+	h := &htpasswdFile{path: path}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *htpasswdFile) reload() error {
+	fi, err := os.Stat(h.path)
+	if err != nil {
+		return err
+	}
+	users, err := readHtpasswd(h.path)
+	if err != nil {
+		return err
+	}
+	h.users = users
+	h.modTime = fi.ModTime()
+	return nil
+}
+
+// lookup 返回用户的密码哈希，必要时先重新加载文件
+func (h *htpasswdFile) lookup(user string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.lastCheck) >= htpasswdCheckInterval {
+		h.lastCheck = now
+		if fi, err := os.Stat(h.path); err == nil && !fi.ModTime().Equal(h.modTime) {
+			if err := h.reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "重新加载htpasswd文件失败，继续使用旧数据: %v\n", err)
+			}
+		}
+	}
+	hash, ok := h.users[user]
+	return hash, ok
+}
+
+// verify 验证用户名和密码
+func (h *htpasswdFile) verify(user, password string) bool {
+	hash, ok := h.lookup(user)
+	if !ok {
+		// 对不存在的用户同样执行一次哈希计算，避免通过响应时间枚举用户
+		verifyPasswordHash(dummyPasswordHash, password)
+		return false
+	}
+	return verifyPasswordHash(hash, password)
+}
+
+// exists 判断用户是否存在于htpasswd文件中
+func (h *htpasswdFile) exists(user string) bool {
+	if h == nil {
+		return false
+	}
+	_, ok := h.lookup(user)
+	return ok
+}
+
+// dummyPasswordHash 用于不存在的用户，使验证耗时与真实用户一致
+var dummyPasswordHash, _ = hashPassword("bcrypt", "sweb-dummy-password")
+
+// readHtpasswd 解析htpasswd文件，返回用户名到密码哈希的映射
+func readHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+// maxPasswordLength 密码的最大字节数。SHA-crypt的计算量随密码长度的平方增长，
+// 校验前拒绝过长的密码，避免认证请求消耗大量CPU
+const maxPasswordLength = 256
+
+// verifyPasswordHash 校验密码是否与htpasswd中的哈希匹配
+//
+// 支持bcrypt ($2y$)、SHA-crypt ($5$/$6$)、APR1 ($apr1$) 和 {SHA} 格式。
+func verifyPasswordHash(hash, password string) bool {
+	if len(password) > maxPasswordLength {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$5$"), strings.HasPrefix(hash, "$6$"):
+		computed, err := shaCrypt(hash[:3], password, hash)
+		return err == nil && subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "$apr1$"):
+		computed := apr1Crypt(password, hash)
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	}
+	return false
+}
+
+// hashPassword 按指定算法生成htpasswd格式的密码哈希
+func hashPassword(algo, password string) (string, error) {
+	if len(password) > maxPasswordLength {
+		return "", fmt.Errorf("密码过长 (最多 %d 字节)", maxPasswordLength)
+	}
+	switch algo {
+	case "bcrypt":
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	case "sha256":
+		return shaCrypt("$5$", password, "$5$"+randomCryptSalt(16))
+	case "sha512":
+		return shaCrypt("$6$", password, "$6$"+randomCryptSalt(16))
+	case "apr1":
+		return apr1Crypt(password, "$apr1$"+randomCryptSalt(8)), nil
+	}
+	return "", fmt.Errorf("不支持的哈希算法: %s (可选: bcrypt, sha256, sha512, apr1)", algo)
+}
+
+// cryptAlphabet crypt(3)使用的base64字母表
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func randomCryptSalt(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = cryptAlphabet[int(b[i])%len(cryptAlphabet)]
+	}
+	return string(b)
+}
+
+// cryptEncode 将三个字节按crypt(3)规则编码为n个字符
+func cryptEncode(buf *bytes.Buffer, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		buf.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
+
+// apr1Crypt 实现Apache的APR1 (MD5-crypt变体) 算法，setting为 "$apr1$salt[$...]"
+func apr1Crypt(password, setting string) string {
+	const magic = "$apr1$"
+	salt := strings.TrimPrefix(setting, magic)
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic))
+	ctx.Write([]byte(salt))
+	for pl := len(pw); pl > 0; pl -= 16 {
+		ctx.Write(altSum[:min(pl, 16)])
+	}
+	for i := len(pw); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		c := md5.New()
+		if i&1 != 0 {
+			c.Write(pw)
+		} else {
+			c.Write(final)
+		}
+		if i%3 != 0 {
+			c.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			c.Write(pw)
+		}
+		if i&1 != 0 {
+			c.Write(final)
+		} else {
+			c.Write(pw)
+		}
+		final = c.Sum(nil)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(magic + salt + "$")
+	cryptEncode(&buf, final[0], final[6], final[12], 4)
+	cryptEncode(&buf, final[1], final[7], final[13], 4)
+	cryptEncode(&buf, final[2], final[8], final[14], 4)
+	cryptEncode(&buf, final[3], final[9], final[15], 4)
+	cryptEncode(&buf, final[4], final[10], final[5], 4)
+	cryptEncode(&buf, 0, 0, final[11], 2)
+	return buf.String()
+}
+
+// SHA-crypt输出时的字节排列顺序
+var (
+	sha256CryptOrder = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512CryptOrder = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// shaCrypt 实现SHA-256/SHA-512 crypt算法，magic为 "$5$" 或 "$6$"，
+// setting为 "magic[rounds=N$]salt[$...]"
+func shaCrypt(magic, password, setting string) (string, error) {
+	var newHash func() hash.Hash
+	switch magic {
+	case "$5$":
+		newHash = sha256.New
+	case "$6$":
+		newHash = sha512.New
+	default:
+		return "", errors.New("未知的SHA-crypt类型")
+	}
+
+	rest := strings.TrimPrefix(setting, magic)
+	rounds, customRounds := 5000, false
+	if strings.HasPrefix(rest, "rounds=") {
+		value, after, ok := strings.Cut(strings.TrimPrefix(rest, "rounds="), "$")
+		if !ok {
+			return "", errors.New("SHA-crypt轮数格式错误")
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", errors.New("SHA-crypt轮数格式错误")
+		}
+		rounds = min(max(n, 1000), 999999999)
+		customRounds = true
+		rest = after
+	}
+	salt := rest
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	pw, s := []byte(password), []byte(salt)
+
+	b := newHash()
+	b.Write(pw)
+	b.Write(s)
+	b.Write(pw)
+	bSum := b.Sum(nil)
+	size := len(bSum)
+
+	a := newHash()
+	a.Write(pw)
+	a.Write(s)
+	for n := len(pw); n > 0; n -= size {
+		a.Write(bSum[:min(n, size)])
+	}
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(bSum)
+		} else {
+			a.Write(pw)
+		}
+	}
+	aSum := a.Sum(nil)
+
+	dp := newHash()
+	for range pw {
+		dp.Write(pw)
+	}
+	dpSum := dp.Sum(nil)
+	p := make([]byte, 0, len(pw))
+	for n := len(pw); n > 0; n -= size {
+		p = append(p, dpSum[:min(n, size)]...)
+	}
+
+	ds := newHash()
+	for i := 0; i < 16+int(aSum[0]); i++ {
+		ds.Write(s)
+	}
+	dsSum := ds.Sum(nil)
+	sp := make([]byte, 0, len(s))
+	for n := len(s); n > 0; n -= size {
+		sp = append(sp, dsSum[:min(n, size)]...)
+	}
+
+	c := aSum
+	for i := 0; i < rounds; i++ {
+		h := newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sp)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	if customRounds {
+		buf.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	buf.WriteString(salt + "$")
+	if magic == "$5$" {
+		for _, o := range sha256CryptOrder {
+			cryptEncode(&buf, c[o[0]], c[o[1]], c[o[2]], 4)
+		}
+		cryptEncode(&buf, 0, c[31], c[30], 3)
+	} else {
+		for _, o := range sha512CryptOrder {
+			cryptEncode(&buf, c[o[0]], c[o[1]], c[o[2]], 4)
+		}
+		cryptEncode(&buf, 0, 0, c[63], 2)
+	}
+	return buf.String(), nil
+}
+
+// runPasswdCommand 实现 "sweb passwd" 子命令，添加、更新或删除htpasswd中的用户
+func runPasswdCommand(args []string) {
+	fs := flag.NewFlagSet("passwd", flag.ExitOnError)
+	file := fs.String("f", "htpasswd", "htpasswd文件路径")
+	algo := fs.String("algo", "bcrypt", "密码哈希算法: bcrypt, sha256, sha512 或 apr1")
+	del := fs.Bool("D", false, "删除指定用户")
+	fs.Usage = func() {
+		fmt.Println("用法: sweb passwd [-f 文件] [-algo 算法] [-D] <用户名> [密码]")
+		fmt.Println("未提供密码时从标准输入读取。")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	user := fs.Arg(0)
+	if user == "" || strings.ContainsAny(user, ":\r\n") {
+		fmt.Fprintln(os.Stderr, "用户名不能为空且不能包含冒号或换行")
+		os.Exit(1)
+	}
+
+	var entry string
+	if !*del {
+		password := fs.Arg(1)
+		if fs.NArg() < 2 {
+			fmt.Fprint(os.Stderr, "请输入密码: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				fmt.Fprintln(os.Stderr, "\n无法读取密码")
+				os.Exit(1)
+			}
+			password = strings.TrimRight(line, "\r\n")
+		}
+		if password == "" {
+			fmt.Fprintln(os.Stderr, "密码不能为空")
+			os.Exit(1)
+		}
+		hash, err := hashPassword(*algo, password)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		entry = user + ":" + hash
+	}
+
+	found, err := updateHtpasswdEntry(*file, user, entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法更新htpasswd文件: %v\n", err)
+		os.Exit(1)
+	}
+	switch {
+	case *del && !found:
+		fmt.Printf("用户 %s 不存在\n", user)
+	case *del:
+		fmt.Printf("已删除用户 %s\n", user)
+	case found:
+		fmt.Printf("已更新用户 %s 的密码\n", user)
+	default:
+		fmt.Printf("已添加用户 %s\n", user)
+	}
+}
+
+// updateHtpasswdEntry 替换或追加用户条目，entry为空时删除该用户；返回用户是否已存在
+func updateHtpasswdEntry(path, user, entry string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	var lines []string
+	found := false
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if name, _, ok := strings.Cut(line, ":"); ok && name == user {
+			found = true
+			if entry != "" {
+				lines = append(lines, entry)
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !found && entry != "" {
+		lines = append(lines, entry)
+	}
+
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return found, writeFileAtomic(path, []byte(content), 0600)
+}
+
+// writeFileAtomic 先写入临时文件再重命名，避免读取方看到不完整的文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// 以下哈希由 openssl passwd -apr1/-5/-6 生成
+func TestVerifyPasswordHash(t *testing.T) {
+	tests := []struct {
+		hash     string
+		password string
+		want     bool
+	}{
+		{"$apr1$saltstri$aGfuB7Lcvs2TUeFTqUVfN0", "Hello world!", true},
+		{"$apr1$r31.....$ARC3pREO82RIm0aQ2zszC0", "password", true},
+		{"$apr1$r31.....$ARC3pREO82RIm0aQ2zszC0", "Password", false},
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!", true},
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world", false},
+		{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!", true},
+		{"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world!", true},
+		{"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "", false},
+		{"{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M=", "test", true},
+		{"{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M=", "test2", false},
+		{"plaintext", "plaintext", false},
+		{"$6$", "", false},
+	}
+	for _, tt := range tests {
+		if got := verifyPasswordHash(tt.hash, tt.password); got != tt.want {
+			t.Errorf("verifyPasswordHash(%q, %q) = %v, 期望 %v", tt.hash, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	for _, algo := range []string{"bcrypt", "sha256", "sha512", "apr1"} {
+		hash, err := hashPassword(algo, "s3cret")
+		if err != nil {
+			t.Fatalf("hashPassword(%s): %v", algo, err)
+		}
+		if !verifyPasswordHash(hash, "s3cret") || verifyPasswordHash(hash, "other") {
+			t.Errorf("%s 哈希 %q 校验结果不正确", algo, hash)
+		}
+	}
+	if _, err := hashPassword("md5", "x"); err == nil {
+		t.Error("不支持的算法应返回错误")
+	}
+}
+
+// 超过 maxPasswordLength 的密码在计算哈希前被拒绝
+func TestPasswordLengthLimit(t *testing.T) {
+	maxLen := strings.Repeat("a", maxPasswordLength)
+	tooLong := maxLen + "a"
+	for _, algo := range []string{"sha256", "sha512", "apr1"} {
+		hash, err := hashPassword(algo, maxLen)
+		if err != nil {
+			t.Fatalf("hashPassword(%s, %d字节): %v", algo, len(maxLen), err)
+		}
+		if !verifyPasswordHash(hash, maxLen) {
+			t.Errorf("%s: 最大长度的密码应能通过校验", algo)
+		}
+		if _, err := hashPassword(algo, tooLong); err == nil {
+			t.Errorf("%s: 过长的密码应返回错误", algo)
+		}
+	}
+
+	hash, _ := hashPassword("sha512", "pw")
+	start := time.Now()
+	if verifyPasswordHash(hash, strings.Repeat("x", 1<<20)) {
+		t.Error("1MB的密码不应通过校验")
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("校验过长的密码耗时 %s，应在计算哈希前拒绝", d)
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icalProp iCalendar（RFC 5545）或vCard（RFC 6350）中的一个属性
+type icalProp struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// icalComponent iCalendar或vCard中 BEGIN/END 之间的组件
+type icalComponent struct {
+	Name     string
+	Props    []icalProp
+	Children []*icalComponent
+}
+
+// parseICal 解析iCalendar或vCard数据，返回唯一的根组件
+func parseICal(data []byte) (*icalComponent, error) {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	// 展开折行：以空格或制表符开头的行是上一行的延续
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+
+	var root *icalComponent
+	var stack []*icalComponent
+	for _, line := range lines {
+		prop, ok := parseICalLine(line)
+		if !ok {
+			return nil, errors.New("无效的内容行: " + line)
+		}
+		switch prop.Name {
+		case "BEGIN":
+			c := &icalComponent{Name: strings.ToUpper(prop.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, c)
+			} else if root != nil {
+				return nil, errors.New("存在多个根组件")
+			} else {
+				root = c
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return nil, errors.New("组件未正确结束: " + prop.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, errors.New("属性不在组件中: " + prop.Name)
+			}
+			top := stack[len(stack)-1]
+			top.Props = append(top.Props, prop)
+		}
+	}
+	if root == nil || len(stack) != 0 {
+		return nil, errors.New("缺少完整的组件")
+	}
+	return root, nil
+}
+
+// parseICalLine 解析 "名称;参数=值:属性值" 格式的内容行
+func parseICalLine(line string) (icalProp, bool) {
+	// 冒号可能出现在带引号的参数值中
+	quoted, colon := false, -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon <= 0 {
+		return icalProp{}, false
+	}
+	prop := icalProp{Value: line[colon+1:]}
+	parts := splitQuoted(line[:colon], ';')
+	prop.Name = strings.ToUpper(parts[0])
+	// vCard的属性名可以带分组前缀，如 item1.EMAIL
+	if i := strings.LastIndex(prop.Name, "."); i >= 0 {
+		prop.Name = prop.Name[i+1:]
+	}
+	if prop.Name == "" {
+		return icalProp{}, false
+	}
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		if prop.Params == nil {
+			prop.Params = make(map[string]string)
+		}
+		prop.Params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+	}
+	return prop, true
+}
+
+// splitQuoted 按分隔符拆分字符串，忽略引号中的分隔符
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// text 返回去掉转义后的属性值
+func (p icalProp) text() string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(p.Value)
+}
+
+// props 返回组件中指定名称的属性
+func (c *icalComponent) props(name string) []icalProp {
+	var props []icalProp
+	for _, p := range c.Props {
+		if p.Name == strings.ToUpper(name) {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// prop 返回组件中指定名称的第一个属性
+func (c *icalComponent) prop(name string) (icalProp, bool) {
+	props := c.props(name)
+	if len(props) == 0 {
+		return icalProp{}, false
+	}
+	return props[0], true
+}
+
+// children 返回指定名称的子组件
+func (c *icalComponent) children(name string) []*icalComponent {
+	var children []*icalComponent
+	for _, child := range c.Children {
+		if child.Name == strings.ToUpper(name) {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// icalTime 解析日期或日期时间属性；浮动时间和无法识别的时区按UTC处理
+func icalTime(p icalProp) (t time.Time, allDay bool, ok bool) {
+	v := strings.TrimSpace(p.Value)
+	if strings.EqualFold(p.Params["VALUE"], "DATE") || len(v) == 8 {
+		t, err := time.Parse("20060102", v)
+		return t, true, err == nil
+	}
+	if strings.HasSuffix(v, "Z") {
+		t, err := time.Parse("20060102T150405Z", v)
+		return t, false, err == nil
+	}
+	loc := time.UTC
+	if tzid := p.Params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", v, loc)
+	return t, false, err == nil
+}
+
+// icalDuration 解析 RFC 5545 的持续时间，如 P1D、PT1H30M、-P2W
+func icalDuration(s string) (time.Duration, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	s = strings.TrimPrefix(s, "+")
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, false
+	}
+	var d time.Duration
+	num, inTime := "", false
+	for _, c := range s[1:] {
+		if c >= '0' && c <= '9' {
+			num += string(c)
+			continue
+		}
+		if c == 'T' {
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, false
+		}
+		num = ""
+		switch {
+		case c == 'W' && !inTime:
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D' && !inTime:
+			d += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, false
+		}
+	}
+	return sign * d, num == ""
+}
+
+// calTimeRange CalDAV的 time-range 过滤条件（RFC 4791 9.9节）
+type calTimeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// bounds 返回时间范围的起止时间，缺少的一端不受限制
+func (tr *calTimeRange) bounds() (start, end time.Time, ok bool) {
+	start, end = time.Unix(0, 0).AddDate(-100, 0, 0), time.Unix(0, 0).AddDate(1000, 0, 0)
+	if tr.Start != "" {
+		t, err := time.Parse("20060102T150405Z", tr.Start)
+		if err != nil {
+			return start, end, false
+		}
+		start = t
+	}
+	if tr.End != "" {
+		t, err := time.Parse("20060102T150405Z", tr.End)
+		if err != nil {
+			return start, end, false
+		}
+		end = t
+	}
+	return start, end, true
+}
+
+// overlaps 判断时间段是否与范围重叠，零长度的时间段按时间点判断
+func (tr *calTimeRange) overlaps(start, end time.Time) bool {
+	rStart, rEnd, ok := tr.bounds()
+	if !ok {
+		return false
+	}
+	if end.After(start) {
+		return start.Before(rEnd) && end.After(rStart)
+	}
+	return !start.Before(rStart) && start.Before(rEnd)
+}
+
+// matchComponent 判断组件的时间是否落在范围内。重复规则不展开：
+// 只要首次发生不晚于范围结束且重复未在范围开始前终止即视为匹配
+func (tr *calTimeRange) matchComponent(c *icalComponent) bool {
+	var start, end time.Time
+	var allDay, hasStart bool
+	if p, ok := c.prop("DTSTART"); ok {
+		start, allDay, hasStart = icalTime(p)
+	}
+	switch c.Name {
+	case "VEVENT", "VJOURNAL":
+		if !hasStart {
+			return c.Name == "VEVENT"
+		}
+		end = start
+		if p, ok := c.prop("DTEND"); ok && c.Name == "VEVENT" {
+			if t, _, ok := icalTime(p); ok {
+				end = t
+			}
+		} else if p, ok := c.prop("DURATION"); ok {
+			if d, ok := icalDuration(p.Value); ok {
+				end = start.Add(d)
+			}
+		} else if allDay {
+			end = start.Add(24 * time.Hour)
+		}
+	case "VTODO":
+		var due time.Time
+		hasDue := false
+		if p, ok := c.prop("DUE"); ok {
+			due, _, hasDue = icalTime(p)
+		}
+		switch {
+		case hasStart && hasDue:
+			end = due
+		case hasStart:
+			end = start
+			if p, ok := c.prop("DURATION"); ok {
+				if d, ok := icalDuration(p.Value); ok {
+					end = start.Add(d)
+				}
+			}
+		case hasDue:
+			start, end = due, due
+		default:
+			// 没有日期的待办事项匹配任何时间范围
+			return true
+		}
+	default:
+		// 其他组件（如VALARM、VFREEBUSY）不做时间过滤
+		return true
+	}
+
+	if p, ok := c.prop("RRULE"); ok {
+		rStart, rEnd, ok := tr.bounds()
+		if !ok || !start.Before(rEnd) {
+			return false
+		}
+		for _, part := range strings.Split(p.Value, ";") {
+			if k, v, _ := strings.Cut(part, "="); strings.EqualFold(k, "UNTIL") {
+				if until, _, ok := icalTime(icalProp{Value: v}); ok {
+					// 最后一次发生的结束时间早于范围开始
+					return until.Add(end.Sub(start)).After(rStart)
+				}
+			}
+		}
+		return true
+	}
+	return tr.overlaps(start, end)
+}
+
+// davTextMatch CalDAV/CardDAV的 text-match 过滤条件
+type davTextMatch struct {
+	Value     string `xml:",chardata"`
+	Collation string `xml:"collation,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+	MatchType string `xml:"match-type,attr"`
+}
+
+// match 判断文本是否满足条件；默认按不区分大小写的包含关系匹配
+func (tm *davTextMatch) match(value string) bool {
+	want := tm.Value
+	if tm.Collation != "i;octet" {
+		value, want = strings.ToLower(value), strings.ToLower(want)
+	}
+	var ok bool
+	switch tm.MatchType {
+	case "equals":
+		ok = value == want
+	case "starts-with":
+		ok = strings.HasPrefix(value, want)
+	case "ends-with":
+		ok = strings.HasSuffix(value, want)
+	default:
+		ok = strings.Contains(value, want)
+	}
+	if tm.Negate == "yes" {
+		return !ok
+	}
+	return ok
+}
+
+// davParamFilter 属性参数的过滤条件
+type davParamFilter struct {
+	Name         string        `xml:"name,attr"`
+	IsNotDefined *struct{}     `xml:"is-not-defined"`
+	TextMatch    *davTextMatch `xml:"text-match"`
+}
+
+func (pf *davParamFilter) match(p icalProp) bool {
+	value, ok := p.Params[strings.ToUpper(pf.Name)]
+	if pf.IsNotDefined != nil {
+		return !ok
+	}
+	if !ok {
+		return false
+	}
+	return pf.TextMatch == nil || pf.TextMatch.match(value)
+}
+
+// davPropFilter 属性的过滤条件；CalDAV中所有条件都须满足，
+// CardDAV由 test 属性决定（默认满足任一条件即可）
+type davPropFilter struct {
+	Name         string           `xml:"name,attr"`
+	Test         string           `xml:"test,attr"`
+	IsNotDefined *struct{}        `xml:"is-not-defined"`
+	TimeRange    *calTimeRange    `xml:"time-range"`
+	TextMatches  []davTextMatch   `xml:"text-match"`
+	ParamFilters []davParamFilter `xml:"param-filter"`
+}
+
+// match 判断组件的属性是否满足条件，defaultTest 为未指定 test 时的组合方式
+func (pf *davPropFilter) match(c *icalComponent, defaultTest string) bool {
+	props := c.props(pf.Name)
+	if pf.IsNotDefined != nil {
+		return len(props) == 0
+	}
+	for _, p := range props {
+		if pf.matchProp(p, defaultTest) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pf *davPropFilter) matchProp(p icalProp, defaultTest string) bool {
+	var results []bool
+	if pf.TimeRange != nil {
+		t, _, ok := icalTime(p)
+		results = append(results, ok && pf.TimeRange.overlaps(t, t))
+	}
+	for i := range pf.TextMatches {
+		results = append(results, pf.TextMatches[i].match(p.text()))
+	}
+	for i := range pf.ParamFilters {
+		results = append(results, pf.ParamFilters[i].match(p))
+	}
+	return combineTests(results, pf.Test, defaultTest)
+}
+
+// combineTests 按 anyof 或 allof 组合多个条件的结果，没有条件时视为匹配
+func combineTests(results []bool, test, defaultTest string) bool {
+	if len(results) == 0 {
+		return true
+	}
+	if test == "" {
+		test = defaultTest
+	}
+	for _, ok := range results {
+		if test == "anyof" && ok {
+			return true
+		}
+		if test != "anyof" && !ok {
+			return false
+		}
+	}
+	return test != "anyof"
+}
+
+// calCompFilter CalDAV的 comp-filter 过滤条件（RFC 4791 9.7.1节）
+type calCompFilter struct {
+	Name         string          `xml:"name,attr"`
+	IsNotDefined *struct{}       `xml:"is-not-defined"`
+	TimeRange    *calTimeRange   `xml:"time-range"`
+	PropFilters  []davPropFilter `xml:"prop-filter"`
+	CompFilters  []calCompFilter `xml:"comp-filter"`
+}
+
+// match 判断组件是否满足过滤条件，组件名称应已与过滤条件一致
+func (f *calCompFilter) match(c *icalComponent) bool {
+	if f.TimeRange != nil && !f.TimeRange.matchComponent(c) {
+		return false
+	}
+	for i := range f.PropFilters {
+		if !f.PropFilters[i].match(c, "allof") {
+			return false
+		}
+	}
+	for i := range f.CompFilters {
+		cf := &f.CompFilters[i]
+		children := c.children(cf.Name)
+		if cf.IsNotDefined != nil {
+			if len(children) > 0 {
+				return false
+			}
+			continue
+		}
+		matched := false
+		for _, child := range children {
+			if cf.match(child) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+// icalLines 用CRLF连接内容行
+func icalLines(lines ...string) []byte {
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func TestParseICal(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"日历", icalLines("BEGIN:VCALENDAR", "VERSION:2.0", "BEGIN:VEVENT", "UID:1", "END:VEVENT", "END:VCALENDAR"), false},
+		{"名片", icalLines("BEGIN:VCARD", "VERSION:4.0", "FN:张三", "END:VCARD"), false},
+		{"BOM和LF换行", []byte("\ufeffBEGIN:VCARD\nFN:A\nEND:VCARD\n"), false},
+		{"组件名不区分大小写", icalLines("BEGIN:vcard", "FN:A", "END:VCARD"), false},
+		{"空数据", nil, true},
+		{"缺少END", icalLines("BEGIN:VCALENDAR", "BEGIN:VEVENT", "END:VEVENT"), true},
+		{"END不匹配", icalLines("BEGIN:VCALENDAR", "BEGIN:VEVENT", "END:VCALENDAR", "END:VEVENT"), true},
+		{"多个根组件", icalLines("BEGIN:VCARD", "END:VCARD", "BEGIN:VCARD", "END:VCARD"), true},
+		{"属性不在组件中", icalLines("FN:A", "BEGIN:VCARD", "END:VCARD"), true},
+		{"缺少冒号", icalLines("BEGIN:VCARD", "FN", "END:VCARD"), true},
+	}
+	for _, tt := range tests {
+		_, err := parseICal(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: 错误 = %v, 期望出错 %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	root, err := parseICal(icalLines(
+		"BEGIN:VCARD",
+		"item1.EMAIL;TYPE=work:a@example.com",
+		`NOTE;X-LABEL="a:b;c":第一行\n第二`,
+		" 行\\, 续",
+		"END:VCARD",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	email, ok := root.prop("email")
+	if !ok || email.Value != "a@example.com" || email.Params["TYPE"] != "work" {
+		t.Errorf("带分组前缀的属性 = %+v, %v", email, ok)
+	}
+	note, ok := root.prop("NOTE")
+	if !ok || note.Params["X-LABEL"] != "a:b;c" || note.text() != "第一行\n第二行, 续" {
+		t.Errorf("折行和带引号的参数 = %+v, 文本 %q", note, note.text())
+	}
+}
+
+func TestICalTime(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("缺少时区数据")
+	}
+	tests := []struct {
+		name   string
+		prop   icalProp
+		want   time.Time
+		allDay bool
+		ok     bool
+	}{
+		{"UTC时间", icalProp{Value: "20240102T030405Z"}, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), false, true},
+		{"全天", icalProp{Params: map[string]string{"VALUE": "DATE"}, Value: "20240102"}, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), true, true},
+		{"八位日期", icalProp{Value: "20240102"}, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), true, true},
+		{"TZID", icalProp{Params: map[string]string{"TZID": "Asia/Shanghai"}, Value: "20240102T080000"}, time.Date(2024, 1, 2, 8, 0, 0, 0, shanghai), false, true},
+		{"未知时区按UTC", icalProp{Params: map[string]string{"TZID": "Nowhere/City"}, Value: "20240102T080000"}, time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC), false, true},
+		{"浮动时间按UTC", icalProp{Value: "20240102T080000"}, time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC), false, true},
+		{"无效值", icalProp{Value: "tomorrow"}, time.Time{}, false, false},
+	}
+	for _, tt := range tests {
+		got, allDay, ok := icalTime(tt.prop)
+		if ok != tt.ok || (ok && (!got.Equal(tt.want) || allDay != tt.allDay)) {
+			t.Errorf("%s: icalTime = %v, %v, %v, 期望 %v, %v, %v", tt.name, got, allDay, ok, tt.want, tt.allDay, tt.ok)
+		}
+	}
+}
+
+func TestICalDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"P1D", 24 * time.Hour, true},
+		{"PT1H30M", 90 * time.Minute, true},
+		{"P1DT2H", 26 * time.Hour, true},
+		{"-P2W", -14 * 24 * time.Hour, true},
+		{"+PT15S", 15 * time.Second, true},
+		{"pt5m", 5 * time.Minute, true},
+		{"P", 0, false},
+		{"PT", 0, false},
+		{"1D", 0, false},
+		{"P1H", 0, false},
+		{"PT1D", 0, false},
+		{"P1", 0, false},
+		{"PTH", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := icalDuration(tt.in)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("icalDuration(%q) = %v, %v, 期望 %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCalTimeRangeMatch(t *testing.T) {
+	// 查询范围：2024-01-10 00:00 到 2024-01-11 00:00（UTC）
+	tr := &calTimeRange{Start: "20240110T000000Z", End: "20240111T000000Z"}
+	tests := []struct {
+		name  string
+		lines []string
+		want  bool
+	}{
+		{"范围内的事件", []string{"BEGIN:VEVENT", "DTSTART:20240110T090000Z", "DTEND:20240110T100000Z", "END:VEVENT"}, true},
+		{"跨越范围开始", []string{"BEGIN:VEVENT", "DTSTART:20240109T230000Z", "DTEND:20240110T010000Z", "END:VEVENT"}, true},
+		{"在范围开始时结束", []string{"BEGIN:VEVENT", "DTSTART:20240109T230000Z", "DTEND:20240110T000000Z", "END:VEVENT"}, false},
+		{"在范围结束时开始", []string{"BEGIN:VEVENT", "DTSTART:20240111T000000Z", "DTEND:20240111T010000Z", "END:VEVENT"}, false},
+		{"按持续时间计算结束", []string{"BEGIN:VEVENT", "DTSTART:20240109T230000Z", "DURATION:PT2H", "END:VEVENT"}, true},
+		{"全天事件", []string{"BEGIN:VEVENT", "DTSTART;VALUE=DATE:20240110", "END:VEVENT"}, true},
+		{"前一天的全天事件", []string{"BEGIN:VEVENT", "DTSTART;VALUE=DATE:20240109", "END:VEVENT"}, false},
+		{"零长度事件在范围开始", []string{"BEGIN:VEVENT", "DTSTART:20240110T000000Z", "END:VEVENT"}, true},
+		{"没有开始时间的事件", []string{"BEGIN:VEVENT", "SUMMARY:x", "END:VEVENT"}, true},
+		{"没有开始时间的日志", []string{"BEGIN:VJOURNAL", "SUMMARY:x", "END:VJOURNAL"}, false},
+		{"无限重复", []string{"BEGIN:VEVENT", "DTSTART:20240101T090000Z", "DTEND:20240101T100000Z", "RRULE:FREQ=DAILY", "END:VEVENT"}, true},
+		{"重复在范围前终止", []string{"BEGIN:VEVENT", "DTSTART:20240101T090000Z", "DTEND:20240101T100000Z", "RRULE:FREQ=DAILY;UNTIL=20240105T090000Z", "END:VEVENT"}, false},
+		{"重复在范围内终止", []string{"BEGIN:VEVENT", "DTSTART:20240101T090000Z", "DTEND:20240101T100000Z", "RRULE:FREQ=DAILY;UNTIL=20240110T090000Z", "END:VEVENT"}, true},
+		{"重复在范围后开始", []string{"BEGIN:VEVENT", "DTSTART:20240111T090000Z", "RRULE:FREQ=DAILY", "END:VEVENT"}, false},
+		{"有截止时间的待办", []string{"BEGIN:VTODO", "DUE:20240110T120000Z", "END:VTODO"}, true},
+		{"截止时间在范围后的待办", []string{"BEGIN:VTODO", "DUE:20240112T120000Z", "END:VTODO"}, false},
+		{"开始到截止跨越范围的待办", []string{"BEGIN:VTODO", "DTSTART:20240101T000000Z", "DUE:20240120T000000Z", "END:VTODO"}, true},
+		{"没有日期的待办", []string{"BEGIN:VTODO", "SUMMARY:x", "END:VTODO"}, true},
+		{"提醒不做时间过滤", []string{"BEGIN:VALARM", "TRIGGER:-PT15M", "END:VALARM"}, true},
+	}
+	for _, tt := range tests {
+		c, err := parseICal(icalLines(tt.lines...))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := tr.matchComponent(c); got != tt.want {
+			t.Errorf("%s: matchComponent = %v, 期望 %v", tt.name, got, tt.want)
+		}
+	}
+
+	if (&calTimeRange{Start: "2024-01-10"}).matchComponent(&icalComponent{Name: "VEVENT", Props: []icalProp{{Name: "DTSTART", Value: "20240110T000000Z"}}}) {
+		t.Error("无效的时间范围不应匹配")
+	}
+}
+
+func TestCalCompFilter(t *testing.T) {
+	cal, err := parseICal(icalLines(
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:1",
+		"SUMMARY:项目周会",
+		"DTSTART:20240110T090000Z",
+		"DTEND:20240110T100000Z",
+		"BEGIN:VALARM",
+		"TRIGGER:-PT15M",
+		"END:VALARM",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		filter string
+		want   bool
+	}{
+		{"只按组件过滤", `<C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"/></C:comp-filter>`, true},
+		{"没有该组件", `<C:comp-filter name="VCALENDAR"><C:comp-filter name="VTODO"/></C:comp-filter>`, false},
+		{"组件不存在条件", `<C:comp-filter name="VCALENDAR"><C:comp-filter name="VTODO"><C:is-not-defined/></C:comp-filter></C:comp-filter>`, true},
+		{"时间范围匹配", `<C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"><C:time-range start="20240110T000000Z" end="20240111T000000Z"/></C:comp-filter></C:comp-filter>`, true},
+		{"时间范围不匹配", `<C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"><C:time-range start="20240111T000000Z"/></C:comp-filter></C:comp-filter>`, false},
+		{"文本匹配", `<C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"><C:prop-filter name="SUMMARY"><C:text-match>周会</C:text-match></C:prop-filter></C:comp-filter></C:comp-filter>`, true},
+		{"文本取反", `<C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"><C:prop-filter name="SUMMARY"><C:text-match negate-condition="yes">周会</C:text-match></C:prop-filter></C:comp-filter></C:comp-filter>`, false},
+		{"属性不存在条件", `<C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"><C:prop-filter name="LOCATION"><C:is-not-defined/></C:prop-filter></C:comp-filter></C:comp-filter>`, true},
+		{"嵌套组件", `<C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"><C:comp-filter name="VALARM"/></C:comp-filter></C:comp-filter>`, true},
+	}
+	for _, tt := range tests {
+		var req calendarQueryRequest
+		body := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><C:filter>` + tt.filter + `</C:filter></C:calendar-query>`
+		if err := xml.Unmarshal([]byte(body), &req); err != nil || req.Filter.CompFilter == nil {
+			t.Fatalf("%s: 无法解析过滤条件: %v", tt.name, err)
+		}
+		if got := req.Filter.CompFilter.match(cal); got != tt.want {
+			t.Errorf("%s: match = %v, 期望 %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAddressbookPropFilter(t *testing.T) {
+	card, err := parseICal(icalLines(
+		"BEGIN:VCARD",
+		"VERSION:4.0",
+		"FN:Zhang San",
+		"EMAIL;TYPE=work:zhang@example.com",
+		"END:VCARD",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		test   string // filter 的 test 属性
+		filter string
+		want   bool
+	}{
+		{"包含", "", `<prop-filter name="FN"><text-match>zhang</text-match></prop-filter>`, true},
+		{"区分大小写", "", `<prop-filter name="FN"><text-match collation="i;octet">zhang</text-match></prop-filter>`, false},
+		{"相等", "", `<prop-filter name="FN"><text-match match-type="equals">zhang san</text-match></prop-filter>`, true},
+		{"开头", "", `<prop-filter name="EMAIL"><text-match match-type="starts-with">zhang@</text-match></prop-filter>`, true},
+		{"结尾", "", `<prop-filter name="EMAIL"><text-match match-type="ends-with">.org</text-match></prop-filter>`, false},
+		{"参数过滤", "", `<prop-filter name="EMAIL"><param-filter name="TYPE"><text-match match-type="equals">work</text-match></param-filter></prop-filter>`, true},
+		{"默认满足任一条件", "", `<prop-filter name="FN"><text-match>li</text-match><text-match>san</text-match></prop-filter>`, true},
+		{"allof", "", `<prop-filter name="FN" test="allof"><text-match>li</text-match><text-match>san</text-match></prop-filter>`, false},
+		{"属性不存在", "", `<prop-filter name="TEL"><text-match>1</text-match></prop-filter>`, false},
+		{"属性不存在条件", "", `<prop-filter name="TEL"><is-not-defined/></prop-filter>`, true},
+		{"过滤器之间默认anyof", "", `<prop-filter name="TEL"><is-not-defined/></prop-filter><prop-filter name="FN"><text-match>li</text-match></prop-filter>`, true},
+		{"过滤器之间allof", "allof", `<prop-filter name="TEL"><is-not-defined/></prop-filter><prop-filter name="FN"><text-match>li</text-match></prop-filter>`, false},
+	}
+	for _, tt := range tests {
+		var req addressbookQueryRequest
+		body := `<addressbook-query xmlns="urn:ietf:params:xml:ns:carddav"><filter test="` + tt.test + `">` + tt.filter + `</filter></addressbook-query>`
+		if err := xml.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatalf("%s: 无法解析过滤条件: %v", tt.name, err)
+		}
+		results := make([]bool, len(req.Filter.PropFilters))
+		for i := range req.Filter.PropFilters {
+			results[i] = req.Filter.PropFilters[i].match(card, "anyof")
+		}
+		if got := combineTests(results, req.Filter.Test, "anyof"); got != tt.want {
+			t.Errorf("%s: 匹配 = %v, 期望 %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// identity 表示经过认证的客户端身份，供授权规则和访问日志使用
+type identity struct {
+	Name   string    // 用户名
+	Groups []string  // 所属用户组
+	Method string    // 认证方式，如 mtls
+	Token  *apiToken // 通过API令牌认证时使用的令牌
+}
+
+// requestInfo 保存单个请求在中间件之间共享的信息
+type requestInfo struct {
+	identity *identity
+	clientIP string
+}
+
+type requestInfoKey struct{}
+
+// withRequestInfo 为请求附加可在中间件之间共享的请求信息
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return r, info
+	}
+	info := &requestInfo{clientIP: clientIP(r)}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// getRequestInfo 返回请求附带的共享信息，不存在时返回nil
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// setRequestIdentity 记录请求的客户端身份
+func setRequestIdentity(r *http.Request, id *identity) {
+	if info := getRequestInfo(r); info != nil {
+		info.identity = id
+	}
+}
+
+// requestIdentity 返回请求的客户端身份，未认证时返回nil
+func requestIdentity(r *http.Request) *identity {
+	return contextIdentity(r.Context())
+}
+
+// authenticatedUser 返回通过登录凭据认证的用户身份，匿名请求和分享链接请求返回nil
+func authenticatedUser(r *http.Request) *identity {
+	id := requestIdentity(r)
+	if id == nil || id.Name == "" || id.Method == "share" {
+		return nil
+	}
+	return id
+}
+
+// requestClientIP 返回请求的客户端IP地址
+func requestClientIP(r *http.Request) string {
+	return contextClientIP(r.Context())
+}
+
+// contextClientIP 从请求上下文中取得客户端IP地址
+func contextClientIP(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.clientIP
+	}
+	return ""
+}
+
+// contextIdentity 从请求上下文中取得客户端身份，供webdav.FileSystem等只能拿到context的代码使用
+func contextIdentity(ctx context.Context) *identity {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.identity
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IP地址过滤和反向代理相关配置
+var (
+	trustedProxies string
+	proxyProtocol  bool
+	ipAllow        string
+	ipDeny         string
+	ipAllowStatic  string
+	ipDenyStatic   string
+	ipAllowUpload  string
+	ipDenyUpload   string
+	ipAllowWebDAV  string
+	ipDenyWebDAV   string
+)
+
+// proxyHeaderTimeout 等待PROXY协议头的最长时间
+const proxyHeaderTimeout = 10 * time.Second
+
+// ipList CIDR地址段列表
+type ipList []*net.IPNet
+
+// parseIPList 解析逗号分隔的CIDR或单个IP地址
+func parseIPList(s string) (ipList, error) {
+	var list ipList
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的IP地址: %s", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR: %s", item)
+		}
+		list = append(list, ipnet)
+	}
+	return list, nil
+}
+
+// contains 判断IP地址是否位于列表中的任一地址段
+func (l ipList) contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilter 允许和拒绝的地址段：先检查拒绝列表，允许列表非空时只允许其中的地址
+type ipFilter struct {
+	allow ipList
+	deny  ipList
+}
+
+// permits 判断IP地址是否允许访问
+func (f *ipFilter) permits(ip string) bool {
+	if f == nil {
+		return true
+	}
+	if f.deny.contains(ip) {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow.contains(ip)
+}
+
+// newIPFilter 根据允许和拒绝列表创建过滤器，两者都为空时返回nil
+func newIPFilter(allow, deny string) (*ipFilter, error) {
+	f := &ipFilter{}
+	var err error
+	if f.allow, err = parseIPList(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parseIPList(deny); err != nil {
+		return nil, err
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+var (
+	trustedProxyList ipList
+	globalIPFilter   *ipFilter
+	mountIPFilters   = map[string]*ipFilter{}
+)
+
+// setupIPFilter 解析可信代理和IP地址过滤配置
+func setupIPFilter() {
+	var err error
+	if trustedProxyList, err = parseIPList(trustedProxies); err != nil {
+		log.Fatalf("无效的 -trusted-proxies: %v", err)
+	}
+	if proxyProtocol && len(trustedProxyList) == 0 {
+		log.Fatal("-proxy-protocol 需要同时指定 -trusted-proxies")
+	}
+	if len(trustedProxyList) > 0 {
+		mode := "X-Forwarded-For/Forwarded"
+		if proxyProtocol {
+			mode += "、PROXY协议"
+		}
+		fmt.Printf("✅ 可信反向代理: %s (%s)\n", trustedProxies, mode)
+	}
+
+	if globalIPFilter, err = newIPFilter(ipAllow, ipDeny); err != nil {
+		log.Fatalf("无效的 -ip-allow/-ip-deny: %v", err)
+	}
+	if globalIPFilter != nil {
+		fmt.Printf("✅ 全局IP过滤已启用 (允许: %s, 拒绝: %s)\n", orAll(ipAllow), orNone(ipDeny))
+	}
+	for _, m := range []struct{ mount, allow, deny string }{
+		{"static", ipAllowStatic, ipDenyStatic},
+		{"upload", ipAllowUpload, ipDenyUpload},
+		{"webdav", ipAllowWebDAV, ipDenyWebDAV},
+	} {
+		f, err := newIPFilter(m.allow, m.deny)
+		if err != nil {
+			log.Fatalf("无效的 -ip-allow-%s/-ip-deny-%s: %v", m.mount, m.mount, err)
+		}
+		if f != nil {
+			mountIPFilters[m.mount] = f
+			fmt.Printf("✅ %s IP过滤已启用 (允许: %s, 拒绝: %s)\n", m.mount, orAll(m.allow), orNone(m.deny))
+		}
+	}
+}
+
+func orAll(s string) string {
+	if s == "" {
+		return "全部"
+	}
+	return s
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "无"
+	}
+	return s
+}
+
+// ipFilterMiddleware 按全局IP过滤规则拒绝请求
+func ipFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !globalIPFilter.permits(requestClientIP(r)) {
+			http.Error(w, "不允许从当前地址访问", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mountIPAllowed 判断客户端IP是否允许访问挂载点
+func mountIPAllowed(mount string, r *http.Request) bool {
+	return mountIPFilters[mount].permits(requestClientIP(r))
+}
+
+// trustedProxy 判断地址是否为可信的反向代理
+func trustedProxy(ip string) bool {
+	return trustedProxyList.contains(ip)
+}
+
+// normalizeForwardedIP 从转发头中的地址取出IP，去掉引号、方括号和端口；无法识别时返回空字符串
+func normalizeForwardedIP(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if strings.HasPrefix(s, "[") {
+		if end := strings.Index(s, "]"); end > 0 {
+			s = s[1:end]
+		}
+	} else if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// forwardedChain 返回转发头记录的地址链，从最初的客户端到最近的代理；
+// 优先使用RFC 7239的Forwarded头，否则使用X-Forwarded-For
+func forwardedChain(r *http.Request) []string {
+	var chain []string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			addr := ""
+			for _, pair := range strings.Split(element, ";") {
+				if k, v, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(k, "for") {
+					addr = v
+				}
+			}
+			chain = append(chain, addr)
+		}
+		return chain
+	}
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		chain = append(chain, strings.Split(value, ",")...)
+	}
+	return chain
+}
+
+// forwardedClientIP 在直接连接的对端是可信代理时，从转发头中找出真实的客户端地址：
+// 从右向左跳过可信代理，第一个不可信的地址即为客户端；地址无法识别时停止，使用最后一个可信的地址
+func forwardedClientIP(r *http.Request, peer string) string {
+	if !trustedProxy(peer) {
+		return peer
+	}
+	chain := forwardedChain(r)
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := normalizeForwardedIP(chain[i])
+		if ip == "" {
+			break
+		}
+		peer = ip
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+	return peer
+}
+
+// listen 监听TCP端口，启用PROXY协议时包装监听器
+func listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil || !proxyProtocol {
+		return ln, err
+	}
+	return &proxyProtocolListener{Listener: ln}, nil
+}
+
+// proxyProtocolListener 接受来自可信代理的PROXY协议（v1和v2）连接
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: c}, nil
+}
+
+// proxyProtocolConn 在第一次读取或获取对端地址时解析PROXY协议头；
+// 只有对端是可信代理时才解析，否则按普通连接处理
+type proxyProtocolConn struct {
+	net.Conn
+
+	once   sync.Once
+	reader *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		host, _, _ := net.SplitHostPort(c.Conn.RemoteAddr().String())
+		if !trustedProxy(host) {
+			return
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			log.Printf("无法解析来自 %s 的PROXY协议头: %v", host, c.err)
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// proxyV2Signature PROXY协议v2的固定前缀
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader 读取PROXY协议头并返回其中的源地址；没有协议头时返回nil，
+// 协议头表示本地连接（健康检查等）或未知协议时同样返回nil
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, err := r.Peek(6); err != nil || string(prefix) != "PROXY " {
+			// PUT、POST、PROPFIND等HTTP请求
+			return nil, nil
+		}
+		return readProxyV1(r)
+	case '\r':
+		if prefix, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(prefix, proxyV2Signature) {
+			return readProxyV2(r)
+		}
+	}
+	return nil, nil
+}
+
+// readProxyV1 解析文本格式的协议头，如 "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n"
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) <= 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY协议头过长或格式错误")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("PROXY协议头格式错误: %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("PROXY协议头地址无效: %q", strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 解析二进制格式的协议头
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.New("不支持的PROXY协议版本")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if header[12]&0x0f == 0 {
+		// LOCAL命令：代理自身发起的连接
+		return nil, nil
+	}
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("PROXY协议头地址长度错误")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("PROXY协议头地址长度错误")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2Header 构造PROXY协议v2头部：command为0（LOCAL）或1（PROXY），length为写入头部的地址长度
+func proxyV2Header(version, command, family byte, length int, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(version<<4 | command)
+	buf.WriteByte(family)
+	binary.Write(&buf, binary.BigEndian, uint16(length))
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := append(append(net.IPv4(203, 0, 113, 7).To4(), 10, 0, 0, 1), 0xc8, 0x02, 0x01, 0xbb) // 端口51202 -> 443
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("2001:db8::7"))
+	copy(ipv6[16:], net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(ipv6[32:], 40000)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string // 期望的源地址，为空表示没有地址
+		wantErr bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n"), "203.0.113.7:51234", false},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 40000 443\r\n"), "[2001:db8::7]:40000", false},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 缺少字段", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234\r\n"), "", true},
+		{"v1 无效地址", []byte("PROXY TCP4 203.0.113.999 10.0.0.1 51234 443\r\n"), "", true},
+		{"v1 无效端口", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 70000 443\r\n"), "", true},
+		{"v1 不支持的协议", []byte("PROXY UDP4 203.0.113.7 10.0.0.1 51234 443\r\n"), "", true},
+		{"v1 缺少CRLF", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\n"), "", true},
+		{"v1 截断", []byte("PROXY TCP4 203.0.113.7"), "", true},
+		{"v1 过长", []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), "", true},
+		{"HTTP请求", []byte("PUT /webdav/a.txt HTTP/1.1\r\n"), "", false},
+		{"以P开头的短请求", []byte("PO"), "", false},
+		{"v2 TCP4", proxyV2Header(2, 1, 0x11, 12, ipv4), "203.0.113.7:51202", false},
+		{"v2 TCP6", proxyV2Header(2, 1, 0x21, 36, ipv6), "[2001:db8::7]:40000", false},
+		{"v2 LOCAL", proxyV2Header(2, 0, 0x00, 0, nil), "", false},
+		{"v2 未指定地址族", proxyV2Header(2, 1, 0x00, 0, nil), "", false},
+		{"v2 TLV附加数据", proxyV2Header(2, 1, 0x11, 16, append(append([]byte{}, ipv4...), 0x04, 0x00, 0x01, 0x00)), "203.0.113.7:51202", false},
+		{"v2 错误版本", proxyV2Header(1, 1, 0x11, 12, ipv4), "", true},
+		{"v2 头部截断", proxyV2Header(2, 1, 0x11, 12, nil)[:14], "", true},
+		{"v2 地址截断", proxyV2Header(2, 1, 0x11, 12, ipv4[:6]), "", true},
+		{"v2 IPv4地址过短", proxyV2Header(2, 1, 0x11, 4, ipv4[:4]), "", true},
+		{"v2 IPv6地址过短", proxyV2Header(2, 1, 0x21, 12, ipv4), "", true},
+		{"签名不完整", proxyV2Signature[:8], "", false},
+	}
+	for _, tt := range tests {
+		addr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(tt.input)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, 期望出错 = %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%s: 地址 = %q, 期望 %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// 协议头之后的数据必须保留给HTTP服务器读取
+func TestReadProxyHeaderKeepsPayload(t *testing.T) {
+	for _, header := range [][]byte{
+		[]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n"),
+		proxyV2Header(2, 1, 0x11, 12, []byte{203, 0, 113, 7, 10, 0, 0, 1, 0, 80, 1, 187}),
+		nil,
+	} {
+		r := bufio.NewReader(bytes.NewReader(append(header, "GET / HTTP/1.1\r\n"...)))
+		if _, err := readProxyHeader(r); err != nil {
+			t.Fatalf("readProxyHeader: %v", err)
+		}
+		rest, _ := io.ReadAll(r)
+		if string(rest) != "GET / HTTP/1.1\r\n" {
+			t.Errorf("协议头之后的数据 = %q", rest)
+		}
+	}
+}
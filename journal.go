@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// 日志中无效记录超过该数量（且多于有效状态条目数）时压缩日志文件
+const journalCompactThreshold = 256
+
+// jsonJournal 追加写入的JSON行日志文件：每次状态变化追加一条记录并同步到磁盘，
+// 启动时重放全部记录恢复状态，无效记录过多时用当前状态的快照重写文件
+type jsonJournal struct {
+	path  string
+	file  *os.File
+	stale int // 已被后续记录覆盖或删除的记录数
+}
+
+// replayJournal 逐行读取日志文件并交给apply处理，文件不存在时返回nil；
+// 无法解析的行（例如写入时崩溃留下的不完整记录）会被记录并忽略
+func replayJournal(path string, apply func(line []byte) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := apply(scanner.Bytes()); err != nil {
+			log.Printf("⚠️ 日志文件 %s 第 %d 行无效，已忽略: %v", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// rewrite 用快照记录重写日志文件，然后以追加模式重新打开
+func (j *jsonJournal) rewrite(entries []interface{}) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	if err := writeFileAtomic(j.path, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	j.file = f
+	j.stale = 0
+	return nil
+}
+
+// needsCompact 判断是否应该用快照重写日志，live 为当前有效的状态条目数
+func (j *jsonJournal) needsCompact(live int) bool {
+	return j.stale > journalCompactThreshold && j.stale > live
+}
+
+// append 追加一条记录并同步到磁盘
+func (j *jsonJournal) append(entry interface{}) error {
+	if j.file == nil {
+		return os.ErrClosed
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// appendAll 追加多条记录，全部写入后同步一次磁盘
+func (j *jsonJournal) appendAll(entries []interface{}) error {
+	if j.file == nil {
+		return os.ErrClosed
+	}
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	if _, err := j.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
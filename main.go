@@ -10,7 +10,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/net/webdav"
 )
 
@@ -22,19 +24,86 @@ var (
 	webdavReadonly bool
 )
 
+// webdavFS WebDAV服务使用的文件系统，未启用WebDAV时为nil
+var webdavFS webdav.FileSystem
+
 func main() {
 	// 解析命令行参数
 	var port int
 	var showHelp bool
 
+	// 处理子命令
+	if len(os.Args) > 1 && runSubcommand(os.Args[1], os.Args[2:]) {
+		return
+	}
+
 	flag.BoolVar(&uploadEnabled, "upload", false, "启用文件上传功能")
 	flag.BoolVar(&uploadEnabled, "enable-upload", false, "启用文件上传功能")
+	flag.BoolVar(&dropboxEnabled, "dropbox", false, "投递箱模式: /upload 只能投递文件，每次投递保存到独立目录")
+	flag.StringVar(&dropboxDir, "dropbox-dir", "dropbox", "投递箱目录")
+	flag.StringVar(&dropboxMaxSizeSpec, "dropbox-max-size", "1GB", "每次投递的最大大小，例如 200MB (0表示不限制)")
 	flag.BoolVar(&webdavEnabled, "webdav", false, "启用WebDAV服务")
 	flag.BoolVar(&webdavEnabled, "enable-webdav", false, "启用WebDAV服务")
 	flag.StringVar(&webdavDir, "webdav-dir", ".", "WebDAV服务的根目录")
 	flag.BoolVar(&webdavReadonly, "webdav-readonly", false, "WebDAV服务只读模式")
+	flag.StringVar(&webdavPolicySpec, "webdav-policy", "", "WebDAV访问策略，格式: 用户=模式,@组=模式,/webdav/路径=模式 (模式: rw, ro, ro-strict)")
+	flag.BoolVar(&webdavUserHomes, "webdav-user-homes", false, "为每个认证用户提供独立的WebDAV主目录 (<webdav-dir>/users/<用户名>)")
+	flag.StringVar(&webdavShared, "webdav-shared", "", "在每个用户主目录中挂载的共享文件夹，格式: 名称=目录,名称=目录")
+	flag.StringVar(&webdavQuotaSpec, "webdav-quota", "", "WebDAV存储配额，启用用户主目录时为每个用户的配额，例如 10GB")
+	flag.StringVar(&webdavUserQuotasSpec, "webdav-user-quotas", "", "按用户设置的WebDAV配额，格式: 用户=容量,用户=容量")
+	flag.IntVar(&fileVersionsMax, "file-versions", 0, "每个文件保留的历史版本数，覆盖上传或WebDAV写入前保存原内容 (0表示不保留)")
+	flag.DurationVar(&webdavTrashRetention, "webdav-trash-retention", 30*24*time.Hour, "WebDAV回收站保留删除和覆盖内容的时间 (0表示直接永久删除)")
+	flag.DurationVar(&webdavSyncScan, "webdav-sync-scan", time.Minute, "扫描WebDAV目录以发现外部修改的间隔，用于同步报告 (0表示只在启动时扫描)")
+	flag.BoolVar(&caldavEnabled, "caldav", false, "启用CalDAV和CardDAV服务 (/dav/)，需要启用认证")
+	flag.StringVar(&caldavDir, "caldav-dir", "caldav", "CalDAV日历和CardDAV通讯录的存储目录")
+	flag.StringVar(&webdavAppleFiles, "webdav-apple-files", appleFilesKeep, "Finder创建的 ._* 和 .DS_Store 文件的处理方式: keep, hide 或 discard")
+	flag.DurationVar(&webdavLockMaxTimeout, "webdav-lock-timeout", 24*time.Hour, "WebDAV锁的最长有效期，无限期的锁也按此过期 (0表示不限制)")
 	flag.IntVar(&port, "port", 8080, "指定服务器端口")
 	flag.IntVar(&port, "p", 8080, "指定服务器端口")
+	flag.StringVar(&tlsCertFile, "tls-cert", "", "HTTPS证书文件路径 (PEM格式)")
+	flag.StringVar(&tlsKeyFile, "tls-key", "", "HTTPS私钥文件路径 (PEM格式)")
+	flag.BoolVar(&tlsSelfSigned, "tls-self-signed", false, "自动生成并缓存自签名证书以启用HTTPS")
+	flag.IntVar(&tlsRedirectPort, "tls-redirect-port", 0, "在指定端口监听HTTP并重定向到HTTPS (0表示不启用)")
+	flag.StringVar(&acmeDomains, "acme-domains", "", "通过ACME自动申请证书的域名，多个用逗号分隔")
+	flag.StringVar(&acmeEmail, "acme-email", "", "ACME账户联系邮箱")
+	flag.StringVar(&acmeDirectory, "acme-directory", autocert.DefaultACMEDirectory, "ACME服务目录URL")
+	flag.StringVar(&acmeCARoot, "acme-ca-root", "", "信任的ACME服务根证书 (用于Pebble等本地测试CA)")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "客户端证书CA文件，指定后启用客户端证书认证 (mTLS)")
+	flag.StringVar(&tlsClientAuth, "tls-client-auth", "required", "客户端证书验证模式: required 或 optional")
+	flag.StringVar(&tlsClientIdentity, "tls-client-identity", "cn", "用作用户名的证书字段: cn, email, dns, uri 或 subject")
+	flag.StringVar(&tlsClientMapFile, "tls-client-map", "", "证书到用户名的映射文件")
+	flag.StringVar(&htpasswdPath, "htpasswd", "", "Basic认证使用的htpasswd用户文件 (修改后自动重新加载)")
+	flag.StringVar(&authStatic, "auth-static", authNone, "静态文件的认证要求: none, write 或 all")
+	flag.StringVar(&authUpload, "auth-upload", authAll, "文件上传的认证要求: none, write 或 all")
+	flag.StringVar(&authWebDAV, "auth-webdav", authAll, "WebDAV的认证要求: none, write 或 all")
+	flag.StringVar(&oidcIssuer, "oidc-issuer", "", "OpenID Connect身份提供方地址，指定后启用单点登录")
+	flag.StringVar(&oidcClientID, "oidc-client-id", "", "OpenID Connect客户端ID")
+	flag.StringVar(&oidcClientSecret, "oidc-client-secret", "", "OpenID Connect客户端密钥 (公共客户端可留空)")
+	flag.StringVar(&oidcRedirectURL, "oidc-redirect-url", "", "OpenID Connect回调地址 (默认根据请求自动生成 /oidc/callback)")
+	flag.StringVar(&oidcScopes, "oidc-scopes", "openid profile email", "OpenID Connect请求的scope")
+	flag.StringVar(&oidcUsernameClaim, "oidc-username-claim", "preferred_username", "用作用户名的ID令牌声明")
+	flag.StringVar(&oidcGroupsClaim, "oidc-groups-claim", "groups", "用作用户组的ID令牌声明")
+	flag.StringVar(&oidcGroupMap, "oidc-group-map", "", "身份提供方组到本地组的映射，格式: 远程组=本地组,远程组=本地组")
+	flag.StringVar(&totpIssuer, "totp-issuer", "sweb", "两步验证时身份验证器应用中显示的服务名称")
+	flag.IntVar(&lockoutUserThreshold, "lockout-threshold", 5, "同一用户连续认证失败多少次后锁定 (0表示不限制)")
+	flag.IntVar(&lockoutIPThreshold, "lockout-ip-threshold", 20, "同一IP地址认证失败多少次后锁定 (0表示不限制)")
+	flag.DurationVar(&lockoutDuration, "lockout-duration", time.Minute, "首次锁定的时长，之后每次锁定时长翻倍")
+	flag.DurationVar(&lockoutMaxDuration, "lockout-max", time.Hour, "锁定时长的上限")
+	flag.DurationVar(&sessionTTL, "session-ttl", 12*time.Hour, "登录会话的空闲过期时间")
+	flag.StringVar(&adminUsers, "admin-users", "", "管理员用户，多个用逗号分隔 (admin组成员同样视为管理员)")
+	flag.StringVar(&aclFilePath, "acl-file", "", "访问控制列表文件 (JSON格式)")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "可信反向代理的地址段，多个用逗号分隔；只信任来自这些地址的X-Forwarded-For/Forwarded头")
+	flag.BoolVar(&proxyProtocol, "proxy-protocol", false, "接受来自可信反向代理的PROXY协议 (v1/v2) 连接")
+	flag.StringVar(&ipAllow, "ip-allow", "", "只允许这些地址段访问，多个用逗号分隔")
+	flag.StringVar(&ipDeny, "ip-deny", "", "拒绝这些地址段访问，多个用逗号分隔")
+	flag.StringVar(&ipAllowStatic, "ip-allow-static", "", "只允许这些地址段访问静态文件")
+	flag.StringVar(&ipDenyStatic, "ip-deny-static", "", "拒绝这些地址段访问静态文件")
+	flag.StringVar(&ipAllowUpload, "ip-allow-upload", "", "只允许这些地址段使用文件上传")
+	flag.StringVar(&ipDenyUpload, "ip-deny-upload", "", "拒绝这些地址段使用文件上传")
+	flag.StringVar(&ipAllowWebDAV, "ip-allow-webdav", "", "只允许这些地址段访问WebDAV")
+	flag.StringVar(&ipDenyWebDAV, "ip-deny-webdav", "", "拒绝这些地址段访问WebDAV")
+	flag.StringVar(&accessLogFile, "access-log", "", "访问日志文件 (\"-\" 表示标准输出)")
+	flag.StringVar(&dataDir, "data-dir", ".sweb", "程序状态数据目录 (证书缓存等)")
 	flag.BoolVar(&showHelp, "help", false, "显示帮助信息")
 	flag.BoolVar(&showHelp, "h", false, "显示帮助信息")
 
@@ -55,19 +124,67 @@ func main() {
 		}
 	}
 
+	refuseInStaticDir(dataDir, "状态数据目录", webDir, "-data-dir")
+
+	// 投递箱模式
+	setupDropbox(webDir)
+
 	// 检查并创建默认页面
 	createDefaultPageIfNeeded(webDir, uploadEnabled)
 
+	// 打开访问日志，解析反向代理和IP过滤配置
+	setupAccessLog()
+	setupIPFilter()
+
+	// 加载认证配置和访问控制列表
+	setupAuth()
+	setupTwoFactor()
+	setupOIDC()
+	setupAPITokens()
+	setupLockout()
+	setupACL()
+	setupShareLinks()
+	setupShares()
+	setupFileVersions()
+
 	// 处理静态文件（HTML, JS等）
-	fileServer := http.FileServer(http.Dir(webDir))
-	http.Handle("/", fileServer)
+	fileServer := aclStaticHandler(http.Dir(webDir))
+	http.Handle("/", requireAuth("static", fileVersionHandler(fileServer)))
 
 	// 添加上传状态API端点
 	http.HandleFunc("/api/upload-status", uploadStatusHandler)
 
+	// 登录页面
+	http.Handle("/login", csrfProtect(http.HandlerFunc(loginHandler)))
+	http.Handle("/logout", csrfProtect(http.HandlerFunc(logoutHandler)))
+	http.Handle("/account/2fa", csrfProtect(http.HandlerFunc(twoFactorHandler)))
+	http.HandleFunc("/oidc/login", oidcLoginHandler)
+	http.HandleFunc("/oidc/callback", oidcCallbackHandler)
+
+	// API令牌管理
+	http.Handle("/api/tokens", sessionCSRFProtect(http.HandlerFunc(apiTokensAPIHandler)))
+
+	// WebDAV锁管理API
+	http.Handle("/api/webdav/locks", sessionCSRFProtect(http.HandlerFunc(webdavLocksAPIHandler)))
+	http.Handle("/api/versions", sessionCSRFProtect(http.HandlerFunc(versionsAPIHandler)))
+	http.Handle("/api/webdav/trash", requireAuth("webdav", sessionCSRFProtect(http.HandlerFunc(webdavTrashAPIHandler))))
+	http.Handle("/api/search", requireAuth("webdav", sessionCSRFProtect(http.HandlerFunc(searchAPIHandler))))
+
+	// 暴力破解防护管理API
+	http.Handle("/api/security/", sessionCSRFProtect(http.HandlerFunc(securityAPIHandler)))
+
+	// 分享链接管理API
+	http.Handle("/api/share-links", sessionCSRFProtect(http.HandlerFunc(shareLinksAPIHandler)))
+
+	// 受密码保护的分享页面
+	http.Handle("/share/", csrfProtect(http.HandlerFunc(sharePageHandler)))
+	http.Handle("/api/shares", sessionCSRFProtect(http.HandlerFunc(sharesAPIHandler)))
+
 	// 根据参数决定是否启用文件上传
-	if uploadEnabled {
-		http.HandleFunc("/upload", uploadHandler)
+	if dropboxEnabled {
+		http.Handle("/upload", requireAuth("upload", dropboxSizeLimit(uploadCSRFProtect(http.HandlerFunc(dropboxHandler)))))
+	} else if uploadEnabled {
+		http.Handle("/upload", requireAuth("upload", uploadCSRFProtect(http.HandlerFunc(uploadHandler))))
 		fmt.Println("✅ 文件上传功能已启用")
 	} else {
 		http.HandleFunc("/upload", uploadDisabledHandler)
@@ -87,16 +204,86 @@ func main() {
 		fmt.Println("🔒 WebDAV服务已禁用 (使用 -webdav 参数启用)")
 	}
 
+	// CalDAV和CardDAV服务
+	setupCalDAV(webDir)
+
 	// 启动服务器
-	fmt.Printf("服务器启动在 http://localhost:%d\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	startServer(port)
+}
+
+// runSubcommand 执行子命令，返回是否识别了该子命令
+func runSubcommand(name string, args []string) bool {
+	switch name {
+	case "passwd":
+		runPasswdCommand(args)
+	case "acl":
+		runACLCommand(args)
+	case "share":
+		runShareCommand(args)
+	case "token":
+		runTokenCommand(args)
+	case "2fa":
+		runTwoFactorCommand(args)
+	case "oidc":
+		runOIDCCommand(args)
+	default:
+		return false
+	}
+	return true
+}
+
+// startServer 根据是否启用HTTPS启动HTTP或HTTPS服务器
+func startServer(port int) {
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: buildHandler()}
+	ln, err := listen(server.Addr)
+	if err != nil {
+		log.Fatalf("无法监听端口 %d: %v", port, err)
+	}
+
+	if !tlsEnabled() {
+		if mtlsEnabled() {
+			log.Fatal("客户端证书认证需要启用HTTPS (-tls-cert/-tls-key、-tls-self-signed 或 -acme-domains)")
+		}
+		fmt.Printf("服务器启动在 http://localhost:%d\n", port)
+		log.Fatal(server.Serve(ln))
+	}
+
+	tlsConfig, err := setupTLS()
+	if err != nil {
+		log.Fatalf("无法配置HTTPS: %v", err)
+	}
+	server.TLSConfig = tlsConfig
+	fmt.Printf("✅ HTTPS已启用 (%s)\n", tlsMode())
+
+	if tlsRedirectPort > 0 {
+		startRedirectServer(tlsRedirectPort, port)
+	}
+
+	fmt.Printf("服务器启动在 https://localhost:%d\n", port)
+	log.Fatal(server.ServeTLS(ln, "", ""))
+}
+
+// buildHandler 组装处理所有请求的中间件链
+func buildHandler() http.Handler {
+	var handler http.Handler = http.DefaultServeMux
+	handler = davClientMiddleware(handler)
+	handler = dropboxGuard(handler)
+	handler = shareLinkMiddleware(handler)
+	handler = sessionMiddleware(handler)
+	handler = authMiddleware(handler)
+	handler = clientCertMiddleware(handler)
+	handler = ipFilterMiddleware(handler)
+	// 访问日志位于最外层，以便记录内层中间件识别出的客户端身份
+	handler = accessLogMiddleware(handler)
+	return handler
 }
 
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		// 显示上传表单
+		token := csrfToken(w, r)
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Write([]byte(`
+		w.Write([]byte(fmt.Sprintf(`
             <!DOCTYPE html>
             <html>
             <head>
@@ -105,12 +292,13 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
             <body>
                 <h2>文件上传</h2>
                 <form method="post" enctype="multipart/form-data">
+                    <input type="hidden" name="csrf_token" value="%s">
                     <input type="file" name="file">
                     <input type="submit" value="上传">
                 </form>
             </body>
             </html>
-        `))
+        `, token)))
 	} else if r.Method == "POST" {
 		// 处理文件上传
 		file, header, err := r.FormFile("file")
@@ -120,8 +308,25 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 
+		// 检查访问控制列表
+		if target := "/" + header.Filename; !aclAllowed(r, target, aclWrite) {
+			aclDenied(w, r, target)
+			return
+		}
+
+		// 覆盖已有文件前保存历史版本
+		target := filepath.Join("web", header.Filename)
+		user := ""
+		if id := requestIdentity(r); id != nil {
+			user = id.Name
+		}
+		if err := saveFileVersion(target, user); err != nil {
+			http.Error(w, "无法保存历史版本: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		// 创建目标文件
-		dst, err := os.Create(filepath.Join("web", header.Filename))
+		dst, err := os.Create(target)
 		if err != nil {
 			http.Error(w, "无法创建目标文件: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -134,6 +339,8 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "无法保存文件: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		dst.Close()
+		recordSyncChange(target, false)
 
 		// 返回成功信息
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -190,13 +397,76 @@ func showHelpInfo() {
 	fmt.Println()
 	fmt.Println("用法:")
 	fmt.Println("  sweb.exe [选项]")
+	fmt.Println("  sweb.exe passwd [-f 文件] [-algo 算法] [-D] <用户名> [密码]")
+	fmt.Println("  sweb.exe share [-expires 时长] [-ip 地址] [-max 次数] [-user 用户] <路径>")
+	fmt.Println("  sweb.exe share list | revoke <链接ID>")
+	fmt.Println("  sweb.exe token create -user <用户> [-scope read|write|admin] [-prefix 路径] [-expires 时长]")
+	fmt.Println("  sweb.exe token list | revoke <令牌ID>")
+	fmt.Println("  sweb.exe 2fa status | enroll <用户> | disable <用户> | app-password <用户> <名称>")
+	fmt.Println("  sweb.exe acl test -acl-file <文件> [-user 用户] [-groups 组] [-ip 地址] <路径> [read|write]")
+	fmt.Println("  sweb.exe oidc mock [-port 端口] [-client-id ID] [-user 用户] [-groups 组] (需以 -tags oidcmock 构建)")
 	fmt.Println()
 	fmt.Println("选项:")
 	fmt.Println("  -upload, --enable-upload    启用文件上传功能 (默认: 禁用)")
+	fmt.Println("  -dropbox                    投递箱模式: 匿名投递文件，不能查看已上传内容")
+	fmt.Println("  -dropbox-dir <目录>         投递箱目录 (默认: dropbox)")
+	fmt.Println("  -dropbox-max-size <容量>    每次投递的最大大小 (默认: 1GB, 0表示不限制)")
 	fmt.Println("  -webdav, --enable-webdav    启用WebDAV服务 (默认: 禁用)")
 	fmt.Println("  -webdav-dir <目录>          WebDAV服务的根目录 (默认: 当前目录)")
 	fmt.Println("  -webdav-readonly            WebDAV服务只读模式 (默认: 读写)")
+	fmt.Println("  -webdav-policy <规则>       按用户、组或路径设置WebDAV模式，格式: alice=rw,@guest=ro,/webdav/archive=ro-strict")
+	fmt.Println("  -webdav-user-homes          为每个认证用户提供独立的WebDAV主目录")
+	fmt.Println("  -webdav-shared <配置>       在用户主目录中挂载共享文件夹，格式: 名称=目录,...")
+	fmt.Println("  -webdav-quota <容量>        WebDAV存储配额，启用用户主目录时为每个用户的配额")
+	fmt.Println("  -webdav-user-quotas <配置>  按用户设置WebDAV配额，格式: 用户=容量,...")
+	fmt.Println("  -file-versions <数量>      每个文件保留的历史版本数 (默认: 0, 不保留)")
+	fmt.Println("  -webdav-trash-retention <时长> WebDAV回收站的保留时间 (默认: 720h, 0表示直接永久删除)")
+	fmt.Println("  -webdav-sync-scan <时长>   扫描WebDAV目录以发现外部修改的间隔 (默认: 1m, 0表示只在启动时扫描)")
+	fmt.Println("  -webdav-lock-timeout <时长> WebDAV锁的最长有效期 (默认: 24h, 0表示不限制)")
+	fmt.Println("  -webdav-apple-files <模式>  Finder的 ._* 和 .DS_Store 文件: keep|hide|discard (默认: keep)")
+	fmt.Println("  -caldav                     启用CalDAV和CardDAV服务 (/dav/)，需要启用认证 (默认: 禁用)")
+	fmt.Println("  -caldav-dir <目录>          日历和通讯录的存储目录 (默认: caldav)")
 	fmt.Println("  -port, -p <端口>           指定服务器端口 (默认: 8080)")
+	fmt.Println("  -tls-cert <文件>            HTTPS证书文件 (修改后自动重新加载)")
+	fmt.Println("  -tls-key <文件>             HTTPS私钥文件")
+	fmt.Println("  -tls-self-signed            自动生成自签名证书并启用HTTPS")
+	fmt.Println("  -tls-redirect-port <端口>   监听HTTP端口并重定向到HTTPS (默认: 不启用)")
+	fmt.Println("  -acme-domains <域名>        通过ACME自动申请证书，多个域名用逗号分隔")
+	fmt.Println("  -acme-email <邮箱>          ACME账户联系邮箱")
+	fmt.Println("  -acme-directory <URL>       ACME服务目录 (默认: Let's Encrypt)")
+	fmt.Println("  -acme-ca-root <文件>        信任的ACME服务根证书 (本地测试CA)")
+	fmt.Println("  -tls-client-ca <文件>       客户端证书CA，启用客户端证书认证 (mTLS)")
+	fmt.Println("  -tls-client-auth <模式>     客户端证书验证模式: required|optional (默认: required)")
+	fmt.Println("  -tls-client-identity <字段> 用作用户名的证书字段: cn|email|dns|uri|subject (默认: cn)")
+	fmt.Println("  -tls-client-map <文件>      证书到用户名的映射文件")
+	fmt.Println("  -htpasswd <文件>            启用Basic认证的htpasswd用户文件 (修改后自动重新加载)")
+	fmt.Println("  -auth-static <级别>         静态文件认证要求: none|write|all (默认: none)")
+	fmt.Println("  -auth-upload <级别>         文件上传认证要求: none|write|all (默认: all)")
+	fmt.Println("  -auth-webdav <级别>         WebDAV认证要求: none|write|all (默认: all)")
+	fmt.Println("  -oidc-issuer <URL>          OpenID Connect身份提供方地址，启用单点登录")
+	fmt.Println("  -oidc-client-id <ID>        OpenID Connect客户端ID")
+	fmt.Println("  -oidc-client-secret <密钥>  OpenID Connect客户端密钥 (公共客户端可留空)")
+	fmt.Println("  -oidc-redirect-url <URL>    OpenID Connect回调地址 (默认: 自动生成 /oidc/callback)")
+	fmt.Println("  -oidc-scopes <列表>         请求的scope (默认: openid profile email)")
+	fmt.Println("  -oidc-username-claim <声明> 用作用户名的声明 (默认: preferred_username)")
+	fmt.Println("  -oidc-groups-claim <声明>   用作用户组的声明 (默认: groups)")
+	fmt.Println("  -oidc-group-map <映射>      组映射，格式: 远程组=本地组,...")
+	fmt.Println("  -totp-issuer <名称>         身份验证器应用中显示的服务名称 (默认: sweb)")
+	fmt.Println("  -lockout-threshold <次数>   同一用户认证失败多少次后锁定 (默认: 5，0表示不限制)")
+	fmt.Println("  -lockout-ip-threshold <次数> 同一IP认证失败多少次后锁定 (默认: 20，0表示不限制)")
+	fmt.Println("  -lockout-duration <时长>    首次锁定时长，之后每次翻倍 (默认: 1m)")
+	fmt.Println("  -lockout-max <时长>         锁定时长上限 (默认: 1h)")
+	fmt.Println("  -session-ttl <时长>         登录会话的空闲过期时间 (默认: 12h)")
+	fmt.Println("  -admin-users <用户>         管理员用户，多个用逗号分隔 (admin组成员同样视为管理员)")
+	fmt.Println("  -acl-file <文件>            访问控制列表文件 (JSON格式)")
+	fmt.Println("  -trusted-proxies <地址段>   可信反向代理，只信任来自这些地址的X-Forwarded-For/Forwarded头")
+	fmt.Println("  -proxy-protocol            接受来自可信反向代理的PROXY协议 (v1/v2) 连接")
+	fmt.Println("  -ip-allow <地址段>          只允许这些地址段访问，多个用逗号分隔")
+	fmt.Println("  -ip-deny <地址段>           拒绝这些地址段访问，多个用逗号分隔")
+	fmt.Println("  -ip-allow-<挂载点> <地址段> 只允许这些地址段访问 static、upload 或 webdav")
+	fmt.Println("  -ip-deny-<挂载点> <地址段>  拒绝这些地址段访问 static、upload 或 webdav")
+	fmt.Println("  -access-log <文件>          访问日志文件，\"-\" 表示标准输出 (默认: 不记录)")
+	fmt.Println("  -data-dir <目录>            程序状态数据目录 (默认: .sweb)")
 	fmt.Println("  -help, -h                  显示此帮助信息")
 	fmt.Println()
 	fmt.Println("示例:")
@@ -206,6 +476,11 @@ func showHelpInfo() {
 	fmt.Println("  sweb.exe -webdav -webdav-readonly  # 启动只读WebDAV服务")
 	fmt.Println("  sweb.exe -webdav -webdav-dir /data # 指定WebDAV目录")
 	fmt.Println("  sweb.exe -upload -webdav -p 9000   # 启用所有功能并指定端口")
+	fmt.Println("  sweb.exe -webdav -tls-self-signed  # 使用自签名证书启用HTTPS")
+	fmt.Println("  sweb.exe -tls-cert a.crt -tls-key a.key -p 443 -tls-redirect-port 80")
+	fmt.Println("  sweb.exe -acme-domains files.example.com -p 443 -tls-redirect-port 80")
+	fmt.Println("  sweb.exe passwd -f users.htpasswd alice  # 添加或更新用户")
+	fmt.Println("  sweb.exe -upload -webdav -htpasswd users.htpasswd -auth-webdav write")
 	fmt.Println()
 	fmt.Println("WebDAV访问:")
 	fmt.Println("  WebDAV地址: http://localhost:8080/webdav")
@@ -215,6 +490,7 @@ func showHelpInfo() {
 	fmt.Println("安全说明:")
 	fmt.Println("  文件上传和WebDAV功能默认禁用以确保服务器安全。")
 	fmt.Println("  只有在明确需要时才使用相应参数启用。")
+	fmt.Println("  指定 -htpasswd 后，上传和WebDAV默认要求Basic认证。")
 }
 
 // uploadDisabledHandler 处理上传功能被禁用时的请求
@@ -302,6 +578,7 @@ func uploadStatusHandler(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"upload": map[string]interface{}{
 			"enabled": uploadEnabled,
+			"dropbox": dropboxEnabled,
 			"status": func() string {
 				if uploadEnabled {
 					return "enabled"
@@ -309,10 +586,64 @@ func uploadStatusHandler(w http.ResponseWriter, r *http.Request) {
 				return "disabled"
 			}(),
 		},
+		"tls": map[string]interface{}{
+			"enabled": tlsEnabled(),
+			"mode":    tlsMode(),
+			"acme":    acmeStatus(),
+			"client_auth": map[string]interface{}{
+				"enabled": mtlsEnabled(),
+				"mode":    tlsClientAuth,
+			},
+		},
+		"auth": map[string]interface{}{
+			"enabled": authEnabled(),
+			"login":   formLoginEnabled(),
+			"oidc":    oidcEnabled(),
+			"tokens":  apiTokens != nil,
+			"2fa":     twoFactor != nil,
+			"lockout": lockoutEnabled(),
+			"static":  authStatic,
+			"upload":  authUpload,
+			"webdav":  authWebDAV,
+		},
+		"acl": map[string]interface{}{
+			"enabled": aclEnabled(),
+		},
+		"ip_filter": map[string]interface{}{
+			"enabled":         globalIPFilter != nil || len(mountIPFilters) > 0,
+			"trusted_proxies": len(trustedProxyList) > 0,
+			"proxy_protocol":  proxyProtocol,
+		},
+		"share_links":   shareLinkStatus(),
+		"file_versions": fileVersionStatus(),
+		"caldav":        caldavStatus(),
 		"webdav": map[string]interface{}{
-			"enabled":   webdavEnabled,
-			"readonly":  webdavReadonly,
-			"directory": webdavDir,
+			"enabled":     webdavEnabled,
+			"readonly":    webdavReadonly,
+			"directory":   webdavDir,
+			"user_homes":  webdavUserHomes,
+			"quota":       webdavQuota,
+			"policy":      webdavPolicyStatus(),
+			"apple_files": webdavAppleFiles,
+			"trash":       trashStatus(),
+			"properties": func() int {
+				if davProps == nil {
+					return 0
+				}
+				return davProps.count()
+			}(),
+			"sync": func() interface{} {
+				if davSync == nil {
+					return nil
+				}
+				return map[string]interface{}{"token": davSync.currentToken(), "files": davSync.count()}
+			}(),
+			"locks": func() int {
+				if davLocks == nil {
+					return 0
+				}
+				return davLocks.count()
+			}(),
 			"status": func() string {
 				if webdavEnabled {
 					if webdavReadonly {
@@ -339,52 +670,70 @@ func setupWebDAVHandler() {
 	}
 
 	// 创建WebDAV处理器
+	setupWebDAVPolicy()
+	setupWebDAVQuota()
+	webdavFS = buildWebDAVFileSystem()
+	// 同步索引扫描的存储目录（包括共享文件夹）在构造文件系统时确定
+	setupWebDAVSync()
 	handler := &webdav.Handler{
 		Prefix:     "/webdav",
-		FileSystem: webdav.Dir(webdavDir),
-		LockSystem: webdav.NewMemLS(),
-		Logger: func(r *http.Request, err error) {
-			if err != nil {
-				// 过滤掉一些常见的非关键错误
-				errStr := err.Error()
-				// 忽略文件不存在的PROPFIND错误（这在文件创建过程中是正常的）
-				if r.Method == "PROPFIND" && (strings.Contains(errStr, "cannot find the file specified") ||
-					strings.Contains(errStr, "no such file or directory") ||
-					strings.Contains(errStr, "file does not exist")) {
-					// 这些是正常的操作流程，不记录错误
-					return
-				}
-				// 记录其他重要错误
-				log.Printf("WebDAV操作: %s %s - %v", r.Method, r.URL.Path, err)
-			}
-		},
+		FileSystem: webdavFS,
+		LockSystem: setupWebDAVLocks(),
+		Logger:     webdavLogger,
 	}
 
-	// 如果是只读模式，包装处理器
-	if webdavReadonly {
-		http.HandleFunc("/webdav/", func(w http.ResponseWriter, r *http.Request) {
-			// 只允许GET、HEAD、OPTIONS、PROPFIND方法
-			switch r.Method {
-			case "GET", "HEAD", "OPTIONS", "PROPFIND":
-				handler.ServeHTTP(w, r)
-			default:
-				http.Error(w, "WebDAV服务处于只读模式", http.StatusMethodNotAllowed)
-			}
-		})
-		// 处理根路径
-		http.HandleFunc("/webdav", func(w http.ResponseWriter, r *http.Request) {
-			// 只允许GET、HEAD、OPTIONS、PROPFIND方法
-			switch r.Method {
-			case "GET", "HEAD", "OPTIONS", "PROPFIND":
-				handler.ServeHTTP(w, r)
-			default:
-				http.Error(w, "WebDAV服务处于只读模式", http.StatusMethodNotAllowed)
-			}
-		})
-	} else {
-		http.Handle("/webdav/", handler)
-		http.Handle("/webdav", handler)
+	webdavReports.fs, webdavReports.handler = webdavFS, handler
+
+	// 访问策略决定每个请求允许的方法，ACL进一步按路径限制
+	dav := requireAuth("webdav", webdavPolicyMiddleware(webdavACLMiddleware(webdavSearchMiddleware(webdavReports.middleware(webdavQuotaMiddleware(fileVersionHandler(scopedLockHandler(handler))))))))
+	http.Handle("/webdav/", dav)
+	http.Handle("/webdav", dav)
+}
+
+// webdavLogger 记录WebDAV处理器中的错误
+func webdavLogger(r *http.Request, err error) {
+	if err != nil {
+		// 过滤掉一些常见的非关键错误
+		errStr := err.Error()
+		// 忽略文件不存在的PROPFIND错误（这在文件创建过程中是正常的）
+		if r.Method == "PROPFIND" && (strings.Contains(errStr, "cannot find the file specified") ||
+			strings.Contains(errStr, "no such file or directory") ||
+			strings.Contains(errStr, "file does not exist")) {
+			// 这些是正常的操作流程，不记录错误
+			return
+		}
+		// 记录其他重要错误
+		log.Printf("WebDAV操作: %s %s - %v", r.Method, r.URL.Path, err)
+	}
+}
+
+// buildWebDAVFileSystem 根据配置构造WebDAV使用的文件系统
+func buildWebDAVFileSystem() webdav.FileSystem {
+	var fs webdav.FileSystem = webdav.Dir(webdavDir)
+	if webdavUserHomes {
+		shared, err := parseSharedFolders(webdavShared)
+		if err != nil {
+			log.Fatalf("无法配置共享文件夹: %v", err)
+		}
+		fmt.Printf("✅ WebDAV用户主目录已启用: %s\n", filepath.Join(webdavDir, "users", "<用户名>"))
+		home := newUserHomeFS(webdavDir, shared)
+		fs, webdavRealPath, webdavHomes = home, home.realPath, home
+	}
+	reserveWebDAVDir(dataDir, "状态数据目录")
+	if dropboxEnabled {
+		// 投递的文件和回执不能被列出、搜索或同步，也不能被覆盖
+		reserveWebDAVDir(dropboxDir, "投递箱目录")
+	}
+	if caldavEnabled {
+		// 日历和通讯录只能通过 /dav/ 访问，以执行按用户的权限检查和内容校验
+		reserveWebDAVDir(caldavDir, "日历和通讯录目录")
+	}
+	fs = &reservedFS{FileSystem: setupWebDAVAppleFiles(setupWebDAVVersions(setupWebDAVTrash(fs)))}
+	if aclEnabled() {
+		fs = &aclFS{FileSystem: fs, prefix: "/webdav"}
 	}
+	// 属性层、同步层和配额层位于最外层，使其提供的属性能被webdav处理器识别
+	return &quotaFS{FileSystem: &syncFS{FileSystem: setupWebDAVProps(fs)}}
 }
 
 // webdavDisabledHandler 处理WebDAV功能被禁用时的请求
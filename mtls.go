@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+// 客户端证书认证 (mTLS) 相关配置
+var (
+	tlsClientCA       string
+	tlsClientAuth     string
+	tlsClientIdentity string
+	tlsClientMapFile  string
+)
+
+// clientCertMapping 将证书字段映射到用户名的规则
+type clientCertMapping struct {
+	field string // cn, email, dns, uri, subject
+	value string
+	user  string
+}
+
+var clientCertMappings []clientCertMapping
+
+// mtlsEnabled 判断是否启用了客户端证书认证
+func mtlsEnabled() bool {
+	return tlsClientCA != ""
+}
+
+// validClientCertField 判断证书字段名是否受支持
+func validClientCertField(field string) bool {
+	switch field {
+	case "cn", "email", "dns", "uri", "subject":
+		return true
+	}
+	return false
+}
+
+// applyClientAuth 在TLS配置中启用客户端证书验证
+func applyClientAuth(cfg *tls.Config) error {
+	pemData, err := os.ReadFile(tlsClientCA)
+	if err != nil {
+		return fmt.Errorf("无法读取客户端CA证书: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return fmt.Errorf("客户端CA证书无效: %s", tlsClientCA)
+	}
+
+	var clientAuth tls.ClientAuthType
+	switch tlsClientAuth {
+	case "required":
+		clientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return fmt.Errorf("无效的客户端证书验证模式: %s (可选: required, optional)", tlsClientAuth)
+	}
+	if !validClientCertField(tlsClientIdentity) {
+		return fmt.Errorf("无效的客户端证书身份字段: %s (可选: cn, email, dns, uri, subject)", tlsClientIdentity)
+	}
+	if tlsClientMapFile != "" {
+		if clientCertMappings, err = loadClientCertMappings(tlsClientMapFile); err != nil {
+			return err
+		}
+	}
+
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = clientAuth
+	if clientAuth == tls.RequireAndVerifyClientCert {
+		// ACME的TLS-ALPN-01验证请求不会携带客户端证书
+		base := cfg
+		cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if !isACMEChallengeHello(hello) {
+				return nil, nil
+			}
+			relaxed := base.Clone()
+			relaxed.GetConfigForClient = nil
+			relaxed.ClientAuth = tls.NoClientCert
+			relaxed.NextProtos = []string{acme.ALPNProto}
+			return relaxed, nil
+		}
+	}
+	fmt.Printf("✅ 客户端证书认证已启用 (%s)\n", tlsClientAuth)
+	return nil
+}
+
+// loadClientCertMappings 加载证书到用户名的映射文件
+//
+// 文件每行格式为 "<字段>:<值> <用户名>"，例如:
+//
+//	cn:backup-bot            backup
+//	email:ops@example.com    ops
+//	subject:CN=sync,O=Corp   sync
+func loadClientCertMappings(path string) ([]clientCertMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取证书映射文件: %v", err)
+	}
+	defer f.Close()
+
+	var mappings []clientCertMapping
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// 用户名位于最后一列，匹配值中允许包含空格（如subject）
+		idx := strings.LastIndexAny(line, " \t")
+		if idx < 0 {
+			return nil, fmt.Errorf("证书映射文件第%d行格式错误", lineNo)
+		}
+		matcher, user := strings.TrimSpace(line[:idx]), line[idx+1:]
+		field, value, ok := strings.Cut(matcher, ":")
+		if !ok || !validClientCertField(field) {
+			return nil, fmt.Errorf("证书映射文件第%d行字段无效: %s", lineNo, matcher)
+		}
+		mappings = append(mappings, clientCertMapping{field: field, value: value, user: user})
+	}
+	return mappings, scanner.Err()
+}
+
+// clientCertValues 返回证书中指定字段的所有取值
+func clientCertValues(cert *x509.Certificate, field string) []string {
+	switch field {
+	case "cn":
+		if cert.Subject.CommonName != "" {
+			return []string{cert.Subject.CommonName}
+		}
+	case "email":
+		return cert.EmailAddresses
+	case "dns":
+		return cert.DNSNames
+	case "uri":
+		var uris []string
+		for _, u := range cert.URIs {
+			uris = append(uris, u.String())
+		}
+		return uris
+	case "subject":
+		return []string{cert.Subject.String()}
+	}
+	return nil
+}
+
+// clientCertUser 根据映射规则或身份字段确定证书对应的用户名
+func clientCertUser(cert *x509.Certificate) string {
+	if tlsClientMapFile != "" {
+		for _, m := range clientCertMappings {
+			for _, v := range clientCertValues(cert, m.field) {
+				if strings.EqualFold(v, m.value) {
+					return m.user
+				}
+			}
+		}
+		return ""
+	}
+	if values := clientCertValues(cert, tlsClientIdentity); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// clientCertMiddleware 将已验证的客户端证书映射为请求身份
+func clientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			if user := clientCertUser(r.TLS.VerifiedChains[0][0]); user != "" {
+				setRequestIdentity(r, &identity{Name: user, Method: "mtls"})
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connect相关配置
+var (
+	oidcIssuer        string
+	oidcClientID      string
+	oidcClientSecret  string
+	oidcRedirectURL   string
+	oidcScopes        string
+	oidcUsernameClaim string
+	oidcGroupsClaim   string
+	oidcGroupMap      string
+)
+
+// oidcGroupMapping 身份提供方的组名 -> 本地组名
+var oidcGroupMapping map[string]string
+
+// oidcHTTPClient 访问身份提供方使用的HTTP客户端
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcClockSkew 校验ID令牌时间时允许的时钟偏差
+const oidcClockSkew = time.Minute
+
+// oidcEnabled 判断是否启用了OpenID Connect登录
+func oidcEnabled() bool {
+	return oidcIssuer != ""
+}
+
+// setupOIDC 校验OpenID Connect配置
+func setupOIDC() {
+	if !oidcEnabled() {
+		return
+	}
+	if oidcClientID == "" {
+		log.Fatal("启用OpenID Connect需要指定 -oidc-client-id")
+	}
+	oidcIssuer = strings.TrimRight(oidcIssuer, "/")
+	oidcGroupMapping = make(map[string]string)
+	for _, item := range strings.Split(oidcGroupMap, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(from) == "" || strings.TrimSpace(to) == "" {
+			log.Fatalf("组映射格式错误: %s (应为 远端组=本地组)", item)
+		}
+		oidcGroupMapping[strings.TrimSpace(from)] = strings.TrimSpace(to)
+	}
+	// 启动时尝试获取配置，失败时在首次登录时重试
+	if _, err := oidcProvider.discover(); err != nil {
+		log.Printf("警告：无法获取OpenID Connect配置，将在登录时重试: %v", err)
+	}
+	fmt.Printf("✅ OpenID Connect登录已启用 - 身份提供方: %s\n", oidcIssuer)
+}
+
+// oidcMetadata 身份提供方的发现文档中使用到的字段
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProviderState 缓存的发现文档和签名公钥
+type oidcProviderState struct {
+	mu        sync.Mutex
+	meta      *oidcMetadata
+	keys      map[string]crypto.PublicKey
+	keysFetch time.Time
+}
+
+var oidcProvider = &oidcProviderState{}
+
+// getJSON 获取并解析JSON文档
+func getJSON(rawURL string, v interface{}) error {
+	resp, err := oidcHTTPClient.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回状态码 %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover 获取并缓存身份提供方的发现文档
+func (p *oidcProviderState) discover() (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	meta := &oidcMetadata{}
+	if err := getJSON(oidcIssuer+"/.well-known/openid-configuration", meta); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(meta.Issuer, "/") != oidcIssuer {
+		return nil, fmt.Errorf("发现文档中的issuer不匹配: %s", meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("发现文档缺少必要的端点")
+	}
+	p.meta = meta
+	return meta, nil
+}
+
+// publicKey 返回指定kid的签名公钥，未知的kid会触发重新获取JWKS（每分钟至多一次）
+func (p *oidcProviderState) publicKey(meta *oidcMetadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetch) < time.Minute && p.keys != nil {
+		return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+	}
+	p.keysFetch = time.Now()
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(meta.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	p.keys = make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			p.keys[jwk.Kid] = key
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+}
+
+// jsonWebKey JWKS中的单个公钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey 将JWK转换为RSA或ECDSA公钥
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("无效的RSA公钥指数")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("不支持的椭圆曲线: %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %s", k.Kty)
+}
+
+// verifyJWTSignature 使用公钥校验JWT签名，只接受RS*和ES*算法
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("不支持的签名算法: %s", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return errors.New("签名算法与密钥类型不匹配")
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(sig) != 2*size {
+			return errors.New("签名算法与密钥类型不匹配")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("签名无效")
+		}
+		return nil
+	}
+	return errors.New("不支持的密钥类型")
+}
+
+// verifyIDToken 校验ID令牌的签名、签发者、受众、有效期和nonce，返回其中的声明
+func verifyIDToken(meta *oidcMetadata, token, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("ID令牌格式错误")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil {
+		return nil, errors.New("ID令牌头部格式错误")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("ID令牌签名格式错误")
+	}
+	key, err := oidcProvider.publicKey(meta, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("ID令牌签名校验失败: %v", err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("ID令牌内容格式错误")
+	}
+	claims := make(map[string]interface{})
+	dec := json.NewDecoder(strings.NewReader(string(payload)))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, errors.New("ID令牌内容格式错误")
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != oidcIssuer {
+		return nil, fmt.Errorf("ID令牌签发者不匹配: %s", iss)
+	}
+	audiences := claimStrings(claims["aud"])
+	if !containsString(audiences, oidcClientID) {
+		return nil, errors.New("ID令牌的受众不包含本应用")
+	}
+	if azp, ok := claims["azp"].(string); (ok || len(audiences) > 1) && azp != oidcClientID {
+		return nil, errors.New("ID令牌的azp与本应用不匹配")
+	}
+	now := time.Now()
+	exp, ok := claimTime(claims["exp"])
+	if !ok || now.After(exp.Add(oidcClockSkew)) {
+		return nil, errors.New("ID令牌已过期")
+	}
+	if iat, ok := claimTime(claims["iat"]); ok && iat.After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("ID令牌的签发时间无效")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("ID令牌的nonce不匹配")
+	}
+	return claims, nil
+}
+
+// claimStrings 将字符串或字符串数组形式的声明转换为字符串列表
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' })
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// claimTime 解析NumericDate形式的时间声明
+func claimTime(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// oidcIdentity 根据ID令牌声明生成本地用户身份，组名按 -oidc-group-map 映射
+func oidcIdentity(claims map[string]interface{}) (*identity, error) {
+	name, _ := claims[oidcUsernameClaim].(string)
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+	if !validPathSegment(name) || strings.ContainsAny(name, ":\r\n") {
+		return nil, fmt.Errorf("无效的用户名: %q", name)
+	}
+	id := &identity{Name: name, Method: "oidc"}
+	for _, group := range claimStrings(claims[oidcGroupsClaim]) {
+		if mapped, ok := oidcGroupMapping[group]; ok {
+			group = mapped
+		}
+		if !containsString(id.Groups, group) {
+			id.Groups = append(id.Groups, group)
+		}
+	}
+	return id, nil
+}
+
+// oidcLoginState 授权请求发出后等待回调的登录状态
+type oidcLoginState struct {
+	verifier string // PKCE code_verifier
+	nonce    string
+	next     string
+	expires  time.Time
+}
+
+// oidcStateTTL 用户在身份提供方完成登录的最长时间
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookieName 将回调与发起登录的浏览器绑定的Cookie
+const oidcStateCookieName = "sweb_oidc_state"
+
+// oidcMaxStates 同时等待回调的登录数上限。任何人都能请求 /oidc/login，不设上限时重复请求会让内存持续增长
+const oidcMaxStates = 1000
+
+var (
+	oidcStatesMu sync.Mutex
+	oidcStates   = make(map[string]*oidcLoginState)
+)
+
+// storeOIDCState 保存等待回调的登录状态：先清理过期的状态，达到 oidcMaxStates 时丢弃最早发起的登录
+func storeOIDCState(stateID string, state *oidcLoginState) {
+	oidcStatesMu.Lock()
+	defer oidcStatesMu.Unlock()
+	now := time.Now()
+	for id, s := range oidcStates {
+		if now.After(s.expires) {
+			delete(oidcStates, id)
+		}
+	}
+	for len(oidcStates) >= oidcMaxStates {
+		oldest := ""
+		for id, s := range oidcStates {
+			if oldest == "" || s.expires.Before(oidcStates[oldest].expires) {
+				oldest = id
+			}
+		}
+		delete(oidcStates, oldest)
+	}
+	oidcStates[stateID] = state
+}
+
+// oidcCallbackURL 返回授权回调地址
+func oidcCallbackURL(r *http.Request) string {
+	if oidcRedirectURL != "" {
+		return oidcRedirectURL
+	}
+	return requestBaseURL(r) + "/oidc/callback"
+}
+
+// oidcLoginHandler 处理 /oidc/login：生成state、nonce和PKCE参数后跳转到身份提供方
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	meta, err := oidcProvider.discover()
+	if err != nil {
+		log.Printf("无法获取OpenID Connect配置: %v", err)
+		http.Error(w, "单点登录暂时不可用", http.StatusBadGateway)
+		return
+	}
+
+	state := &oidcLoginState{verifier: randomToken(), nonce: randomToken(),
+		next: safeRedirectTarget(r.FormValue("next")), expires: time.Now().Add(oidcStateTTL)}
+	stateID := randomToken()
+	storeOIDCState(stateID, state)
+
+	challenge := sha256.Sum256([]byte(state.verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", oidcClientID)
+	q.Set("redirect_uri", oidcCallbackURL(r))
+	q.Set("scope", oidcScopes)
+	q.Set("state", stateID)
+	q.Set("nonce", state.nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookieName, Value: stateID, Path: "/oidc/",
+		MaxAge: int(oidcStateTTL.Seconds()), HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, meta.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// oidcCallbackHandler 处理 /oidc/callback：用授权码换取ID令牌，校验后创建登录会话
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	fail := func(msg string, err error) {
+		log.Printf("OpenID Connect登录失败 (来自 %s): %s: %v", requestClientIP(r), msg, err)
+		renderLoginPage(w, r, http.StatusUnauthorized, map[string]interface{}{"Next": "/", "Error": "单点登录失败: " + msg})
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		fail("身份提供方拒绝了请求", errors.New(e+" "+q.Get("error_description")))
+		return
+	}
+	stateID := q.Get("state")
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil || stateID == "" || cookie.Value != stateID {
+		fail("登录状态无效，请重新登录", errors.New("state不匹配"))
+		return
+	}
+	oidcStatesMu.Lock()
+	state, ok := oidcStates[stateID]
+	delete(oidcStates, stateID)
+	oidcStatesMu.Unlock()
+	if !ok || time.Now().After(state.expires) {
+		fail("登录已超时，请重新登录", errors.New("state已过期"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookieName, Value: "", Path: "/oidc/", MaxAge: -1})
+
+	meta, err := oidcProvider.discover()
+	if err != nil {
+		fail("无法获取身份提供方配置", err)
+		return
+	}
+	idToken, err := oidcExchangeCode(meta, q.Get("code"), state.verifier, oidcCallbackURL(r))
+	if err != nil {
+		fail("无法获取令牌", err)
+		return
+	}
+	claims, err := verifyIDToken(meta, idToken, state.nonce)
+	if err != nil {
+		fail("ID令牌无效", err)
+		return
+	}
+	id, err := oidcIdentity(claims)
+	if err != nil {
+		fail("无法确定用户名", err)
+		return
+	}
+
+	if oldID, sess := requestSession(r); sess != nil {
+		sessions.remove(oldID)
+	}
+	sessionID, _ := sessions.create(id)
+	setCookie(w, r, sessionCookieName, sessionID, 0, http.SameSiteLaxMode)
+	log.Printf("OpenID Connect登录成功: 用户 %s (组: %s) 来自 %s", id.Name, strings.Join(id.Groups, ","), requestClientIP(r))
+	http.Redirect(w, r, state.next, http.StatusSeeOther)
+}
+
+// oidcExchangeCode 在令牌端点用授权码和PKCE code_verifier换取ID令牌
+func oidcExchangeCode(meta *oidcMetadata, code, verifier, redirectURI string) (string, error) {
+	if code == "" {
+		return "", errors.New("缺少授权码")
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", oidcClientID)
+
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if oidcClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(oidcClientID), url.QueryEscape(oidcClientSecret))
+	}
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("令牌端点返回状态码 %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return "", fmt.Errorf("令牌端点返回错误: %s %s", result.Error, result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return "", errors.New("令牌端点没有返回ID令牌")
+	}
+	return result.IDToken, nil
+}
@@ -0,0 +1,234 @@
+//go:build oidcmock
+
+// 测试身份提供方只在以 -tags oidcmock 构建时包含，发布版本中不包含能签发令牌的服务
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mockIssuer 用于本地测试的OpenID Connect身份提供方，自动批准所有授权请求
+type mockIssuer struct {
+	issuer   string
+	clientID string
+	secret   string
+	user     string
+	email    string
+	groups   []string
+	key      *rsa.PrivateKey
+	kid      string
+
+	mu    sync.Mutex
+	codes map[string]mockAuthCode
+}
+
+// mockAuthCode 已签发但尚未兑换的授权码
+type mockAuthCode struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	expires     time.Time
+}
+
+// runOIDCCommand 实现 "sweb oidc mock" 子命令，启动本地测试用的身份提供方
+func runOIDCCommand(args []string) {
+	if len(args) == 0 || args[0] != "mock" {
+		fmt.Println("用法: sweb oidc mock [-port 端口] [-client-id ID] [-client-secret 密钥] [-user 用户] [-email 邮箱] [-groups 组1,组2]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("oidc mock", flag.ExitOnError)
+	port := fs.Int("port", 9000, "监听端口")
+	clientID := fs.String("client-id", "sweb", "允许的客户端ID")
+	secret := fs.String("client-secret", "", "客户端密钥 (为空表示公共客户端)")
+	user := fs.String("user", "alice", "登录的用户名 (preferred_username)")
+	email := fs.String("email", "", "用户邮箱")
+	groups := fs.String("groups", "", "用户所属的组，多个用逗号分隔")
+	fs.Parse(args[1:])
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("无法生成签名密钥: %v", err)
+	}
+	m := &mockIssuer{
+		issuer:   fmt.Sprintf("http://localhost:%d", *port),
+		clientID: *clientID,
+		secret:   *secret,
+		user:     *user,
+		email:    *email,
+		key:      key,
+		kid:      randomToken()[:8],
+		codes:    make(map[string]mockAuthCode),
+	}
+	for _, g := range strings.Split(*groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			m.groups = append(m.groups, g)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/jwks", m.jwks)
+	fmt.Printf("🧪 测试用OpenID Connect身份提供方: %s (客户端: %s, 用户: %s, 组: %s)\n", m.issuer, m.clientID, m.user, *groups)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), mux))
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.issuer,
+		"authorization_endpoint":                m.issuer + "/authorize",
+		"token_endpoint":                        m.issuer + "/token",
+		"jwks_uri":                              m.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := m.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []jsonWebKey{{
+			Kty: "RSA", Kid: m.kid, Use: "sig", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize 校验授权请求后立即签发授权码并跳转回客户端
+func (m *mockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	switch {
+	case q.Get("client_id") != m.clientID:
+		http.Error(w, "未知的client_id", http.StatusBadRequest)
+		return
+	case q.Get("response_type") != "code":
+		http.Error(w, "仅支持授权码模式", http.StatusBadRequest)
+		return
+	case redirectURI == "":
+		http.Error(w, "缺少redirect_uri", http.StatusBadRequest)
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		http.Error(w, "需要S256 PKCE参数", http.StatusBadRequest)
+		return
+	}
+	code := randomToken()
+	m.mu.Lock()
+	m.codes[code] = mockAuthCode{clientID: m.clientID, redirectURI: redirectURI, nonce: q.Get("nonce"),
+		challenge: q.Get("code_challenge"), expires: time.Now().Add(time.Minute)}
+	m.mu.Unlock()
+
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "redirect_uri无效", http.StatusBadRequest)
+		return
+	}
+	params := target.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// token 兑换授权码，校验PKCE后签发ID令牌
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code, desc string) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": desc})
+	}
+	if r.Method != http.MethodPost {
+		tokenError("invalid_request", "需要POST请求")
+		return
+	}
+	clientID, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != m.clientID || secret != m.secret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		tokenError("unsupported_grant_type", "")
+		return
+	}
+
+	m.mu.Lock()
+	code, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	m.mu.Unlock()
+	if !ok || time.Now().After(code.expires) || code.redirectURI != r.PostFormValue("redirect_uri") {
+		tokenError("invalid_grant", "授权码无效或已过期")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		tokenError("invalid_grant", "PKCE校验失败")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                m.issuer,
+		"sub":                "mock-" + m.user,
+		"aud":                m.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              code.nonce,
+		"preferred_username": m.user,
+		"groups":             m.groups,
+	}
+	if m.email != "" {
+		claims["email"] = m.email
+	}
+	idToken, err := m.sign(claims)
+	if err != nil {
+		tokenError("server_error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomToken(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// sign 生成RS256签名的JWT
+func (m *mockIssuer) sign(claims map[string]interface{}) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": m.kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
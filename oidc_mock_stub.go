@@ -0,0 +1,14 @@
+//go:build !oidcmock
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runOIDCCommand 发布版本中不包含测试身份提供方
+func runOIDCCommand(args []string) {
+	fmt.Println("此版本不包含测试用的OpenID Connect身份提供方，请使用 go build -tags oidcmock 构建")
+	os.Exit(2)
+}
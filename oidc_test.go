@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestJWT 用RSA密钥生成RS256签名的JWT，alg只写入头部，便于构造算法不符的令牌
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidcIssuer, oidcClientID = "https://idp.example.com", "sweb"
+	oidcProvider = &oidcProviderState{keys: map[string]crypto.PublicKey{"test": &key.PublicKey}, keysFetch: time.Now()}
+	defer func() {
+		oidcIssuer, oidcClientID = "", ""
+		oidcProvider = &oidcProviderState{}
+	}()
+	meta := &oidcMetadata{Issuer: oidcIssuer}
+
+	now := time.Now().Unix()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": oidcIssuer, "aud": "sweb", "sub": "u1", "nonce": "n1",
+			"iat": now, "exp": now + 300,
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	valid := signTestJWT(t, key, "RS256", claims(nil))
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"有效令牌", valid, true},
+		{"alg为none", signTestJWT(t, key, "none", claims(nil)), false},
+		{"alg为HS256", signTestJWT(t, key, "HS256", claims(nil)), false},
+		{"alg与RSA密钥不符", signTestJWT(t, key, "ES256", claims(nil)), false},
+		{"头部声明RS384", signTestJWT(t, key, "RS384", claims(nil)), false},
+		{"其他密钥签名", signTestJWT(t, other, "RS256", claims(nil)), false},
+		{"篡改内容", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"https://idp.example.com","aud":"sweb","sub":"admin","nonce":"n1","exp":9999999999}`)) + "." + parts[2], false},
+		{"缺少签名", parts[0] + "." + parts[1] + ".", false},
+		{"格式错误", parts[0] + "." + parts[1], false},
+		{"已过期", signTestJWT(t, key, "RS256", claims(map[string]interface{}{"exp": now - 3600})), false},
+		{"缺少exp", signTestJWT(t, key, "RS256", claims(map[string]interface{}{"exp": nil})), false},
+		{"在时钟偏差内过期", signTestJWT(t, key, "RS256", claims(map[string]interface{}{"exp": now - 10})), true},
+		{"签发时间在未来", signTestJWT(t, key, "RS256", claims(map[string]interface{}{"iat": now + 3600})), false},
+		{"签发者不符", signTestJWT(t, key, "RS256", claims(map[string]interface{}{"iss": "https://evil.example.com"})), false},
+		{"受众不符", signTestJWT(t, key, "RS256", claims(map[string]interface{}{"aud": "other"})), false},
+		{"多个受众缺少azp", signTestJWT(t, key, "RS256", claims(map[string]interface{}{"aud": []string{"sweb", "other"}})), false},
+		{"多个受众azp正确", signTestJWT(t, key, "RS256", claims(map[string]interface{}{"aud": []string{"sweb", "other"}, "azp": "sweb"})), true},
+		{"nonce不符", signTestJWT(t, key, "RS256", claims(map[string]interface{}{"nonce": "n2"})), false},
+	}
+	for _, tt := range tests {
+		_, err := verifyIDToken(meta, tt.token, "n1")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, 期望通过 = %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestStoreOIDCState(t *testing.T) {
+	defer func() { oidcStates = make(map[string]*oidcLoginState) }()
+	oidcStates = make(map[string]*oidcLoginState)
+
+	now := time.Now()
+	storeOIDCState("expired", &oidcLoginState{expires: now.Add(-time.Second)})
+	storeOIDCState("first", &oidcLoginState{expires: now.Add(time.Minute)})
+	if _, ok := oidcStates["expired"]; ok {
+		t.Fatal("过期的登录状态应被清理")
+	}
+	for i := 1; i < oidcMaxStates; i++ {
+		storeOIDCState(fmt.Sprintf("s%d", i), &oidcLoginState{expires: now.Add(oidcStateTTL + time.Duration(i))})
+	}
+	if len(oidcStates) != oidcMaxStates {
+		t.Fatalf("登录状态数 = %d, 期望 %d", len(oidcStates), oidcMaxStates)
+	}
+	storeOIDCState("last", &oidcLoginState{expires: now.Add(2 * oidcStateTTL)})
+	if len(oidcStates) != oidcMaxStates {
+		t.Fatalf("达到上限后登录状态数 = %d, 期望 %d", len(oidcStates), oidcMaxStates)
+	}
+	if _, ok := oidcStates["first"]; ok {
+		t.Error("达到上限时应丢弃最早发起的登录")
+	}
+	if _, ok := oidcStates["last"]; !ok {
+		t.Error("新的登录状态应被保存")
+	}
+}
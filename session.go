@@ -0,0 +1,421 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionTTL 会话空闲多久后过期
+var sessionTTL time.Duration
+
+// 登录会话和CSRF令牌使用的Cookie名称
+const (
+	sessionCookieName = "sweb_session"
+	csrfCookieName    = "sweb_csrf"
+)
+
+// session 服务器端保存的登录会话
+type session struct {
+	user    *identity // 登录时确定的用户身份，Method为登录方式
+	csrf    string    // 会话的CSRF令牌
+	created time.Time
+	expires time.Time
+}
+
+// sessionStore 内存中的会话存储，服务器重启后所有会话失效
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+var sessions = &sessionStore{sessions: make(map[string]*session)}
+
+// randomToken 生成URL安全的随机令牌
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// create 为用户创建新会话并返回会话ID
+func (s *sessionStore) create(user *identity) (string, *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	id := randomToken()
+	sess := &session{user: user, csrf: randomToken(), created: now, expires: now.Add(sessionTTL)}
+	s.sessions[id] = sess
+	return id, sess
+}
+
+// get 返回有效的会话并延长其有效期
+func (s *sessionStore) get(id string) (*session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	if now.After(sess.expires) {
+		delete(s.sessions, id)
+		return nil, false
+	}
+	sess.expires = now.Add(sessionTTL)
+	return sess, true
+}
+
+func (s *sessionStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// count 返回有效会话的数量
+func (s *sessionStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now, n := time.Now(), 0
+	for _, sess := range s.sessions {
+		if now.Before(sess.expires) {
+			n++
+		}
+	}
+	return n
+}
+
+// formLoginEnabled 判断是否提供登录页面（需要htpasswd用户文件或OpenID Connect）
+func formLoginEnabled() bool {
+	return basicAuthEnabled() || oidcEnabled()
+}
+
+// requestSession 返回请求Cookie对应的有效会话
+func requestSession(r *http.Request) (string, *session) {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || c.Value == "" {
+		return "", nil
+	}
+	sess, ok := sessions.get(c.Value)
+	if !ok {
+		return "", nil
+	}
+	return c.Value, sess
+}
+
+// sessionMiddleware 识别请求中的会话Cookie
+func sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if formLoginEnabled() && requestIdentity(r) == nil {
+			if id, sess := requestSession(r); sess != nil {
+				if sess.user.Method == "basic" && !htpasswdUsers.exists(sess.user.Name) {
+					// 用户已从htpasswd文件中删除
+					sessions.remove(id)
+				} else {
+					setRequestIdentity(r, &identity{Name: sess.user.Name, Groups: sess.user.Groups, Method: "session"})
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setCookie 设置HttpOnly的Cookie，通过HTTPS访问时同时设置Secure
+func setCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int, sameSite http.SameSite) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: sameSite,
+	})
+}
+
+// csrfToken 返回用于嵌入表单的CSRF令牌：已登录时使用会话令牌，否则使用Cookie中的令牌
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if _, sess := requestSession(r); sess != nil {
+		return sess.csrf
+	}
+	if c, err := r.Cookie(csrfCookieName); err == nil && len(c.Value) >= 32 {
+		return c.Value
+	}
+	token := randomToken()
+	setCookie(w, r, csrfCookieName, token, 0, http.SameSiteStrictMode)
+	return token
+}
+
+// validCSRF 校验请求携带的CSRF令牌（表单字段 csrf_token 或请求头 X-CSRF-Token）
+func validCSRF(r *http.Request) bool {
+	submitted := r.Header.Get("X-CSRF-Token")
+	if submitted == "" {
+		submitted = r.FormValue("csrf_token")
+	}
+	if submitted == "" {
+		return false
+	}
+	expected := ""
+	if _, sess := requestSession(r); sess != nil {
+		expected = sess.csrf
+	} else if c, err := r.Cookie(csrfCookieName); err == nil && len(c.Value) >= 32 {
+		expected = c.Value
+	}
+	return expected != "" && subtle.ConstantTimeCompare([]byte(submitted), []byte(expected)) == 1
+}
+
+// isSafeMethod 判断HTTP方法是否不修改服务器状态
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// csrfProtect 保护网页表单端点：除使用Bearer API令牌的请求外，所有修改操作都必须携带有效的CSRF令牌
+func csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isSafeMethod(r.Method) && !bearerTokenRequest(r) && !validCSRF(r) {
+			http.Error(w, "CSRF令牌无效，请刷新页面后重试", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerTokenRequest 判断请求是否通过 Authorization: Bearer 使用API令牌认证。
+// 浏览器不会自动附带该请求头，这类请求不需要CSRF令牌；Basic认证会被浏览器自动发送，仍需校验
+func bearerTokenRequest(r *http.Request) bool {
+	id := requestIdentity(r)
+	auth := r.Header.Get("Authorization")
+	return id != nil && id.Method == "token" && len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ")
+}
+
+// uploadCSRFProtect 保护上传端点：只有携带浏览器会自动发送的凭据（会话Cookie、Basic认证或客户端证书）的
+// 修改请求需要CSRF令牌；匿名上传没有可被冒用的凭据，使用Bearer令牌的脚本同样不受影响
+func uploadCSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestIdentity(r) != nil && !isSafeMethod(r.Method) && !bearerTokenRequest(r) && !validCSRF(r) {
+			http.Error(w, "CSRF令牌无效，请刷新页面后重试", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionCSRFProtect 保护API端点：通过会话Cookie认证的修改请求必须携带CSRF令牌，
+// 使用Basic认证或客户端证书的脚本不受影响
+func sessionCSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := requestIdentity(r); id != nil && id.Method == "session" && !isSafeMethod(r.Method) && !validCSRF(r) {
+			http.Error(w, "CSRF令牌无效", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wantsLoginPage 判断未认证的请求是否来自浏览器，应跳转到登录页面而不是弹出Basic认证对话框
+// 已识别的WebDAV客户端（如Windows资源管理器、Office）不能处理登录页面，始终返回401
+func wantsLoginPage(r *http.Request) bool {
+	return formLoginEnabled() && (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		strings.Contains(r.Header.Get("Accept"), "text/html") && detectDAVClient(r) == davClientOther
+}
+
+// safeRedirectTarget 只允许跳转到本站的相对路径
+func safeRedirectTarget(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>登录</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 360px; margin: 80px auto; padding: 0 20px; color: #333; }
+        .box { background: #f8f9fa; border: 1px solid #dee2e6; border-radius: 8px; padding: 24px; }
+        .error { color: #dc3545; }
+        input[type=text], input[type=password] { width: 100%; padding: 8px; margin: 4px 0 12px; box-sizing: border-box; }
+        .sso { display: block; text-align: center; background: #28a745; color: #fff; padding: 10px; border-radius: 4px; text-decoration: none; }
+        button { background: #007bff; color: #fff; padding: 8px 18px; border: none; border-radius: 4px; cursor: pointer; }
+    </style>
+</head>
+<body>
+<div class="box">
+{{if .User}}
+    <h2>已登录</h2>
+    <p>当前用户: {{.User}}</p>
+    <p><a href="/">返回首页</a>{{if .TwoFactor}} | <a href="/account/2fa">两步验证设置</a>{{end}}</p>
+    <form method="post" action="/logout">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <button type="submit">退出登录</button>
+    </form>
+{{else}}
+    <h2>🔑 登录</h2>
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .MFA}}
+    <form method="post" action="/login">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <input type="hidden" name="next" value="{{.Next}}">
+        <input type="hidden" name="mfa_token" value="{{.MFA}}">
+        <label>身份验证器应用中的6位验证码（或恢复码）</label>
+        <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code" autofocus>
+        <button type="submit">验证</button>
+    </form>
+    {{else}}
+    {{if .OIDC}}
+    <p><a class="sso" href="/oidc/login?next={{.Next}}">使用单点登录</a></p>
+    {{end}}
+    {{if .Password}}
+    <form method="post" action="/login">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <input type="hidden" name="next" value="{{.Next}}">
+        <label>用户名</label>
+        <input type="text" name="username" value="{{.Username}}" autocomplete="username" autofocus>
+        <label>密码</label>
+        <input type="password" name="password" autocomplete="current-password">
+        <button type="submit">登录</button>
+    </form>
+    {{end}}
+    {{end}}
+{{end}}
+</div>
+</body>
+</html>
+`))
+
+// renderLoginPage 输出登录页面
+func renderLoginPage(w http.ResponseWriter, r *http.Request, status int, data map[string]interface{}) {
+	data["CSRF"] = csrfToken(w, r)
+	data["Password"] = basicAuthEnabled()
+	data["OIDC"] = oidcEnabled()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := loginTemplate.Execute(w, data); err != nil {
+		log.Printf("无法渲染登录页面: %v", err)
+	}
+}
+
+// loginHandler 处理 /login：GET 显示登录表单，POST 验证用户名和密码并创建会话
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if !formLoginEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	next := safeRedirectTarget(r.FormValue("next"))
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		data := map[string]interface{}{"Next": next}
+		if id := authenticatedUser(r); id != nil {
+			data["User"] = id.Name
+			data["TwoFactor"] = twoFactor != nil && passwordUser(r) != nil
+		}
+		renderLoginPage(w, r, http.StatusOK, data)
+
+	case http.MethodPost:
+		if token := r.PostFormValue("mfa_token"); token != "" {
+			loginSecondFactor(w, r, token, next)
+			return
+		}
+		user, password := r.PostFormValue("username"), r.PostFormValue("password")
+		if d, blocked := throttle.check(user, requestClientIP(r)); blocked {
+			throttle.event("blocked_attempt", user, requestClientIP(r), "表单登录")
+			w.Header().Set("Retry-After", strconv.Itoa(int(d.Seconds())+1))
+			renderLoginPage(w, r, http.StatusTooManyRequests, map[string]interface{}{
+				"Next": next, "Username": user, "Error": lockoutMessage(d),
+			})
+			return
+		}
+		if !basicAuthEnabled() || !htpasswdUsers.verify(user, password) {
+			throttle.failure(user, requestClientIP(r), "表单登录")
+			renderLoginPage(w, r, http.StatusUnauthorized, map[string]interface{}{
+				"Next": next, "Username": user, "Error": "用户名或密码错误",
+			})
+			return
+		}
+		if twoFactor.enabled(user) {
+			renderLoginPage(w, r, http.StatusOK, map[string]interface{}{"Next": next, "MFA": mfaLogins.start(user)})
+			return
+		}
+		throttle.success(user)
+		startLoginSession(w, r, &identity{Name: user, Method: "basic"}, next)
+
+	default:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}
+
+// loginSecondFactor 处理登录的第二步：校验身份验证器的验证码或恢复码
+func loginSecondFactor(w http.ResponseWriter, r *http.Request, token, next string) {
+	user, ok := mfaLogins.user(token)
+	if !ok {
+		renderLoginPage(w, r, http.StatusUnauthorized, map[string]interface{}{"Next": next, "Error": "登录已超时，请重新输入密码"})
+		return
+	}
+	if d, blocked := throttle.check(user, requestClientIP(r)); blocked {
+		mfaLogins.finish(token)
+		renderLoginPage(w, r, http.StatusTooManyRequests, map[string]interface{}{"Next": next, "Error": lockoutMessage(d)})
+		return
+	}
+	if !twoFactor.verifyCode(user, r.PostFormValue("code")) {
+		throttle.failure(user, requestClientIP(r), "两步验证")
+		data := map[string]interface{}{"Next": next, "Error": "验证码错误"}
+		if mfaLogins.fail(token) {
+			data["MFA"] = token
+		} else {
+			data["Error"] = "验证码错误次数过多，请重新登录"
+		}
+		renderLoginPage(w, r, http.StatusUnauthorized, data)
+		return
+	}
+	mfaLogins.finish(token)
+	throttle.success(user)
+	startLoginSession(w, r, &identity{Name: user, Method: "basic"}, next)
+}
+
+// startLoginSession 为登录成功的用户创建会话并跳转到目标页面
+func startLoginSession(w http.ResponseWriter, r *http.Request, user *identity, next string) {
+	// 登录时总是创建新会话，防止会话固定攻击
+	if oldID, sess := requestSession(r); sess != nil {
+		sessions.remove(oldID)
+	}
+	id, _ := sessions.create(user)
+	setCookie(w, r, sessionCookieName, id, 0, http.SameSiteLaxMode)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// logoutHandler 处理 POST /logout：删除服务器端会话并清除Cookie
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	if id, sess := requestSession(r); sess != nil {
+		sessions.remove(id)
+	}
+	setCookie(w, r, sessionCookieName, "", -1, http.SameSiteLaxMode)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shareLinkDefaultTTL 未指定有效期时分享链接的默认有效期
+const shareLinkDefaultTTL = 24 * time.Hour
+
+// shareLink 签名分享链接中携带的参数，全部参与签名
+type shareLink struct {
+	Path         string    // 被分享资源的URL路径
+	ID           string    // 链接ID，用于撤销和下载计数
+	Owner        string    // 创建者，访问时以其身份进行授权检查
+	IP           string    // 绑定的客户端IP，为空表示不限制
+	Expires      time.Time // 过期时间
+	MaxDownloads int       // 最大下载次数，0表示不限制
+}
+
+// canonical 返回参与签名的规范化字符串
+func (l *shareLink) canonical() string {
+	return strings.Join([]string{
+		path.Clean("/" + l.Path), l.ID, strconv.FormatInt(l.Expires.Unix(), 10),
+		l.IP, strconv.Itoa(l.MaxDownloads), l.Owner,
+	}, "\n")
+}
+
+// sign 使用密钥计算链接的HMAC-SHA256签名
+func (l *shareLink) sign(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(l.canonical()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// url 返回带签名参数的链接
+func (l *shareLink) url(baseURL string, key []byte) string {
+	q := url.Values{}
+	q.Set("sid", l.ID)
+	q.Set("exp", strconv.FormatInt(l.Expires.Unix(), 10))
+	if l.IP != "" {
+		q.Set("ip", l.IP)
+	}
+	if l.MaxDownloads > 0 {
+		q.Set("max", strconv.Itoa(l.MaxDownloads))
+	}
+	if l.Owner != "" {
+		q.Set("u", l.Owner)
+	}
+	q.Set("sig", l.sign(key))
+	u := url.URL{Path: path.Clean("/" + l.Path), RawQuery: q.Encode()}
+	return strings.TrimRight(baseURL, "/") + u.String()
+}
+
+// parseShareLink 从请求中解析分享链接参数，请求不含签名参数时返回nil
+func parseShareLink(r *http.Request) (*shareLink, string, error) {
+	q := r.URL.Query()
+	sig := q.Get("sig")
+	if sig == "" || q.Get("sid") == "" {
+		return nil, "", nil
+	}
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil {
+		return nil, "", errors.New("分享链接参数无效")
+	}
+	l := &shareLink{
+		Path:    r.URL.Path,
+		ID:      q.Get("sid"),
+		Owner:   q.Get("u"),
+		IP:      q.Get("ip"),
+		Expires: time.Unix(exp, 0),
+	}
+	if max := q.Get("max"); max != "" {
+		if l.MaxDownloads, err = strconv.Atoi(max); err != nil || l.MaxDownloads < 0 {
+			return nil, "", errors.New("分享链接参数无效")
+		}
+	}
+	return l, sig, nil
+}
+
+// newShareLinkID 生成随机的链接ID
+func newShareLinkID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// 分享链接签名密钥，保存在 <data-dir>/share.key
+var (
+	shareKeyMu sync.Mutex
+	shareKey   []byte
+)
+
+// loadShareKey 读取签名密钥；create为true且密钥不存在时生成新密钥
+func loadShareKey(create bool) ([]byte, error) {
+	shareKeyMu.Lock()
+	defer shareKeyMu.Unlock()
+	if shareKey != nil {
+		return shareKey, nil
+	}
+
+	file := dataPath("share.key")
+	data, err := os.ReadFile(file)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < 16 {
+			return nil, fmt.Errorf("分享链接密钥文件格式错误: %s", file)
+		}
+		shareKey = key
+		return key, nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(file, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	shareKey = key
+	return key, nil
+}
+
+// shareLinkRecord 已签发或已撤销的分享链接记录
+type shareLinkRecord struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path,omitempty"`
+	Owner        string    `json:"owner,omitempty"`
+	IP           string    `json:"ip,omitempty"`
+	Created      time.Time `json:"created,omitempty"`
+	Expires      time.Time `json:"expires,omitempty"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	Revoked      bool      `json:"revoked,omitempty"`
+}
+
+// shareLinkStore 持久化的分享链接记录，包括下载计数和撤销列表；
+// 文件被其他进程（如 sweb share revoke）修改后自动重新加载
+type shareLinkStore struct {
+	path string
+
+	mu        sync.Mutex
+	links     map[string]*shareLinkRecord
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// shareLinkCheckInterval 两次检查记录文件是否变更的最小间隔
+const shareLinkCheckInterval = 2 * time.Second
+
+// shareLinks 服务器使用的分享链接记录
+var shareLinks *shareLinkStore
+
+func openShareLinkStore(file string) (*shareLinkStore, error) {
+	s := &shareLinkStore{path: file}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *shareLinkStore) reload() error {
+	var links []*shareLinkRecord
+	if err := readJSONFile(s.path, &links); err != nil {
+		return fmt.Errorf("无法读取分享链接记录: %v", err)
+	}
+	s.links = make(map[string]*shareLinkRecord, len(links))
+	for _, rec := range links {
+		s.links[rec.ID] = rec
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// refresh 在文件变更后重新加载记录，调用者需持有锁
+func (s *shareLinkStore) refresh() {
+	now := time.Now()
+	if now.Sub(s.lastCheck) < shareLinkCheckInterval {
+		return
+	}
+	s.lastCheck = now
+	if fi, err := os.Stat(s.path); err == nil && !fi.ModTime().Equal(s.modTime) {
+		if err := s.reload(); err != nil {
+			log.Printf("重新加载分享链接记录失败，继续使用旧数据: %v", err)
+		}
+	}
+}
+
+// save 写回记录文件，同时清理已过期的链接，调用者需持有锁。
+// 文件在上次加载后被其他进程修改过时先合并其中的记录，避免覆盖期间写入的撤销
+func (s *shareLinkStore) save() error {
+	if fi, err := os.Stat(s.path); err == nil && !fi.ModTime().Equal(s.modTime) {
+		var disk []*shareLinkRecord
+		if err := readJSONFile(s.path, &disk); err != nil {
+			return fmt.Errorf("无法读取分享链接记录: %v", err)
+		}
+		for _, rec := range disk {
+			cur, ok := s.links[rec.ID]
+			if !ok {
+				s.links[rec.ID] = rec
+				continue
+			}
+			cur.Revoked = cur.Revoked || rec.Revoked
+			cur.Downloads = max(cur.Downloads, rec.Downloads)
+		}
+	}
+	now := time.Now()
+	links := make([]*shareLinkRecord, 0, len(s.links))
+	for id, rec := range s.links {
+		if !rec.Expires.IsZero() && rec.Expires.Before(now) {
+			delete(s.links, id)
+			continue
+		}
+		links = append(links, rec)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Created.Before(links[j].Created) })
+	if err := writeJSONFile(s.path, links); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// add 记录新签发的链接
+func (s *shareLinkStore) add(rec *shareLinkRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = time.Time{}
+	s.refresh()
+	s.links[rec.ID] = rec
+	return s.save()
+}
+
+// get 返回链接记录的副本
+func (s *shareLinkStore) get(id string) (shareLinkRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	if rec, ok := s.links[id]; ok {
+		return *rec, true
+	}
+	return shareLinkRecord{}, false
+}
+
+// revoke 将链接加入撤销列表，未知的链接ID同样会被记录
+func (s *shareLinkStore) revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = time.Time{}
+	s.refresh()
+	rec, ok := s.links[id]
+	if !ok {
+		rec = &shareLinkRecord{ID: id, Created: time.Now()}
+		s.links[id] = rec
+	}
+	rec.Revoked = true
+	return s.save()
+}
+
+// use 检查链接是否已被撤销，download为true时检查并增加下载计数
+func (s *shareLinkStore) use(l *shareLink, download bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if download && l.MaxDownloads > 0 {
+		// 计数前总是检查文件，使刚撤销的链接不会再被下载
+		s.lastCheck = time.Time{}
+	}
+	s.refresh()
+	rec, ok := s.links[l.ID]
+	if ok && rec.Revoked {
+		return errors.New("分享链接已被撤销")
+	}
+	if !download || l.MaxDownloads == 0 {
+		return nil
+	}
+	if !ok {
+		rec = &shareLinkRecord{ID: l.ID, Path: l.Path, Owner: l.Owner, Expires: l.Expires, MaxDownloads: l.MaxDownloads}
+		s.links[l.ID] = rec
+	}
+	if rec.Downloads >= l.MaxDownloads {
+		return errors.New("分享链接的下载次数已用完")
+	}
+	rec.Downloads++
+	if err := s.save(); err != nil {
+		log.Printf("无法保存分享链接记录: %v", err)
+	}
+	return nil
+}
+
+// list 返回记录列表，owner为空时返回全部记录
+func (s *shareLinkStore) list(owner string) []shareLinkRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	now := time.Now()
+	var result []shareLinkRecord
+	for _, rec := range s.links {
+		if (owner == "" || rec.Owner == owner) && (rec.Expires.IsZero() || rec.Expires.After(now)) {
+			result = append(result, *rec)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+	return result
+}
+
+// setupShareLinks 加载分享链接记录
+func setupShareLinks() {
+	store, err := openShareLinkStore(dataPath("share-links.json"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	shareLinks = store
+}
+
+// shareLinkMiddleware 验证带签名的分享链接，验证通过的请求以链接创建者的身份访问该资源
+func shareLinkMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l, sig, err := parseShareLink(r)
+		if l == nil && err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "分享链接仅支持下载", http.StatusMethodNotAllowed)
+			return
+		}
+		key, err := loadShareKey(false)
+		if err != nil || !hmac.Equal([]byte(sig), []byte(l.sign(key))) {
+			http.Error(w, "分享链接无效", http.StatusForbidden)
+			return
+		}
+		if time.Now().After(l.Expires) {
+			http.Error(w, "分享链接已过期", http.StatusGone)
+			return
+		}
+		if l.IP != "" && l.IP != requestClientIP(r) {
+			http.Error(w, "分享链接不允许从当前地址访问", http.StatusForbidden)
+			return
+		}
+		if shareLinks != nil {
+			if err := shareLinks.use(l, r.Method == http.MethodGet); err != nil {
+				http.Error(w, err.Error(), http.StatusGone)
+				return
+			}
+		}
+
+		setRequestIdentity(r, &identity{Name: l.Owner, Method: "share"})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shareLinkRequest 创建分享链接API的请求参数
+type shareLinkRequest struct {
+	Path         string `json:"path"`
+	ExpiresIn    string `json:"expires_in"` // 有效期，如 "24h"，也可以是秒数
+	IP           string `json:"ip"`         // 绑定的IP地址，"client" 表示当前请求的地址
+	MaxDownloads int    `json:"max_downloads"`
+}
+
+// parseShareTTL 解析有效期，支持Go时长格式和秒数
+func parseShareTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return shareLinkDefaultTTL, nil
+	}
+	if secs, err := strconv.Atoi(s); err == nil {
+		s = strconv.Itoa(secs) + "s"
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("无效的有效期: %s", s)
+	}
+	return d, nil
+}
+
+// requestBaseURL 返回客户端访问本服务器使用的地址
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// writeJSON 以JSON格式写出响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// shareLinksAPIHandler 处理 /api/share-links：
+// GET 列出当前用户的链接（管理员列出全部），POST 创建链接，DELETE ?id= 撤销链接
+func shareLinksAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id := authenticatedUser(r)
+	if id == nil {
+		requestAuthentication(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		owner := id.Name
+		if isAdmin(id) {
+			owner = ""
+		}
+		links := shareLinks.list(owner)
+		if links == nil {
+			links = []shareLinkRecord{}
+		}
+		writeJSON(w, http.StatusOK, links)
+
+	case http.MethodPost:
+		var req shareLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(req.Path, "/") {
+			http.Error(w, "path 必须是以 / 开头的URL路径", http.StatusBadRequest)
+			return
+		}
+		ttl, err := parseShareTTL(req.ExpiresIn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.MaxDownloads < 0 {
+			http.Error(w, "max_downloads 不能为负数", http.StatusBadRequest)
+			return
+		}
+		target := path.Clean(req.Path)
+		if !aclAllowed(r, target, aclRead) {
+			aclDenied(w, r, target)
+			return
+		}
+		if req.IP == "client" {
+			req.IP = requestClientIP(r)
+		}
+
+		key, err := loadShareKey(true)
+		if err != nil {
+			log.Printf("无法加载分享链接密钥: %v", err)
+			http.Error(w, "无法创建分享链接", http.StatusInternalServerError)
+			return
+		}
+		l := &shareLink{Path: target, ID: newShareLinkID(), Owner: id.Name, IP: req.IP,
+			Expires: time.Now().Add(ttl).Truncate(time.Second), MaxDownloads: req.MaxDownloads}
+		rec := &shareLinkRecord{ID: l.ID, Path: l.Path, Owner: l.Owner, IP: l.IP,
+			Created: time.Now(), Expires: l.Expires, MaxDownloads: l.MaxDownloads}
+		if err := shareLinks.add(rec); err != nil {
+			log.Printf("无法保存分享链接记录: %v", err)
+			http.Error(w, "无法创建分享链接", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"id":            l.ID,
+			"url":           l.url(requestBaseURL(r), key),
+			"path":          l.Path,
+			"expires":       l.Expires,
+			"ip":            l.IP,
+			"max_downloads": l.MaxDownloads,
+		})
+
+	case http.MethodDelete:
+		linkID := r.URL.Query().Get("id")
+		if linkID == "" {
+			http.Error(w, "缺少链接ID", http.StatusBadRequest)
+			return
+		}
+		rec, ok := shareLinks.get(linkID)
+		if !isAdmin(id) && (!ok || rec.Owner != id.Name) {
+			http.NotFound(w, r)
+			return
+		}
+		if err := shareLinks.revoke(linkID); err != nil {
+			log.Printf("无法保存分享链接记录: %v", err)
+			http.Error(w, "无法撤销分享链接", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// shareLinkStatus 返回状态API中的分享链接信息
+func shareLinkStatus() map[string]interface{} {
+	active, revoked := 0, 0
+	if shareLinks != nil {
+		for _, rec := range shareLinks.list("") {
+			if rec.Revoked {
+				revoked++
+			} else {
+				active++
+			}
+		}
+	}
+	return map[string]interface{}{
+		"active":  active,
+		"revoked": revoked,
+	}
+}
+
+// runShareCommand 实现 "sweb share" 子命令：创建、列出和撤销分享链接
+func runShareCommand(args []string) {
+	action := "create"
+	if len(args) > 0 && (args[0] == "list" || args[0] == "revoke") {
+		action, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", ".sweb", "程序状态数据目录")
+	baseURL := fs.String("base-url", "http://localhost:8080", "服务器访问地址")
+	expires := fs.String("expires", shareLinkDefaultTTL.String(), "有效期，如 30m、24h 或秒数")
+	ip := fs.String("ip", "", "只允许从指定IP地址访问")
+	max := fs.Int("max", 0, "最大下载次数 (0表示不限制)")
+	user := fs.String("user", "", "以该用户的权限访问资源 (启用用户主目录或访问控制列表时需要)")
+	fs.Usage = func() {
+		fmt.Println("用法:")
+		fmt.Println("  sweb share [-data-dir 目录] [-base-url URL] [-expires 时长] [-ip 地址] [-max 次数] [-user 用户] <路径>")
+		fmt.Println("  sweb share list [-data-dir 目录]")
+		fmt.Println("  sweb share revoke [-data-dir 目录] <链接ID>")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	store, err := openShareLinkStore(dataPath("share-links.json"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch action {
+	case "list":
+		for _, rec := range store.list("") {
+			state := "有效"
+			if rec.Revoked {
+				state = "已撤销"
+			}
+			limit := "不限"
+			if rec.MaxDownloads > 0 {
+				limit = strconv.Itoa(rec.MaxDownloads)
+			}
+			fmt.Printf("%s  %-6s %s  过期: %s  下载: %d/%s  创建者: %s\n", rec.ID, state, rec.Path,
+				rec.Expires.Format("2006-01-02 15:04"), rec.Downloads, limit, rec.Owner)
+		}
+
+	case "revoke":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		if err := store.revoke(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "无法撤销分享链接: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("已撤销分享链接 %s\n", fs.Arg(0))
+
+	default:
+		if fs.NArg() != 1 || !strings.HasPrefix(fs.Arg(0), "/") {
+			fs.Usage()
+			os.Exit(2)
+		}
+		ttl, err := parseShareTTL(*expires)
+		if err == nil && *max < 0 {
+			err = errors.New("最大下载次数不能为负数")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		key, err := loadShareKey(true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "无法加载分享链接密钥: %v\n", err)
+			os.Exit(1)
+		}
+		l := &shareLink{Path: path.Clean(fs.Arg(0)), ID: newShareLinkID(), Owner: *user, IP: *ip,
+			Expires: time.Now().Add(ttl).Truncate(time.Second), MaxDownloads: *max}
+		rec := &shareLinkRecord{ID: l.ID, Path: l.Path, Owner: l.Owner, IP: l.IP,
+			Created: time.Now(), Expires: l.Expires, MaxDownloads: l.MaxDownloads}
+		if err := store.add(rec); err != nil {
+			fmt.Fprintf(os.Stderr, "无法保存分享链接记录: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(l.url(*baseURL, key))
+		fmt.Printf("链接ID: %s  过期时间: %s\n", l.ID, l.Expires.Format("2006-01-02 15:04:05"))
+	}
+}
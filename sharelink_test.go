@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 服务器保存下载计数时不能覆盖其他进程（sweb share revoke）在两次检查之间写入的撤销
+func TestShareLinkStoreKeepsRevocation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "share-links.json")
+	server, err := openShareLinkStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &shareLink{Path: "/a.txt", ID: "link1", Expires: time.Now().Add(time.Hour), MaxDownloads: 5}
+	other := &shareLink{Path: "/b.txt", ID: "link2", Expires: time.Now().Add(time.Hour), MaxDownloads: 5}
+	if err := server.use(l, true); err != nil {
+		t.Fatal(err)
+	}
+
+	cli, err := openShareLinkStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.revoke("link1"); err != nil {
+		t.Fatal(err)
+	}
+	// 确保修改时间不同，并让服务器仍处于检查间隔内
+	future := time.Now().Add(time.Second)
+	os.Chtimes(file, future, future)
+	server.lastCheck = time.Now()
+
+	if err := server.use(other, true); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := openShareLinkStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, ok := reloaded.get("link1"); !ok || !rec.Revoked {
+		t.Errorf("撤销记录被覆盖: %+v", rec)
+	}
+	if rec, ok := reloaded.get("link2"); !ok || rec.Downloads != 1 {
+		t.Errorf("link2 下载计数 = %+v, 期望 1", rec)
+	}
+	if err := server.use(l, true); err == nil {
+		t.Error("已撤销的链接不应再被下载")
+	}
+}
+
+func TestShareLinkMiddleware(t *testing.T) {
+	shareKey = []byte("0123456789abcdef0123456789abcdef")
+	store, err := openShareLinkStore(filepath.Join(t.TempDir(), "share-links.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shareLinks = store
+	defer func() { shareKey, shareLinks = nil, nil }()
+
+	var served *identity
+	handler := shareLinkMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = requestIdentity(r)
+	}))
+	request := func(method, target string) (int, *identity) {
+		served = nil
+		r := httptest.NewRequest(method, target, nil)
+		r, _ = withRequestInfo(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code, served
+	}
+
+	hour := time.Now().Add(time.Hour)
+	valid := (&shareLink{Path: "/webdav/a.txt", ID: "l1", Owner: "alice", Expires: hour}).url("", shareKey)
+	otherKey := (&shareLink{Path: "/webdav/a.txt", ID: "l1", Owner: "alice", Expires: hour}).url("", []byte("fedcba9876543210fedcba9876543210"))
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{"有效的链接", http.MethodGet, valid, http.StatusOK},
+		{"HEAD请求", http.MethodHead, valid, http.StatusOK},
+		{"不支持写入", http.MethodPut, valid, http.StatusMethodNotAllowed},
+		{"修改路径", http.MethodGet, strings.Replace(valid, "/a.txt", "/b.txt", 1), http.StatusForbidden},
+		{"等价路径", http.MethodGet, strings.Replace(valid, "/webdav/a.txt", "/webdav/./a.txt", 1), http.StatusOK},
+		{"修改所有者", http.MethodGet, strings.Replace(valid, "u=alice", "u=bob", 1), http.StatusForbidden},
+		{"去掉所有者", http.MethodGet, strings.Replace(valid, "&u=alice", "", 1), http.StatusForbidden},
+		{"添加下载次数限制", http.MethodGet, valid + "&max=100", http.StatusForbidden},
+		{"其他密钥签名", http.MethodGet, otherKey, http.StatusForbidden},
+		{"无效的过期时间", http.MethodGet, "/webdav/a.txt?sid=l1&exp=abc&sig=x", http.StatusBadRequest},
+		{"无效的下载次数", http.MethodGet, "/webdav/a.txt?sid=l1&exp=1&max=-1&sig=x", http.StatusBadRequest},
+		{"已过期",
+			http.MethodGet, (&shareLink{Path: "/webdav/a.txt", ID: "l2", Expires: time.Now().Add(-time.Second)}).url("", shareKey), http.StatusGone},
+		{"绑定的IP一致",
+			http.MethodGet, (&shareLink{Path: "/webdav/a.txt", ID: "l3", IP: "192.0.2.1", Expires: hour}).url("", shareKey), http.StatusOK},
+		{"绑定的IP不一致",
+			http.MethodGet, (&shareLink{Path: "/webdav/a.txt", ID: "l4", IP: "192.0.2.2", Expires: hour}).url("", shareKey), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if code, _ := request(tt.method, tt.target); code != tt.want {
+			t.Errorf("%s: 状态码 = %d, 期望 %d", tt.name, code, tt.want)
+		}
+	}
+
+	if code, id := request(http.MethodGet, valid); code != http.StatusOK || id == nil || id.Name != "alice" || id.Method != "share" {
+		t.Errorf("分享链接请求的身份 = %+v", id)
+	}
+	if code, id := request(http.MethodGet, "/webdav/a.txt"); code != http.StatusOK || id != nil {
+		t.Errorf("不带签名参数的请求应直接放行, 状态码 %d, 身份 %+v", code, id)
+	}
+
+	limited := (&shareLink{Path: "/webdav/a.txt", ID: "l5", Expires: hour, MaxDownloads: 2}).url("", shareKey)
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusGone} {
+		if i == 1 {
+			// HEAD请求不计入下载次数
+			if code, _ := request(http.MethodHead, limited); code != http.StatusOK {
+				t.Errorf("HEAD请求状态码 = %d", code)
+			}
+		}
+		if code, _ := request(http.MethodGet, limited); code != want {
+			t.Errorf("第%d次下载状态码 = %d, 期望 %d", i+1, code, want)
+		}
+	}
+
+	if err := store.revoke("l1"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := request(http.MethodGet, valid); code != http.StatusGone {
+		t.Errorf("撤销后状态码 = %d, 期望 %d", code, http.StatusGone)
+	}
+}
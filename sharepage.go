@@ -0,0 +1,671 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// shareRecord 受密码保护的分享页面
+type shareRecord struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`  // 被分享的文件或目录的URL路径
+	Owner        string    `json:"owner"` // 创建者，访问时以其身份进行授权检查
+	PasswordHash string    `json:"password_hash,omitempty"`
+	AllowUpload  bool      `json:"allow_upload,omitempty"` // 允许访问者向目录上传文件
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires,omitempty"` // 零值表示永不过期
+	Downloads    int       `json:"downloads"`
+	Uploads      int       `json:"uploads"`
+	LastAccess   time.Time `json:"last_access,omitempty"`
+}
+
+// expired 判断分享是否已过期
+func (rec *shareRecord) expired() bool {
+	return !rec.Expires.IsZero() && time.Now().After(rec.Expires)
+}
+
+// view 返回API中展示的分享信息，不包含密码哈希
+func (rec *shareRecord) view(baseURL string) map[string]interface{} {
+	v := map[string]interface{}{
+		"id":           rec.ID,
+		"url":          baseURL + "/share/" + rec.ID + "/",
+		"path":         rec.Path,
+		"owner":        rec.Owner,
+		"protected":    rec.PasswordHash != "",
+		"allow_upload": rec.AllowUpload,
+		"created":      rec.Created,
+		"downloads":    rec.Downloads,
+		"uploads":      rec.Uploads,
+		"expired":      rec.expired(),
+	}
+	if !rec.Expires.IsZero() {
+		v["expires"] = rec.Expires
+	}
+	if !rec.LastAccess.IsZero() {
+		v["last_access"] = rec.LastAccess
+	}
+	return v
+}
+
+// shareStore 持久化的分享页面记录，保存在 <data-dir>/shares.json
+type shareStore struct {
+	path string
+
+	mu          sync.Mutex
+	shares      map[string]*shareRecord
+	accessSaved map[string]time.Time // 每个分享的访问统计最近一次写回磁盘的时间
+}
+
+// shareAccessSaveInterval 访问时间和下载次数写回记录文件的最短间隔
+const shareAccessSaveInterval = time.Minute
+
+// shares 服务器使用的分享页面记录
+var shares *shareStore
+
+func openShareStore(file string) (*shareStore, error) {
+	var list []*shareRecord
+	if err := readJSONFile(file, &list); err != nil {
+		return nil, fmt.Errorf("无法读取分享记录: %v", err)
+	}
+	s := &shareStore{path: file, shares: make(map[string]*shareRecord, len(list)), accessSaved: make(map[string]time.Time)}
+	for _, rec := range list {
+		s.shares[rec.ID] = rec
+	}
+	return s, nil
+}
+
+// save 写回记录文件，调用者需持有锁
+func (s *shareStore) save() error {
+	list := make([]*shareRecord, 0, len(s.shares))
+	for _, rec := range s.shares {
+		list = append(list, rec)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return writeJSONFile(s.path, list)
+}
+
+// get 返回分享记录的副本
+func (s *shareStore) get(id string) (shareRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.shares[id]; ok {
+		return *rec, true
+	}
+	return shareRecord{}, false
+}
+
+func (s *shareStore) add(rec *shareRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shares[rec.ID] = rec
+	return s.save()
+}
+
+func (s *shareStore) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.shares, id)
+	delete(s.accessSaved, id)
+	return s.save()
+}
+
+// update 修改分享记录并保存
+func (s *shareStore) update(id string, fn func(rec *shareRecord)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.shares[id]
+	if !ok {
+		return
+	}
+	fn(rec)
+	if err := s.save(); err != nil {
+		log.Printf("无法保存分享记录: %v", err)
+	}
+}
+
+// recordAccess 在内存中更新最近访问时间，download为true时同时增加下载次数；
+// 每个分享最多每分钟写回一次记录文件，其间的变化随下一次写回保存
+func (s *shareStore) recordAccess(id string, download bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.shares[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	rec.LastAccess = now
+	if download {
+		rec.Downloads++
+	}
+	if now.Sub(s.accessSaved[id]) < shareAccessSaveInterval {
+		return
+	}
+	s.accessSaved[id] = now
+	if err := s.save(); err != nil {
+		log.Printf("无法保存分享记录: %v", err)
+	}
+}
+
+// list 返回分享列表，owner为空时返回全部
+func (s *shareStore) list(owner string) []shareRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []shareRecord
+	for _, rec := range s.shares {
+		if owner == "" || rec.Owner == owner {
+			result = append(result, *rec)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+	return result
+}
+
+// setupShares 加载分享页面记录
+func setupShares() {
+	store, err := openShareStore(dataPath("shares.json"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	shares = store
+}
+
+// newShareID 生成随机的分享ID
+func newShareID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// shareTarget 将URL路径映射到提供该资源的文件系统及其中的路径
+func shareTarget(urlPath string) (webdav.FileSystem, string) {
+	if webdavFS != nil && pathWithin(urlPath, "/webdav") {
+		return webdavFS, "/" + strings.TrimPrefix(strings.TrimPrefix(urlPath, "/webdav"), "/")
+	}
+	return webdav.Dir("web"), urlPath
+}
+
+// shareWritable 判断分享的位置是否允许上传：写入WebDAV目录时按分享创建者的WebDAV访问策略判断
+func shareWritable(owner *identity, urlPath string) bool {
+	if pathWithin(urlPath, "/webdav") {
+		return webdavEnabled && davPolicy.mode(owner, urlPath) == davModeReadWrite
+	}
+	return uploadEnabled
+}
+
+// shareUploadIPAllowed 判断访问者的地址能否通过分享上传：写入WebDAV目录受 -ip-allow-webdav/-ip-deny-webdav 限制，
+// 写入静态文件目录受 -ip-allow-upload/-ip-deny-upload 限制
+func shareUploadIPAllowed(r *http.Request, urlPath string) bool {
+	mount := "upload"
+	if versionMount(urlPath) == "webdav" {
+		mount = "webdav"
+	}
+	return mountIPAllowed(mount, r)
+}
+
+// shareCookieName 返回记录分享已解锁的Cookie名称
+func shareCookieName(id string) string {
+	return "sweb_share_" + id
+}
+
+// shareUnlockToken 计算解锁Cookie的值，修改密码后原有Cookie自动失效
+func shareUnlockToken(rec *shareRecord) string {
+	key, err := loadShareKey(true)
+	if err != nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("share\n" + rec.ID + "\n" + rec.PasswordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// shareUnlocked 判断访问者是否已输入分享密码
+func shareUnlocked(r *http.Request, rec *shareRecord) bool {
+	if rec.PasswordHash == "" {
+		return true
+	}
+	c, err := r.Cookie(shareCookieName(rec.ID))
+	if err != nil {
+		return false
+	}
+	token := shareUnlockToken(rec)
+	return token != "" && hmac.Equal([]byte(c.Value), []byte(token))
+}
+
+// sharePageData 分享页面模板数据
+type sharePageData struct {
+	Title   string
+	Message string
+	Error   string
+	Share   *shareRecord
+	Base    string // 分享页面的根地址 /share/<id>/
+	Sub     string // 当前浏览的子路径
+	Locked  bool   // 需要输入密码
+	File    os.FileInfo
+	Listing bool
+	Entries []os.FileInfo
+	Upload  bool
+	CSRF    string
+}
+
+var sharePageTemplate = template.Must(template.New("share").Funcs(template.FuncMap{
+	"size": func(n int64) string {
+		const unit = 1024
+		if n < unit {
+			return fmt.Sprintf("%d B", n)
+		}
+		div, exp := int64(unit), 0
+		for m := n / unit; m >= unit; m /= unit {
+			div *= unit
+			exp++
+		}
+		return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+	},
+	"join": path.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Title}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 760px; margin: 40px auto; padding: 0 20px; color: #333; }
+        .box { background: #f8f9fa; border: 1px solid #dee2e6; border-radius: 8px; padding: 24px; }
+        .error { color: #dc3545; }
+        .message { color: #28a745; }
+        table { width: 100%; border-collapse: collapse; }
+        td { padding: 6px 4px; border-bottom: 1px solid #eee; }
+        td.size { text-align: right; color: #666; white-space: nowrap; }
+        .button { display: inline-block; background: #007bff; color: #fff; padding: 8px 18px; border-radius: 4px; text-decoration: none; border: none; cursor: pointer; }
+        input[type=password] { padding: 8px; width: 240px; }
+    </style>
+</head>
+<body>
+<div class="box">
+    <h2>{{.Title}}</h2>
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+    {{if .Locked}}
+    <form method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <p>此分享受密码保护，请输入访问密码：</p>
+        <input type="password" name="password" autofocus>
+        <button class="button" type="submit">访问</button>
+    </form>
+    {{end}}
+    {{with .File}}
+    <p>文件名: {{.Name}}</p>
+    <p>大小: {{size .Size}}</p>
+    <p>修改时间: {{.ModTime.Format "2006-01-02 15:04:05"}}</p>
+    <p><a class="button" href="?download=1">下载</a></p>
+    {{end}}
+    {{if .Listing}}
+    {{$base := .Base}}{{$sub := .Sub}}
+    {{if $sub}}<p><a href="{{join $base $sub ".."}}/">⬆️ 上级目录</a></p>{{end}}
+    <table>
+    {{range .Entries}}
+        {{if .IsDir}}
+        <tr><td>📁 <a href="{{join $base $sub .Name}}/">{{.Name}}/</a></td><td class="size">-</td></tr>
+        {{else}}
+        <tr><td>📄 <a href="{{join $base $sub .Name}}?download=1">{{.Name}}</a></td><td class="size">{{size .Size}}</td></tr>
+        {{end}}
+    {{end}}
+    </table>
+    {{if not .Entries}}<p>目录为空</p>{{end}}
+    {{end}}
+    {{if .Upload}}
+    <h3>上传文件</h3>
+    <form method="post" enctype="multipart/form-data">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <input type="file" name="file" multiple>
+        <button class="button" type="submit">上传</button>
+    </form>
+    {{end}}
+</div>
+</body>
+</html>
+`))
+
+// renderSharePage 输出分享页面
+func renderSharePage(w http.ResponseWriter, status int, data *sharePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := sharePageTemplate.Execute(w, data); err != nil {
+		log.Printf("无法渲染分享页面: %v", err)
+	}
+}
+
+// sharePageHandler 处理 /share/<ID>/<子路径>：密码验证、文件下载、目录浏览和上传
+func sharePageHandler(w http.ResponseWriter, r *http.Request) {
+	id, sub, hasSlash := strings.Cut(strings.TrimPrefix(r.URL.Path, "/share/"), "/")
+	rec, ok := shares.get(id)
+	if !ok {
+		renderSharePage(w, http.StatusNotFound, &sharePageData{Title: "分享不存在", Error: "分享不存在或已被删除。"})
+		return
+	}
+	if rec.expired() {
+		renderSharePage(w, http.StatusGone, &sharePageData{Title: "分享已过期", Error: "此分享已过期。"})
+		return
+	}
+	if !hasSlash {
+		http.Redirect(w, r, "/share/"+id+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	base := "/share/" + id + "/"
+	page := &sharePageData{Title: "文件分享", Share: &rec, Base: base, CSRF: csrfToken(w, r)}
+
+	// 密码验证
+	if !shareUnlocked(r, &rec) {
+		page.Locked = true
+		if r.Method != http.MethodPost {
+			renderSharePage(w, http.StatusUnauthorized, page)
+			return
+		}
+		if d, blocked := throttle.check("share:"+id, requestClientIP(r)); blocked {
+			page.Error = lockoutMessage(d)
+			renderSharePage(w, http.StatusTooManyRequests, page)
+			return
+		}
+		if !verifyPasswordHash(rec.PasswordHash, r.PostFormValue("password")) {
+			throttle.failure("share:"+id, requestClientIP(r), "分享页面密码")
+			page.Error = "密码错误"
+			renderSharePage(w, http.StatusUnauthorized, page)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareCookieName(id),
+			Value:    shareUnlockToken(&rec),
+			Path:     base,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	// 以创建者的身份访问资源，访问控制列表和用户主目录照常生效
+	setRequestIdentity(r, &identity{Name: rec.Owner, Method: "share"})
+	sub = strings.TrimPrefix(path.Clean("/"+sub), "/")
+	urlPath := path.Join(rec.Path, sub)
+	fs, name := shareTarget(urlPath)
+	ctx := r.Context()
+
+	shares.recordAccess(id, false)
+
+	if !aclAllowed(r, urlPath, aclRead) || dropboxHidden(urlPath) {
+		renderSharePage(w, http.StatusNotFound, &sharePageData{Title: "文件不存在", Error: "分享的文件不存在。"})
+		return
+	}
+	fi, err := fs.Stat(ctx, name)
+	if err != nil {
+		renderSharePage(w, http.StatusNotFound, &sharePageData{Title: "文件不存在", Error: "分享的文件不存在。"})
+		return
+	}
+	page.Sub = sub
+
+	switch {
+	case r.Method == http.MethodPost && fi.IsDir():
+		if !rec.AllowUpload || !shareWritable(requestIdentity(r), urlPath) || !aclAllowed(r, urlPath, aclWrite) {
+			http.Error(w, "此分享不允许上传", http.StatusForbidden)
+			return
+		}
+		if !shareUploadIPAllowed(r, urlPath) {
+			http.Error(w, "不允许从此地址上传", http.StatusForbidden)
+			return
+		}
+		saved, err := saveShareUploads(r, fs, name)
+		if len(saved) > 0 {
+			shares.update(id, func(rec *shareRecord) { rec.Uploads += len(saved) })
+			log.Printf("分享 %s 收到上传: %s，来自 %s", id, strings.Join(saved, ", "), requestClientIP(r))
+		}
+		if err != nil {
+			page.Error = err.Error()
+		} else {
+			page.Message = fmt.Sprintf("已上传 %d 个文件", len(saved))
+		}
+		page.Title = "上传文件"
+		page.Upload = true
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusBadRequest
+		}
+		renderSharePage(w, status, page)
+
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+
+	case fi.IsDir():
+		if sub != "" && !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+		if err != nil {
+			http.Error(w, "无法读取目录", http.StatusInternalServerError)
+			return
+		}
+		entries, _ := f.Readdir(-1)
+		f.Close()
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].IsDir() != entries[j].IsDir() {
+				return entries[i].IsDir()
+			}
+			return entries[i].Name() < entries[j].Name()
+		})
+		for _, fi := range entries {
+			if aclAllowed(r, path.Join(urlPath, fi.Name()), aclRead) {
+				page.Entries = append(page.Entries, fi)
+			}
+		}
+		page.Title = "文件分享: " + path.Base("/"+path.Join(path.Base(rec.Path), sub))
+		page.Listing = true
+		page.Upload = rec.AllowUpload && shareWritable(requestIdentity(r), urlPath) && shareUploadIPAllowed(r, urlPath)
+		renderSharePage(w, http.StatusOK, page)
+
+	case r.URL.Query().Get("download") == "":
+		page.Title = "文件分享: " + fi.Name()
+		page.File = fi
+		renderSharePage(w, http.StatusOK, page)
+
+	default:
+		f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+		if err != nil {
+			http.Error(w, "无法读取文件", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		if r.Method == http.MethodGet {
+			shares.recordAccess(id, true)
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", pathEscape(fi.Name())))
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	}
+}
+
+// pathEscape 按RFC 5987对文件名进行百分号编码
+func pathEscape(name string) string {
+	var b strings.Builder
+	for _, c := range []byte(name) {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// saveShareUploads 将表单中的文件保存到分享目录，同名文件自动重命名
+func saveShareUploads(r *http.Request, fs webdav.FileSystem, dir string) ([]string, error) {
+	if err := r.ParseMultipartForm(uploadMemoryLimit); err != nil {
+		return nil, fmt.Errorf("无法读取上传内容: %v", err)
+	}
+	var saved []string
+	for _, header := range r.MultipartForm.File["file"] {
+		name := filepath.Base(strings.ReplaceAll(header.Filename, "\\", "/"))
+		if !validPathSegment(name) {
+			return saved, fmt.Errorf("无效的文件名: %s", header.Filename)
+		}
+		src, err := header.Open()
+		if err != nil {
+			return saved, fmt.Errorf("无法读取上传内容: %v", err)
+		}
+		name = uniqueFileName(r, fs, dir, name)
+		dst, err := fs.OpenFile(r.Context(), path.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+		if err != nil {
+			src.Close()
+			return saved, fmt.Errorf("无法保存文件 %s", name)
+		}
+		_, err = io.Copy(dst, src)
+		dst.Close()
+		src.Close()
+		if err != nil {
+			fs.RemoveAll(r.Context(), path.Join(dir, name))
+			return saved, fmt.Errorf("无法保存文件 %s", name)
+		}
+		saved = append(saved, name)
+	}
+	return saved, nil
+}
+
+// uniqueFileName 在目录中已存在同名文件时返回 "名称 (n).扩展名" 形式的新名称
+func uniqueFileName(r *http.Request, fs webdav.FileSystem, dir, name string) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 1; ; n++ {
+		if _, err := fs.Stat(r.Context(), path.Join(dir, candidate)); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+	}
+}
+
+// shareRequest 创建分享页面API的请求参数
+type shareRequest struct {
+	Path        string `json:"path"`
+	Password    string `json:"password"`
+	ExpiresIn   string `json:"expires_in"` // 有效期，如 "72h"，为空表示永不过期
+	AllowUpload bool   `json:"allow_upload"`
+}
+
+// sharesAPIHandler 处理 /api/shares：
+// GET 列出当前用户的分享（管理员列出全部），POST 创建分享，DELETE ?id= 删除分享
+func sharesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id := authenticatedUser(r)
+	if id == nil {
+		requestAuthentication(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		owner := id.Name
+		if isAdmin(id) {
+			owner = ""
+		}
+		result := []map[string]interface{}{}
+		for _, rec := range shares.list(owner) {
+			result = append(result, rec.view(requestBaseURL(r)))
+		}
+		writeJSON(w, http.StatusOK, result)
+
+	case http.MethodPost:
+		var req shareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(req.Path, "/") {
+			http.Error(w, "path 必须是以 / 开头的URL路径", http.StatusBadRequest)
+			return
+		}
+		target := path.Clean(req.Path)
+		if dropboxHidden(target) {
+			http.Error(w, "不能分享投递箱目录", http.StatusForbidden)
+			return
+		}
+		if !aclAllowed(r, target, aclRead) {
+			aclDenied(w, r, target)
+			return
+		}
+		fs, name := shareTarget(target)
+		fi, err := fs.Stat(r.Context(), name)
+		if err != nil {
+			http.Error(w, "分享的文件不存在", http.StatusNotFound)
+			return
+		}
+		if req.AllowUpload {
+			if !fi.IsDir() || !shareWritable(id, target) {
+				http.Error(w, "只有可写的目录才能允许上传", http.StatusBadRequest)
+				return
+			}
+			if !aclAllowed(r, target, aclWrite) {
+				aclDenied(w, r, target)
+				return
+			}
+		}
+
+		rec := &shareRecord{ID: newShareID(), Path: target, Owner: id.Name, AllowUpload: req.AllowUpload, Created: time.Now()}
+		if req.ExpiresIn != "" {
+			ttl, err := parseShareTTL(req.ExpiresIn)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rec.Expires = time.Now().Add(ttl).Truncate(time.Second)
+		}
+		if req.Password != "" {
+			if rec.PasswordHash, err = hashPassword("bcrypt", req.Password); err != nil {
+				http.Error(w, "无法创建分享", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := shares.add(rec); err != nil {
+			log.Printf("无法保存分享记录: %v", err)
+			http.Error(w, "无法创建分享", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, rec.view(requestBaseURL(r)))
+
+	case http.MethodDelete:
+		shareID := r.URL.Query().Get("id")
+		rec, ok := shares.get(shareID)
+		if !ok || (!isAdmin(id) && rec.Owner != id.Name) {
+			http.NotFound(w, r)
+			return
+		}
+		if err := shares.remove(shareID); err != nil {
+			log.Printf("无法保存分享记录: %v", err)
+			http.Error(w, "无法删除分享", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
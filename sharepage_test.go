@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestShareWritable(t *testing.T) {
+	oldPolicy, oldWebDAV, oldUpload := davPolicy, webdavEnabled, uploadEnabled
+	defer func() { davPolicy, webdavEnabled, uploadEnabled = oldPolicy, oldWebDAV, oldUpload }()
+	webdavEnabled, uploadEnabled = true, false
+
+	alice := &identity{Name: "alice", Method: "basic"}
+	bob := &identity{Name: "bob", Method: "basic", Groups: []string{"staff"}}
+	tests := []struct {
+		name     string
+		spec     string
+		readonly bool
+		owner    *identity
+		path     string
+		want     bool
+	}{
+		{"默认读写", "", false, alice, "/webdav/in", true},
+		{"全局只读", "", true, alice, "/webdav/in", false},
+		{"全局只读时用户可写", "alice=rw", true, alice, "/webdav/in", true},
+		{"用户只读", "bob=ro", false, bob, "/webdav/in", false},
+		{"组只读", "@staff=ro", false, bob, "/webdav/in", false},
+		{"组可写", "@staff=rw", true, bob, "/webdav/in", true},
+		{"路径只读", "/webdav/archive=ro", false, alice, "/webdav/archive/2024", false},
+		{"用户可写但路径只读", "alice=rw,/webdav/archive=ro-strict", true, alice, "/webdav/archive", false},
+		{"静态目录未启用上传", "", false, alice, "/files", false},
+	}
+	for _, tt := range tests {
+		mode := davModeReadWrite
+		if tt.readonly {
+			mode = davModeReadOnly
+		}
+		p, err := parseWebDAVPolicy(tt.spec, mode)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		davPolicy = p
+		if got := shareWritable(tt.owner, tt.path); got != tt.want {
+			t.Errorf("%s: shareWritable = %v, 期望 %v", tt.name, got, tt.want)
+		}
+	}
+}
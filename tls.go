@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPS相关配置
+var (
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsSelfSigned   bool
+	tlsRedirectPort int
+	dataDir         string
+)
+
+// tlsEnabled 判断是否以HTTPS方式提供服务
+func tlsEnabled() bool {
+	return acmeEnabled() || tlsSelfSigned || (tlsCertFile != "" && tlsKeyFile != "")
+}
+
+// tlsMode 返回当前HTTPS模式的描述，供状态API使用
+func tlsMode() string {
+	switch {
+	case acmeEnabled():
+		return "acme"
+	case tlsSelfSigned:
+		return "self-signed"
+	case tlsCertFile != "" && tlsKeyFile != "":
+		return "certificate"
+	}
+	return "disabled"
+}
+
+// setupTLS 根据命令行参数构造TLS配置
+func setupTLS() (*tls.Config, error) {
+	cfg, err := serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if mtlsEnabled() {
+		if err := applyClientAuth(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// serverTLSConfig 构造提供服务器证书的TLS配置
+func serverTLSConfig() (*tls.Config, error) {
+	if acmeEnabled() {
+		if tlsSelfSigned || tlsCertFile != "" || tlsKeyFile != "" {
+			return nil, errors.New("-acme-domains 不能与 -tls-cert/-tls-key/-tls-self-signed 同时使用")
+		}
+		return setupACME()
+	}
+	if tlsSelfSigned {
+		certFile, keyFile, err := ensureSelfSignedCert(filepath.Join(dataDir, "tls"))
+		if err != nil {
+			return nil, err
+		}
+		tlsCertFile, tlsKeyFile = certFile, keyFile
+	}
+	if tlsCertFile == "" || tlsKeyFile == "" {
+		return nil, errors.New("必须同时指定 -tls-cert 和 -tls-key")
+	}
+
+	reloader, err := newCertReloader(tlsCertFile, tlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// certReloader 在证书文件变更时自动重新加载证书
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// certCheckInterval 两次检查证书文件是否变更的最小间隔
+const certCheckInterval = 10 * time.Second
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime 返回证书和私钥文件中较新的修改时间
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("无法加载证书: %v", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate 实现tls.Config.GetCertificate，按需检查并重新加载证书
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastCheck) >= certCheckInterval {
+		r.lastCheck = now
+		if modTime, err := r.latestModTime(); err == nil && !modTime.Equal(r.modTime) {
+			if err := r.reload(); err != nil {
+				// 证书可能正在被替换，继续使用旧证书
+				log.Printf("重新加载证书失败，继续使用旧证书: %v", err)
+			} else {
+				log.Printf("证书已重新加载: %s", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// localHostnames 返回本机的主机名和局域网IP地址
+func localHostnames() ([]string, []net.IP) {
+	names := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		names = append(names, hostname)
+	}
+
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return names, ips
+}
+
+// ensureSelfSignedCert 确保缓存目录中存在覆盖本机所有主机名和IP的自签名证书
+func ensureSelfSignedCert(dir string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, "selfsigned.crt")
+	keyFile = filepath.Join(dir, "selfsigned.key")
+	names, ips := localHostnames()
+
+	if cachedCertUsable(certFile, keyFile, names, ips) {
+		return certFile, keyFile, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", fmt.Errorf("无法创建证书缓存目录: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"sweb self-signed"}, CommonName: names[len(names)-1]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              names,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	fmt.Printf("已生成自签名证书: %s\n", certFile)
+	return certFile, keyFile, nil
+}
+
+// cachedCertUsable 检查缓存的自签名证书是否仍然有效且覆盖所有主机名和IP
+func cachedCertUsable(certFile, keyFile string, names []string, ips []net.IP) bool {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	// 距离过期不足30天时重新生成
+	if time.Now().Add(30 * 24 * time.Hour).After(cert.NotAfter) {
+		return false
+	}
+	for _, name := range names {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if cert.VerifyHostname(ip.String()) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// startRedirectServer 启动将HTTP请求重定向到HTTPS的监听器
+func startRedirectServer(redirectPort, httpsPort int) {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+	if acmeManager != nil {
+		// 同一监听器负责应答ACME HTTP-01验证请求
+		handler = acmeManager.HTTPHandler(handler)
+	}
+
+	fmt.Printf("✅ HTTP重定向已启用: http://localhost:%d → https\n", redirectPort)
+	go func() {
+		ln, err := listen(fmt.Sprintf(":%d", redirectPort))
+		if err == nil {
+			err = http.Serve(ln, handler)
+		}
+		log.Printf("HTTP重定向服务已停止: %v", err)
+	}()
+}
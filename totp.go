@@ -0,0 +1,752 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// totpIssuer 在身份验证器应用中显示的服务名称
+var totpIssuer string
+
+// TOTP参数（RFC 6238默认值，兼容常见的身份验证器应用）
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // 允许前后各一个时间步长的时钟偏差
+)
+
+// recoveryCodeCount 每次生成的恢复码数量
+const recoveryCodeCount = 10
+
+// mfaLoginTTL 输入密码后完成两步验证的时限
+const mfaLoginTTL = 5 * time.Minute
+
+// mfaMaxAttempts 一次登录中允许输错验证码的次数
+const mfaMaxAttempts = 5
+
+// base32NoPad 身份验证器应用使用的无填充Base32编码
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// appPassword 应用专用密码，供不支持两步验证的WebDAV客户端使用
+type appPassword struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Hash     string    `json:"hash"` // 密码的SHA-256
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+}
+
+// twoFactorRecord 单个用户的两步验证设置
+type twoFactorRecord struct {
+	Enabled       bool           `json:"enabled"`
+	Secret        string         `json:"secret,omitempty"`         // 已启用的TOTP密钥（Base32）
+	PendingSecret string         `json:"pending_secret,omitempty"` // 尚未确认的新密钥
+	RecoveryCodes []string       `json:"recovery_codes,omitempty"` // 未使用的恢复码的SHA-256
+	LastCounter   int64          `json:"last_counter,omitempty"`   // 最近一次使用的时间步长，防止验证码重放
+	AppPasswords  []*appPassword `json:"app_passwords,omitempty"`
+}
+
+// totpCode 按RFC 4226计算指定时间步长的验证码
+func totpCode(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// newTOTPSecret 生成160位的TOTP密钥
+func newTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base32NoPad.EncodeToString(b)
+}
+
+// totpURI 返回身份验证器应用使用的 otpauth:// 配置地址，可直接生成二维码
+func totpURI(user, secret string) string {
+	label := url.PathEscape(totpIssuer) + ":" + url.PathEscape(user)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// matchTOTP 在允许的时钟偏差内校验验证码，返回匹配的时间步长
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := base32NoPad.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	counter := now.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, counter+i)), []byte(code)) == 1 {
+			return counter + i, true
+		}
+	}
+	return 0, false
+}
+
+// randomLetters 生成由易于输入的小写字母和数字组成的随机串，每group个字符用连字符分隔
+func randomLetters(n, group int) string {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	var sb strings.Builder
+	for i, c := range b {
+		if i > 0 && i%group == 0 {
+			sb.WriteByte('-')
+		}
+		// 字母表长度为31，取模带来的偏差可以忽略
+		sb.WriteByte(alphabet[int(c)%len(alphabet)])
+	}
+	return sb.String()
+}
+
+// normalizeSecretInput 去掉用户输入中的空格和连字符并转为小写
+func normalizeSecretInput(s string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(s)))
+}
+
+// twoFactorStore 持久化的两步验证设置，保存在 <data-dir>/2fa.json；
+// 文件被其他进程（如 sweb 2fa disable）修改后自动重新加载
+type twoFactorStore struct {
+	path string
+
+	mu        sync.Mutex
+	users     map[string]*twoFactorRecord
+	modTime   time.Time
+	lastCheck time.Time
+	lastSave  time.Time
+}
+
+// twoFactor 服务器使用的两步验证设置，未启用Basic认证时为nil
+var twoFactor *twoFactorStore
+
+func openTwoFactorStore(file string) (*twoFactorStore, error) {
+	s := &twoFactorStore{path: file}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *twoFactorStore) reload() error {
+	users := make(map[string]*twoFactorRecord)
+	if err := readJSONFile(s.path, &users); err != nil {
+		return fmt.Errorf("无法读取两步验证设置: %v", err)
+	}
+	s.users = users
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// refresh 在文件变更后重新加载设置，调用者需持有锁
+func (s *twoFactorStore) refresh() {
+	now := time.Now()
+	if now.Sub(s.lastCheck) < htpasswdCheckInterval {
+		return
+	}
+	s.lastCheck = now
+	if fi, err := os.Stat(s.path); err == nil && !fi.ModTime().Equal(s.modTime) {
+		if err := s.reload(); err != nil {
+			log.Printf("重新加载两步验证设置失败，继续使用旧数据: %v", err)
+		}
+	}
+}
+
+// save 写回设置文件，调用者需持有锁
+func (s *twoFactorStore) save() error {
+	for user, rec := range s.users {
+		if !rec.Enabled && rec.PendingSecret == "" && len(rec.AppPasswords) == 0 {
+			delete(s.users, user)
+		}
+	}
+	if err := writeJSONFile(s.path, s.users); err != nil {
+		return err
+	}
+	s.lastSave = time.Now()
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// update 在锁内修改用户的设置并保存，fn返回错误时不保存
+func (s *twoFactorStore) update(user string, fn func(rec *twoFactorRecord) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = time.Time{}
+	s.refresh()
+	rec, ok := s.users[user]
+	if !ok {
+		rec = &twoFactorRecord{}
+		s.users[user] = rec
+	}
+	if err := fn(rec); err != nil {
+		if !ok {
+			delete(s.users, user)
+		}
+		return err
+	}
+	return s.save()
+}
+
+// get 返回用户设置的副本
+func (s *twoFactorStore) get(user string) twoFactorRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	if rec, ok := s.users[user]; ok {
+		return *rec
+	}
+	return twoFactorRecord{}
+}
+
+// enabled 判断用户是否启用了两步验证
+func (s *twoFactorStore) enabled(user string) bool {
+	if s == nil {
+		return false
+	}
+	return s.get(user).Enabled
+}
+
+// userNames 返回有两步验证设置的用户名列表
+func (s *twoFactorStore) userNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	names := make([]string, 0, len(s.users))
+	for name := range s.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// verifyCode 校验TOTP验证码或恢复码；恢复码使用后立即作废
+func (s *twoFactorStore) verifyCode(user, code string) bool {
+	code = normalizeSecretInput(code)
+	ok := false
+	err := s.update(user, func(rec *twoFactorRecord) error {
+		if !rec.Enabled {
+			return errors.New("未启用两步验证")
+		}
+		if counter, match := matchTOTP(rec.Secret, code, time.Now()); match {
+			if counter <= rec.LastCounter {
+				return errors.New("验证码已使用")
+			}
+			rec.LastCounter = counter
+			ok = true
+			return nil
+		}
+		hash := hashTokenSecret(code)
+		for i, h := range rec.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+				rec.RecoveryCodes = append(rec.RecoveryCodes[:i], rec.RecoveryCodes[i+1:]...)
+				log.Printf("用户 %s 使用了恢复码，剩余 %d 个", user, len(rec.RecoveryCodes))
+				ok = true
+				return nil
+			}
+		}
+		return errors.New("验证码错误")
+	})
+	if err != nil && ok {
+		log.Printf("无法保存两步验证设置: %v", err)
+	}
+	return ok
+}
+
+// verifyAppPassword 校验应用专用密码并记录使用时间
+func (s *twoFactorStore) verifyAppPassword(user, password string) bool {
+	if s == nil {
+		return false
+	}
+	hash := hashTokenSecret(normalizeSecretInput(password))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	rec, ok := s.users[user]
+	if !ok {
+		return false
+	}
+	for _, p := range rec.AppPasswords {
+		if subtle.ConstantTimeCompare([]byte(p.Hash), []byte(hash)) == 1 {
+			now := time.Now()
+			p.LastUsed = now
+			if now.Sub(s.lastSave) >= apiTokenTouchInterval {
+				if err := s.save(); err != nil {
+					log.Printf("无法保存两步验证设置: %v", err)
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// newRecoveryCodes 生成一组恢复码，返回明文和哈希
+func newRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := randomLetters(10, 5)
+		codes = append(codes, code)
+		hashes = append(hashes, hashTokenSecret(normalizeSecretInput(code)))
+	}
+	return codes, hashes
+}
+
+// newAppPassword 生成应用专用密码，返回记录和明文密码
+func newAppPassword(name string) (*appPassword, string) {
+	password := randomLetters(16, 4)
+	return &appPassword{ID: newShareLinkID()[:8], Name: name, Hash: hashTokenSecret(normalizeSecretInput(password)),
+		Created: time.Now()}, password
+}
+
+// setupTwoFactor 加载两步验证设置；两步验证只适用于htpasswd中的用户
+func setupTwoFactor() {
+	if !basicAuthEnabled() {
+		return
+	}
+	store, err := openTwoFactorStore(dataPath("2fa.json"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	twoFactor = store
+	enabled := 0
+	for _, name := range store.userNames() {
+		if store.enabled(name) {
+			enabled++
+		}
+	}
+	fmt.Printf("✅ 两步验证已启用 - %d 个用户已绑定身份验证器\n", enabled)
+}
+
+// verifyBasicPassword 校验Basic认证的用户名和密码，返回认证方式；
+// 启用了两步验证的用户只能使用应用专用密码，不能直接使用登录密码
+func verifyBasicPassword(user, password string) (string, bool) {
+	if twoFactor.verifyAppPassword(user, password) && htpasswdUsers.exists(user) {
+		return "app-password", true
+	}
+	if twoFactor.enabled(user) {
+		return "", false
+	}
+	if htpasswdUsers.verify(user, password) {
+		return "basic", true
+	}
+	return "", false
+}
+
+// mfaLogin 已通过密码验证、等待输入验证码的登录
+type mfaLogin struct {
+	user     string
+	expires  time.Time
+	attempts int
+}
+
+// mfaLoginStore 内存中等待两步验证的登录
+type mfaLoginStore struct {
+	mu     sync.Mutex
+	logins map[string]*mfaLogin
+}
+
+var mfaLogins = &mfaLoginStore{logins: make(map[string]*mfaLogin)}
+
+// start 记录通过密码验证的登录，返回用于下一步的令牌
+func (s *mfaLoginStore) start(user string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for token, l := range s.logins {
+		if now.After(l.expires) {
+			delete(s.logins, token)
+		}
+	}
+	token := randomToken()
+	s.logins[token] = &mfaLogin{user: user, expires: now.Add(mfaLoginTTL)}
+	return token
+}
+
+// user 返回令牌对应的用户
+func (s *mfaLoginStore) user(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.logins[token]
+	if !ok || time.Now().After(l.expires) {
+		delete(s.logins, token)
+		return "", false
+	}
+	return l.user, true
+}
+
+// fail 记录一次验证失败，达到上限后作废该登录，返回是否还可以重试
+func (s *mfaLoginStore) fail(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.logins[token]
+	if !ok {
+		return false
+	}
+	l.attempts++
+	if l.attempts >= mfaMaxAttempts {
+		delete(s.logins, token)
+		return false
+	}
+	return true
+}
+
+func (s *mfaLoginStore) finish(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.logins, token)
+}
+
+// passwordUser 返回通过htpasswd密码登录的用户（Basic认证或密码登录的会话），其他方式返回nil
+func passwordUser(r *http.Request) *identity {
+	id := authenticatedUser(r)
+	if id == nil || !htpasswdUsers.exists(id.Name) {
+		return nil
+	}
+	switch id.Method {
+	case "basic":
+		return id
+	case "session":
+		if _, sess := requestSession(r); sess != nil && sess.user.Method == "basic" {
+			return id
+		}
+	}
+	return nil
+}
+
+var twoFactorTemplate = template.Must(template.New("2fa").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>两步验证</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 640px; margin: 40px auto; padding: 0 20px; color: #333; }
+        .box { background: #f8f9fa; border: 1px solid #dee2e6; border-radius: 8px; padding: 20px; margin-bottom: 20px; }
+        .error { color: #dc3545; }
+        .secret { font-family: monospace; font-size: 1.1em; background: #fff; padding: 8px 12px; border: 1px dashed #999; word-break: break-all; }
+        table { border-collapse: collapse; width: 100%; }
+        td, th { text-align: left; padding: 4px 8px; border-bottom: 1px solid #dee2e6; }
+        button { background: #007bff; color: #fff; padding: 6px 14px; border: none; border-radius: 4px; cursor: pointer; }
+        button.danger { background: #dc3545; }
+    </style>
+</head>
+<body>
+<h2>🔐 两步验证 - {{.User}}</h2>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+
+{{if .RecoveryCodes}}
+<div class="box">
+    <h3>恢复码</h3>
+    <p>丢失身份验证器时可以用恢复码登录，每个恢复码只能使用一次。恢复码只显示这一次，请妥善保存：</p>
+    <pre class="secret">{{range .RecoveryCodes}}{{.}}
+{{end}}</pre>
+</div>
+{{end}}
+{{if .NewAppPassword}}
+<div class="box">
+    <h3>新的应用专用密码</h3>
+    <p>在WebDAV客户端中用它代替登录密码。密码只显示这一次：</p>
+    <p class="secret">{{.NewAppPassword}}</p>
+</div>
+{{end}}
+
+<div class="box">
+{{if .Record.Enabled}}
+    <p>✅ 已启用两步验证，剩余 {{len .Record.RecoveryCodes}} 个恢复码。</p>
+    <form method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <label>验证码或恢复码</label>
+        <input type="text" name="code" autocomplete="one-time-code" required>
+        <button type="submit" name="action" value="regenerate">重新生成恢复码</button>
+        <button type="submit" name="action" value="disable" class="danger">停用两步验证</button>
+    </form>
+{{else if .Record.PendingSecret}}
+    <p>1. 在身份验证器应用中扫描以下地址生成的二维码，或手动输入密钥：</p>
+    <p class="secret">{{.URI}}</p>
+    <p>密钥：<span class="secret">{{.Record.PendingSecret}}</span></p>
+    <p>2. 输入应用显示的6位验证码完成绑定：</p>
+    <form method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <input type="hidden" name="action" value="confirm">
+        <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code" required autofocus>
+        <button type="submit">确认</button>
+    </form>
+{{else}}
+    <p>尚未启用两步验证。启用后，网页登录时除密码外还需要输入身份验证器应用生成的验证码；
+    WebDAV客户端需要改用应用专用密码。</p>
+    <form method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <button type="submit" name="action" value="enroll">启用两步验证</button>
+    </form>
+{{end}}
+</div>
+
+<div class="box">
+    <h3>应用专用密码</h3>
+    {{if .Record.AppPasswords}}
+    <table>
+        <tr><th>名称</th><th>创建时间</th><th>最后使用</th><th></th></tr>
+        {{range .Record.AppPasswords}}
+        <tr>
+            <td>{{.Name}}</td>
+            <td>{{.Created.Format "2006-01-02 15:04"}}</td>
+            <td>{{if .LastUsed.IsZero}}从未使用{{else}}{{.LastUsed.Format "2006-01-02 15:04"}}{{end}}</td>
+            <td><form method="post">
+                <input type="hidden" name="csrf_token" value="{{$.CSRF}}">
+                <input type="hidden" name="id" value="{{.ID}}">
+                <button type="submit" name="action" value="app-delete" class="danger">删除</button>
+            </form></td>
+        </tr>
+        {{end}}
+    </table>
+    {{end}}
+    <form method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <input type="text" name="name" placeholder="名称，如 笔记本电脑WebDAV" required>
+        <button type="submit" name="action" value="app-create">创建</button>
+    </form>
+</div>
+<p><a href="/">返回首页</a></p>
+</body>
+</html>
+`))
+
+// twoFactorHandler 处理 /account/2fa：绑定和停用身份验证器、生成恢复码、管理应用专用密码
+func twoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if twoFactor == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id := passwordUser(r)
+	if id == nil {
+		if authenticatedUser(r) != nil {
+			http.Error(w, "两步验证只适用于使用密码登录的用户", http.StatusForbidden)
+			return
+		}
+		requestAuthentication(w, r)
+		return
+	}
+	user := id.Name
+	data := map[string]interface{}{"User": user}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		var err error
+		switch r.PostFormValue("action") {
+		case "enroll":
+			err = twoFactor.update(user, func(rec *twoFactorRecord) error {
+				if rec.Enabled {
+					return errors.New("已经启用了两步验证")
+				}
+				rec.PendingSecret = newTOTPSecret()
+				return nil
+			})
+		case "confirm":
+			codes, hashes := newRecoveryCodes()
+			err = twoFactor.update(user, func(rec *twoFactorRecord) error {
+				counter, ok := matchTOTP(rec.PendingSecret, normalizeSecretInput(r.PostFormValue("code")), time.Now())
+				if rec.PendingSecret == "" || !ok {
+					return errors.New("验证码错误，请确认手机时间准确后重试")
+				}
+				rec.Enabled, rec.Secret, rec.PendingSecret = true, rec.PendingSecret, ""
+				rec.RecoveryCodes, rec.LastCounter = hashes, counter
+				return nil
+			})
+			if err == nil {
+				log.Printf("用户 %s 启用了两步验证", user)
+				data["RecoveryCodes"] = codes
+			}
+		case "disable", "regenerate":
+			if !twoFactor.verifyCode(user, r.PostFormValue("code")) {
+				err = errors.New("验证码错误")
+				break
+			}
+			codes, hashes := newRecoveryCodes()
+			disable := r.PostFormValue("action") == "disable"
+			err = twoFactor.update(user, func(rec *twoFactorRecord) error {
+				if disable {
+					rec.Enabled, rec.Secret, rec.RecoveryCodes, rec.LastCounter = false, "", nil, 0
+				} else {
+					rec.RecoveryCodes = hashes
+				}
+				return nil
+			})
+			if err == nil && disable {
+				log.Printf("用户 %s 停用了两步验证", user)
+			} else if err == nil {
+				data["RecoveryCodes"] = codes
+			}
+		case "app-create":
+			name := strings.TrimSpace(r.PostFormValue("name"))
+			if name == "" {
+				err = errors.New("请填写名称")
+				break
+			}
+			p, password := newAppPassword(name)
+			err = twoFactor.update(user, func(rec *twoFactorRecord) error {
+				rec.AppPasswords = append(rec.AppPasswords, p)
+				return nil
+			})
+			if err == nil {
+				log.Printf("用户 %s 创建了应用专用密码 %s", user, p.ID)
+				data["NewAppPassword"] = password
+			}
+		case "app-delete":
+			target := r.PostFormValue("id")
+			err = twoFactor.update(user, func(rec *twoFactorRecord) error {
+				for i, p := range rec.AppPasswords {
+					if p.ID == target {
+						rec.AppPasswords = append(rec.AppPasswords[:i], rec.AppPasswords[i+1:]...)
+						return nil
+					}
+				}
+				return errors.New("应用专用密码不存在")
+			})
+		default:
+			err = errors.New("未知操作")
+		}
+		if err != nil {
+			data["Error"] = err.Error()
+		}
+	default:
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rec := twoFactor.get(user)
+	data["Record"] = rec
+	data["CSRF"] = csrfToken(w, r)
+	if rec.PendingSecret != "" {
+		data["URI"] = totpURI(user, rec.PendingSecret)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := twoFactorTemplate.Execute(w, data); err != nil {
+		log.Printf("无法渲染两步验证页面: %v", err)
+	}
+}
+
+// runTwoFactorCommand 实现 "sweb 2fa" 子命令：查看状态、为用户绑定或重置两步验证、创建应用专用密码
+func runTwoFactorCommand(args []string) {
+	action := ""
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("2fa", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", ".sweb", "程序状态数据目录")
+	fs.StringVar(&totpIssuer, "issuer", "sweb", "身份验证器应用中显示的服务名称")
+	fs.Usage = func() {
+		fmt.Println("用法:")
+		fmt.Println("  sweb 2fa status [-data-dir 目录]")
+		fmt.Println("  sweb 2fa enroll [-data-dir 目录] [-issuer 名称] <用户名>")
+		fmt.Println("  sweb 2fa disable [-data-dir 目录] <用户名>")
+		fmt.Println("  sweb 2fa app-password [-data-dir 目录] <用户名> <名称>")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	store, err := openTwoFactorStore(dataPath("2fa.json"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fail := func(format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", a...)
+		os.Exit(1)
+	}
+
+	switch {
+	case action == "status" && fs.NArg() == 0:
+		for _, name := range store.userNames() {
+			rec := store.get(name)
+			state := "未启用"
+			if rec.Enabled {
+				state = fmt.Sprintf("已启用 (剩余 %d 个恢复码)", len(rec.RecoveryCodes))
+			}
+			fmt.Printf("%-16s %s  应用专用密码: %d 个\n", name, state, len(rec.AppPasswords))
+		}
+
+	case action == "enroll" && fs.NArg() == 1:
+		user := fs.Arg(0)
+		secret := newTOTPSecret()
+		codes, hashes := newRecoveryCodes()
+		err := store.update(user, func(rec *twoFactorRecord) error {
+			rec.Enabled, rec.Secret, rec.PendingSecret = true, secret, ""
+			rec.RecoveryCodes, rec.LastCounter = hashes, 0
+			return nil
+		})
+		if err != nil {
+			fail("无法保存两步验证设置: %v", err)
+		}
+		fmt.Printf("已为用户 %s 启用两步验证\n", user)
+		fmt.Println("身份验证器配置地址 (可用 qrencode -t ansiutf8 生成二维码):")
+		fmt.Println(totpURI(user, secret))
+		fmt.Println("密钥:", secret)
+		fmt.Println("恢复码 (只显示这一次):")
+		for _, code := range codes {
+			fmt.Println("  " + code)
+		}
+
+	case action == "disable" && fs.NArg() == 1:
+		user := fs.Arg(0)
+		err := store.update(user, func(rec *twoFactorRecord) error {
+			if !rec.Enabled && rec.PendingSecret == "" {
+				return errors.New("该用户未启用两步验证")
+			}
+			rec.Enabled, rec.Secret, rec.PendingSecret, rec.RecoveryCodes, rec.LastCounter = false, "", "", nil, 0
+			return nil
+		})
+		if err != nil {
+			fail("无法停用两步验证: %v", err)
+		}
+		fmt.Printf("已停用用户 %s 的两步验证\n", user)
+
+	case action == "app-password" && fs.NArg() == 2:
+		p, password := newAppPassword(fs.Arg(1))
+		err := store.update(fs.Arg(0), func(rec *twoFactorRecord) error {
+			rec.AppPasswords = append(rec.AppPasswords, p)
+			return nil
+		})
+		if err != nil {
+			fail("无法保存应用专用密码: %v", err)
+		}
+		fmt.Printf("已为用户 %s 创建应用专用密码 %s (只显示这一次):\n%s\n", fs.Arg(0), p.Name, password)
+
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret RFC 6238附录B中SHA1测试向量使用的密钥
+var rfc6238Secret = []byte("12345678901234567890")
+
+// RFC 6238附录B的SHA1测试向量，取8位验证码的后6位
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("T=%d: totpCode = %s, 期望 %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+// RFC 4226附录D的HOTP测试向量
+func TestTOTPCodeRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := totpCode(rfc6238Secret, int64(counter)); got != code {
+			t.Errorf("计数器 %d: totpCode = %s, 期望 %s", counter, got, code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := base32NoPad.EncodeToString(rfc6238Secret)
+	now := time.Unix(1111111111, 0) // 时间步长 37037037，验证码 050471
+	tests := []struct {
+		name    string
+		secret  string
+		code    string
+		now     time.Time
+		counter int64
+		ok      bool
+	}{
+		{"当前时间步长", secret, "050471", now, 37037037, true},
+		{"小写密钥", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", now, 37037037, true},
+		{"上一个时间步长", secret, "050471", now.Add(totpPeriod * time.Second), 37037037, true},
+		{"下一个时间步长", secret, "050471", now.Add(-totpPeriod * time.Second), 37037037, true},
+		{"超出时钟偏差", secret, "050471", now.Add(2 * totpPeriod * time.Second), 0, false},
+		{"错误的验证码", secret, "050472", now, 0, false},
+		{"位数不符", secret, "50471", now, 0, false},
+		{"8位验证码", secret, "14050471", now, 0, false},
+		{"无效密钥", "not base32!", "050471", now, 0, false},
+	}
+	for _, tt := range tests {
+		counter, ok := matchTOTP(tt.secret, tt.code, tt.now)
+		if ok != tt.ok || counter != tt.counter {
+			t.Errorf("%s: matchTOTP = (%d, %v), 期望 (%d, %v)", tt.name, counter, ok, tt.counter, tt.ok)
+		}
+	}
+}
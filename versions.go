@@ -0,0 +1,680 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// fileVersionsMax 每个文件保留的历史版本数，0表示不保留历史版本
+var fileVersionsMax int
+
+// fileVersion 文件被覆盖前的一个历史版本，内容按SHA-256保存，相同内容只保存一份
+type fileVersion struct {
+	ID       int       `json:"id"`
+	Hash     string    `json:"sha256"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`       // 该版本内容的最后修改时间
+	Saved    time.Time `json:"saved"`          // 被覆盖的时间
+	User     string    `json:"user,omitempty"` // 覆盖该版本的用户
+}
+
+// fileHistory 单个文件的历史版本，按保存顺序排列
+type fileHistory struct {
+	Next     int           `json:"next"` // 下一个版本号
+	Versions []fileVersion `json:"versions"`
+}
+
+// versionJournalEntry 版本日志中的一条记录：
+// set 替换文件的全部历史，add 添加一个版本，drop 删除一个版本，
+// delete 删除文件及其下所有文件的历史，move 将历史随文件一起移动到 to
+type versionJournalEntry struct {
+	Op      string       `json:"op"`
+	Path    string       `json:"path"`
+	To      string       `json:"to,omitempty"`
+	ID      int          `json:"id,omitempty"`
+	Version *fileVersion `json:"version,omitempty"`
+	History *fileHistory `json:"history,omitempty"`
+}
+
+// versionStore 按文件的实际路径保存历史版本，内容保存在 <dir>/objects 中，索引以日志形式保存
+type versionStore struct {
+	mu      sync.Mutex
+	dir     string
+	journal jsonJournal
+	files   map[string]*fileHistory
+}
+
+// fileVersions 历史版本存储，未启用时为nil
+var fileVersions *versionStore
+
+// errVersionNotFound 请求的版本不存在
+var errVersionNotFound = errors.New("版本不存在")
+
+// newVersionStore 打开版本存储，重放日志并清理已不存在的文件的历史和不再被引用的内容
+func newVersionStore(dir string) (*versionStore, error) {
+	vs := &versionStore{dir: dir, journal: jsonJournal{path: filepath.Join(dir, "index.journal")}, files: make(map[string]*fileHistory)}
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0700); err != nil {
+		return nil, err
+	}
+	err := replayJournal(vs.journal.path, func(line []byte) error {
+		var entry versionJournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		vs.apply(entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key := range vs.files {
+		// 文件已被删除（或回收站中的内容已被清除）
+		if _, err := os.Lstat(key); os.IsNotExist(err) {
+			delete(vs.files, key)
+		}
+	}
+	vs.collectGarbage()
+	if err := vs.compact(); err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// apply 将一条记录应用到内存状态，返回受影响的文件数，调用者需持有锁
+func (vs *versionStore) apply(entry versionJournalEntry) int {
+	switch entry.Op {
+	case "set":
+		if entry.History == nil || len(entry.History.Versions) == 0 {
+			delete(vs.files, entry.Path)
+			return 1
+		}
+		vs.files[entry.Path] = entry.History
+		return 1
+	case "add":
+		h := vs.files[entry.Path]
+		if h == nil {
+			h = &fileHistory{Next: 1}
+			vs.files[entry.Path] = h
+		}
+		if entry.Version != nil {
+			h.Versions = append(h.Versions, *entry.Version)
+			if entry.Version.ID >= h.Next {
+				h.Next = entry.Version.ID + 1
+			}
+		}
+		return 1
+	case "drop":
+		h := vs.files[entry.Path]
+		if h == nil {
+			return 0
+		}
+		for i, v := range h.Versions {
+			if v.ID == entry.ID {
+				h.Versions = append(h.Versions[:i], h.Versions[i+1:]...)
+				break
+			}
+		}
+		if len(h.Versions) == 0 {
+			delete(vs.files, entry.Path)
+		}
+		return 1
+	case "delete":
+		n := 0
+		for key := range vs.files {
+			if propPathWithin(key, entry.Path) {
+				delete(vs.files, key)
+				n++
+			}
+		}
+		return n
+	case "move":
+		moved := make(map[string]*fileHistory)
+		for key, h := range vs.files {
+			if propPathWithin(key, entry.Path) {
+				moved[entry.To+strings.TrimPrefix(key, entry.Path)] = h
+				delete(vs.files, key)
+			}
+		}
+		for key := range vs.files {
+			if propPathWithin(key, entry.To) {
+				delete(vs.files, key)
+			}
+		}
+		for key, h := range moved {
+			vs.files[key] = h
+		}
+		return len(moved)
+	}
+	return 0
+}
+
+// compact 用当前的历史版本重写日志文件，调用者需持有锁
+func (vs *versionStore) compact() error {
+	keys := make([]string, 0, len(vs.files))
+	for key := range vs.files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, versionJournalEntry{Op: "set", Path: key, History: vs.files[key]})
+	}
+	return vs.journal.rewrite(entries)
+}
+
+// record 应用一条记录并写入日志，调用者需持有锁
+func (vs *versionStore) record(entry versionJournalEntry) {
+	n := vs.apply(entry)
+	if n == 0 {
+		return
+	}
+	if entry.Op != "add" {
+		vs.journal.stale += n
+	}
+	if vs.journal.needsCompact(len(vs.files)) {
+		err := vs.compact()
+		if err == nil {
+			return
+		}
+		log.Printf("⚠️ 无法压缩历史版本日志: %v", err)
+	}
+	if err := vs.journal.append(entry); err != nil {
+		log.Printf("⚠️ 无法写入历史版本日志: %v", err)
+	}
+}
+
+// objectPath 返回内容的保存路径
+func (vs *versionStore) objectPath(hash string) string {
+	return filepath.Join(vs.dir, "objects", hash[:2], hash)
+}
+
+// referenced 判断内容是否仍被某个版本引用，调用者需持有锁
+func (vs *versionStore) referenced(hash string) bool {
+	for _, h := range vs.files {
+		for _, v := range h.Versions {
+			if v.Hash == hash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collectGarbage 删除不再被任何版本引用的内容和残留的临时文件，只在启动时调用
+func (vs *versionStore) collectGarbage() {
+	used := make(map[string]bool)
+	for _, h := range vs.files {
+		for _, v := range h.Versions {
+			used[v.Hash] = true
+		}
+	}
+	filepath.WalkDir(filepath.Join(vs.dir, "objects"), func(p string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && !used[d.Name()] {
+			os.Remove(p)
+		}
+		return nil
+	})
+}
+
+// dropLocked 删除一个版本，内容不再被引用时一并删除，调用者需持有锁
+func (vs *versionStore) dropLocked(key string, v fileVersion) {
+	vs.record(versionJournalEntry{Op: "drop", Path: key, ID: v.ID})
+	if !vs.referenced(v.Hash) {
+		os.Remove(vs.objectPath(v.Hash))
+	}
+}
+
+// save 在文件被覆盖前将其当前内容保存为一个历史版本，超出数量限制时删除最旧的版本；
+// 文件不存在、为空或内容与最新版本相同时不保存
+func (vs *versionStore) save(key, user string) error {
+	f, err := os.Open(key)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 {
+		return err
+	}
+
+	// 复制内容时不持有锁，避免大文件阻塞其他请求
+	tmp, err := os.CreateTemp(filepath.Join(vs.dir, "objects"), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	sum := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, sum), f)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))
+
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	h := vs.files[key]
+	if h != nil && len(h.Versions) > 0 && h.Versions[len(h.Versions)-1].Hash == hash {
+		return nil
+	}
+	dst := vs.objectPath(hash)
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), dst); err != nil {
+			return err
+		}
+	}
+	next := 1
+	if h != nil {
+		next = h.Next
+	}
+	v := fileVersion{ID: next, Hash: hash, Size: size, Modified: fi.ModTime().UTC(), Saved: time.Now().UTC(), User: user}
+	vs.record(versionJournalEntry{Op: "add", Path: key, Version: &v})
+	for h := vs.files[key]; len(h.Versions) > fileVersionsMax; {
+		vs.dropLocked(key, h.Versions[0])
+	}
+	return nil
+}
+
+// list 返回文件的历史版本，最新的在前
+func (vs *versionStore) list(key string) []fileVersion {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	h := vs.files[key]
+	if h == nil {
+		return nil
+	}
+	result := make([]fileVersion, len(h.Versions))
+	for i, v := range h.Versions {
+		result[len(h.Versions)-1-i] = v
+	}
+	return result
+}
+
+// find 查找文件的指定版本
+func (vs *versionStore) find(key string, id int) (fileVersion, error) {
+	for _, v := range vs.list(key) {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return fileVersion{}, errVersionNotFound
+}
+
+// open 打开文件指定版本的内容
+func (vs *versionStore) open(key string, id int) (*os.File, fileVersion, error) {
+	v, err := vs.find(key, id)
+	if err != nil {
+		return nil, v, err
+	}
+	f, err := os.Open(vs.objectPath(v.Hash))
+	return f, v, err
+}
+
+// drop 删除文件的指定版本
+func (vs *versionStore) drop(key string, id int) error {
+	v, err := vs.find(key, id)
+	if err != nil {
+		return err
+	}
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.dropLocked(key, v)
+	return nil
+}
+
+// restore 用指定版本的内容替换文件，替换前将当前内容保存为新的历史版本
+func (vs *versionStore) restore(key string, id int, user string) error {
+	src, v, err := vs.open(key, id)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := vs.save(key, user); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(key), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(key), ".restore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	os.Chmod(tmp.Name(), 0644)
+	os.Chtimes(tmp.Name(), time.Now(), v.Modified)
+	return os.Rename(tmp.Name(), key)
+}
+
+// moveTree 将文件及其下所有文件的历史移动到新位置
+func (vs *versionStore) moveTree(from, to string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.record(versionJournalEntry{Op: "move", Path: from, To: to})
+}
+
+// removeTree 删除文件及其下所有文件的历史
+func (vs *versionStore) removeTree(key string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	var removed []fileVersion
+	for k, h := range vs.files {
+		if propPathWithin(k, key) {
+			removed = append(removed, h.Versions...)
+		}
+	}
+	vs.record(versionJournalEntry{Op: "delete", Path: key})
+	for _, v := range removed {
+		if !vs.referenced(v.Hash) {
+			os.Remove(vs.objectPath(v.Hash))
+		}
+	}
+}
+
+// count 返回保存了历史版本的文件数量
+func (vs *versionStore) count() int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	return len(vs.files)
+}
+
+// saveFileVersion 在覆盖文件前保存其历史版本，未启用历史版本时不做任何操作
+func saveFileVersion(file, user string) error {
+	if fileVersions == nil {
+		return nil
+	}
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	return fileVersions.save(key, user)
+}
+
+// setupFileVersions 打开历史版本存储
+func setupFileVersions() {
+	if fileVersionsMax <= 0 {
+		return
+	}
+	vs, err := newVersionStore(dataPath("versions"))
+	if err != nil {
+		log.Fatalf("无法加载历史版本: %v", err)
+	}
+	fileVersions = vs
+	fmt.Printf("✅ 文件历史版本已启用: 每个文件保留 %d 个版本 (%s)\n", fileVersionsMax, vs.dir)
+}
+
+// webdavAbsPath 返回WebDAV路径对应文件的绝对路径，用作属性和历史版本的存储键
+func webdavAbsPath(ctx context.Context, name string) (string, error) {
+	p, err := webdavRealPath(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(p)
+}
+
+// versionFS 在WebDAV覆盖文件前保存其历史版本，并在移动文件时一起移动历史
+type versionFS struct {
+	webdav.FileSystem
+}
+
+func (fs *versionFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&os.O_TRUNC != 0 {
+		if key, err := webdavAbsPath(ctx, name); err == nil {
+			user := ""
+			if id := contextIdentity(ctx); id != nil {
+				user = id.Name
+			}
+			if err := fileVersions.save(key, user); err != nil {
+				log.Printf("⚠️ 无法保存历史版本 %s: %v", name, err)
+				return nil, err
+			}
+		}
+	}
+	return fs.FileSystem.OpenFile(ctx, name, flag, perm)
+}
+
+func (fs *versionFS) RemoveAll(ctx context.Context, name string) error {
+	key, keyErr := webdavAbsPath(ctx, name)
+	if err := fs.FileSystem.RemoveAll(ctx, name); err != nil {
+		return err
+	}
+	// 移入回收站的文件还原后仍可使用原来的历史版本
+	if keyErr == nil && webdavTrashRetention <= 0 {
+		fileVersions.removeTree(key)
+	}
+	return nil
+}
+
+func (fs *versionFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldKey, oldErr := webdavAbsPath(ctx, oldName)
+	newKey, newErr := webdavAbsPath(ctx, newName)
+	if err := fs.FileSystem.Rename(ctx, oldName, newName); err != nil {
+		return err
+	}
+	if oldErr == nil && newErr == nil {
+		fileVersions.moveTree(oldKey, newKey)
+	}
+	return nil
+}
+
+// setupWebDAVVersions 启用历史版本时包装WebDAV文件系统
+func setupWebDAVVersions(fs webdav.FileSystem) webdav.FileSystem {
+	if fileVersions == nil {
+		return fs
+	}
+	return &versionFS{FileSystem: fs}
+}
+
+// versionMount 返回URL路径所属的挂载点：webdav 或 static
+func versionMount(urlPath string) string {
+	if webdavEnabled && (urlPath == "/webdav" || strings.HasPrefix(urlPath, "/webdav/")) {
+		return "webdav"
+	}
+	return "static"
+}
+
+// versionedFilePath 将URL路径映射为文件的绝对路径，即历史版本的存储键；
+// WebDAV路径按请求的用户解析，回收站等内部目录视为不存在
+func versionedFilePath(r *http.Request, urlPath string) (string, error) {
+	urlPath = path.Clean("/" + urlPath)
+	if versionMount(urlPath) == "webdav" {
+		name := strings.TrimPrefix(urlPath, "/webdav")
+		if _, rel, err := webdavStorageRoot(r.Context(), name); err != nil || trashInternal(rel) {
+			return "", os.ErrNotExist
+		}
+		return webdavAbsPath(r.Context(), name)
+	}
+	return filepath.Abs(filepath.Join("web", filepath.FromSlash(urlPath)))
+}
+
+// fileVersionHandler 处理带 ?version= 参数的GET和HEAD请求，返回文件的历史版本内容
+func fileVersionHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fileVersions == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) || !r.URL.Query().Has("version") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// 分享链接只公开文件的当前内容，不能通过它读取历史版本
+		if id := requestIdentity(r); id != nil && id.Method == "share" {
+			http.Error(w, "分享链接不能访问历史版本", http.StatusForbidden)
+			return
+		}
+		if !aclAllowed(r, r.URL.Path, aclRead) {
+			aclDenied(w, r, r.URL.Path)
+			return
+		}
+		id, err := strconv.Atoi(r.URL.Query().Get("version"))
+		if err != nil {
+			http.Error(w, "版本号无效", http.StatusBadRequest)
+			return
+		}
+		key, err := versionedFilePath(r, r.URL.Path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, v, err := fileVersions.open(key, id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("ETag", `"`+v.Hash+`"`)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		http.ServeContent(w, r, path.Base(r.URL.Path), v.Modified, f)
+	})
+}
+
+// versionsAPIHandler 处理 /api/versions?path=<URL路径>：
+// GET 列出文件的历史版本，POST &version= 还原到指定版本，DELETE &version= 删除指定版本
+func versionsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if fileVersions == nil {
+		http.Error(w, "文件历史版本未启用", http.StatusNotFound)
+		return
+	}
+	urlPath := r.URL.Query().Get("path")
+	if urlPath == "" {
+		http.Error(w, "需要指定 path", http.StatusBadRequest)
+		return
+	}
+	urlPath = path.Clean("/" + urlPath)
+	mount := versionMount(urlPath)
+	// 按文件所属挂载点的IP过滤和认证要求保护；启用用户主目录时路径需要在认证后按用户解析
+	requireAuth(mount, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveVersionsAPI(w, r, mount, urlPath)
+	})).ServeHTTP(w, r)
+}
+
+// serveVersionsAPI 在通过挂载点的认证后处理版本API请求
+func serveVersionsAPI(w http.ResponseWriter, r *http.Request, mount, urlPath string) {
+	if !aclAllowed(r, urlPath, aclRead) {
+		aclDenied(w, r, urlPath)
+		return
+	}
+	key, err := versionedFilePath(r, urlPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		versions := fileVersions.list(key)
+		if versions == nil {
+			versions = []fileVersion{}
+		}
+		result := map[string]interface{}{"path": urlPath, "versions": versions}
+		if fi, err := os.Stat(key); err == nil && fi.Mode().IsRegular() {
+			result["size"] = fi.Size()
+			result["modified"] = fi.ModTime().UTC()
+		}
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 还原和删除版本需要文件所在挂载点的写入权限
+	id := requestIdentity(r)
+	switch {
+	case !aclAllowed(r, urlPath, aclWrite):
+		http.Error(w, "没有权限修改该文件", http.StatusForbidden)
+		return
+	case mount == "webdav" && davPolicy.mode(id, urlPath) != davModeReadWrite:
+		http.Error(w, "WebDAV访问策略不允许修改该文件", http.StatusForbidden)
+		return
+	case mount == "static" && (!uploadEnabled || dropboxEnabled || !mountIPAllowed("upload", r)):
+		http.Error(w, "没有权限修改该文件", http.StatusForbidden)
+		return
+	case mount == "static" && authRequired("upload", r) && id == nil:
+		requestAuthentication(w, r)
+		return
+	}
+	name := strings.TrimPrefix(urlPath, "/webdav")
+	if mount == "webdav" && davLocks != nil {
+		key := webdavLockKey(r.Context(), name)
+		for _, l := range davLocks.list() {
+			if l.covers(key) {
+				http.Error(w, "文件已被锁定", http.StatusLocked)
+				return
+			}
+		}
+	}
+	versionID, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil {
+		http.Error(w, "需要指定有效的 version", http.StatusBadRequest)
+		return
+	}
+	user := ""
+	if id != nil {
+		user = id.Name
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := fileVersions.drop(key, versionID); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": versionID})
+		return
+	}
+	if err := fileVersions.restore(key, versionID, user); err != nil {
+		if errors.Is(err, errVersionNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, fmt.Sprintf("还原失败: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if root, _ := quotaRoot(r.Context(), name); mount == "webdav" && root != "" {
+		davQuotaUsage.invalidate(root)
+	}
+	recordSyncChange(key, false)
+	log.Printf("🕘 %s 已还原到版本 %d", urlPath, versionID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"restored": versionID})
+}
+
+// fileVersionStatus 返回状态API中历史版本的信息
+func fileVersionStatus() map[string]interface{} {
+	status := map[string]interface{}{
+		"enabled": fileVersions != nil,
+		"max":     fileVersionsMax,
+	}
+	if fileVersions != nil {
+		status["files"] = fileVersions.count()
+	}
+	return status
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// versionObjects 统计版本存储中保存的内容数
+func versionObjects(t *testing.T, vs *versionStore) int {
+	t.Helper()
+	n := 0
+	filepath.WalkDir(filepath.Join(vs.dir, "objects"), func(p string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// versionIDs 返回文件的版本号，最新的在前
+func versionIDs(vs *versionStore, key string) string {
+	var ids []int
+	for _, v := range vs.list(key) {
+		ids = append(ids, v.ID)
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestVersionStoreDedupAndPrune(t *testing.T) {
+	oldMax := fileVersionsMax
+	fileVersionsMax = 3
+	defer func() { fileVersionsMax = oldMax }()
+
+	dir := t.TempDir()
+	vs, err := newVersionStore(filepath.Join(dir, "versions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := filepath.Join(dir, "a.txt")
+	other := filepath.Join(dir, "b.txt")
+
+	// 每一步先将文件内容写为content，再在覆盖前保存版本
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		ids     string // 文件的版本号，最新的在前
+		objects int    // 保存的内容数
+	}{
+		{"空文件不保存", key, "", "[]", 0},
+		{"第一个版本", key, "v1", "[1]", 1},
+		{"内容与最新版本相同", key, "v1", "[1]", 1},
+		{"新内容", key, "v2", "[2 1]", 2},
+		{"与较早的版本相同时仍保存，内容只存一份", key, "v1", "[3 2 1]", 2},
+		{"超出数量时删除最旧的版本，内容仍被引用", key, "v3", "[4 3 2]", 3},
+		{"内容不再被引用时删除", key, "v4", "[5 4 3]", 3},
+		{"其他文件的相同内容只存一份", other, "v4", "[1]", 3},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(tt.file, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := vs.save(tt.file, "alice"); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ids := versionIDs(vs, tt.file); ids != tt.ids {
+			t.Errorf("%s: 版本 = %s, 期望 %s", tt.name, ids, tt.ids)
+		}
+		if n := versionObjects(t, vs); n != tt.objects {
+			t.Errorf("%s: 内容数 = %d, 期望 %d", tt.name, n, tt.objects)
+		}
+	}
+
+	f, v, err := vs.open(key, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 8)
+	n, _ := f.Read(data)
+	f.Close()
+	if string(data[:n]) != "v1" || v.User != "alice" || v.Size != 2 {
+		t.Errorf("版本3 = %+v, 内容 %q", v, data[:n])
+	}
+	if _, err := vs.find(key, 1); err != errVersionNotFound {
+		t.Errorf("已删除的版本: 错误 = %v, 期望 %v", err, errVersionNotFound)
+	}
+	if err := vs.save(filepath.Join(dir, "missing.txt"), ""); err != nil {
+		t.Errorf("不存在的文件: %v", err)
+	}
+
+	// 重新打开后历史保持不变，已删除文件的历史和不再被引用的内容被清理
+	if err := os.Remove(other); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := newVersionStore(vs.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := versionIDs(reopened, key); ids != "[5 4 3]" {
+		t.Errorf("重新打开后的版本 = %s, 期望 [5 4 3]", ids)
+	}
+	if ids := versionIDs(reopened, other); ids != "[]" {
+		t.Errorf("已删除文件的版本 = %s, 期望 []", ids)
+	}
+	if n := versionObjects(t, reopened); n != 3 {
+		t.Errorf("重新打开后的内容数 = %d, 期望 3", n)
+	}
+	if err := os.WriteFile(key, []byte("v5"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.save(key, ""); err != nil {
+		t.Fatal(err)
+	}
+	if ids := versionIDs(reopened, key); ids != "[6 5 4]" {
+		t.Errorf("重新打开后继续编号: 版本 = %s, 期望 [6 5 4]", ids)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Finder创建的 ._* 和 .DS_Store 文件的处理方式
+const (
+	appleFilesKeep    = "keep"    // 与普通文件相同
+	appleFilesHide    = "hide"    // 正常保存，但不出现在目录列表、搜索和同步报告中
+	appleFilesDiscard = "discard" // 不写入WebDAV目录，而是保存在数据目录中的临时区域，过期后删除
+)
+
+// appleFilesExpiry 被丢弃的Finder文件在临时区域中未被修改多久后删除
+const appleFilesExpiry = 24 * time.Hour
+
+// appleFilesMaxBytes 临时区域中单个Finder文件的最大大小，超出时返回507；
+// 这些文件不计入配额，限制大小以免被用来绕过配额存放任意内容
+const appleFilesMaxBytes = 1 << 20
+
+// errAppleFileTooLarge 写入临时区域的Finder文件超出 appleFilesMaxBytes
+var errAppleFileTooLarge = errors.New("Finder文件超出大小限制")
+
+// webdavAppleFiles -webdav-apple-files 参数，取值见上面的常量
+var webdavAppleFiles string
+
+// davClient 根据User-Agent识别出的WebDAV客户端
+type davClient int
+
+const (
+	davClientOther   davClient = iota
+	davClientWindows           // Windows资源管理器（WebClient服务）和Office
+	davClientFinder            // macOS Finder
+)
+
+var (
+	windowsDAVAgents = []string{"Microsoft-WebDAV-MiniRedir", "DavClnt", "Microsoft Office", "Microsoft Data Access Internet Publishing Provider"}
+	finderDAVAgents  = []string{"WebDAVFS/", "WebDAVLib/"}
+)
+
+// detectDAVClient 根据User-Agent识别需要兼容处理的WebDAV客户端
+func detectDAVClient(r *http.Request) davClient {
+	ua := r.UserAgent()
+	for _, prefix := range windowsDAVAgents {
+		if strings.HasPrefix(ua, prefix) {
+			return davClientWindows
+		}
+	}
+	for _, prefix := range finderDAVAgents {
+		if strings.HasPrefix(ua, prefix) {
+			return davClientFinder
+		}
+	}
+	return davClientOther
+}
+
+// windowsBasicAuthHint 保证Windows通过HTTP使用Basic认证的提示只记录一次
+var windowsBasicAuthHint sync.Once
+
+// davClientMiddleware 为Windows资源管理器和macOS Finder处理兼容问题：
+// 在服务器根目录响应OPTIONS和PROPFIND，为Windows客户端添加Microsoft的头部，
+// 以及在路由前规范WebDAV路径，避免这些不跟随重定向的客户端收到301
+func davClientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !webdavEnabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == "/" && (r.Method == http.MethodOptions || r.Method == "PROPFIND") {
+			serveDAVServerRoot(w, r)
+			return
+		}
+		client := detectDAVClient(r)
+		if client == davClientOther || (r.URL.Path != "/webdav" && !strings.HasPrefix(r.URL.Path, "/webdav/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// ServeMux会将包含 // 或 . 的路径重定向到规范形式，而这两种客户端对PROPFIND、PUT等请求不跟随重定向
+		if clean := cleanDAVPath(r.URL.Path); clean != r.URL.Path && (clean == "/webdav" || strings.HasPrefix(clean, "/webdav/")) {
+			r.URL.Path, r.URL.RawPath = clean, ""
+		}
+
+		switch client {
+		case davClientWindows:
+			w.Header().Set("MS-Author-Via", "DAV")
+			if r.TLS == nil && r.Header.Get("Authorization") == "" && basicAuthEnabled() {
+				rec := &statusRecorder{ResponseWriter: w}
+				next.ServeHTTP(rec, r)
+				if rec.status == http.StatusUnauthorized {
+					windowsBasicAuthHint.Do(func() {
+						log.Printf("ℹ️ Windows WebDAV客户端 (%s) 通过HTTP请求认证；Windows默认只在HTTPS上发送Basic认证，"+
+							"如无法登录请使用HTTPS，或将注册表 HKLM\\SYSTEM\\CurrentControlSet\\Services\\WebClient\\Parameters\\BasicAuthLevel 设为2",
+							requestClientIP(r))
+					})
+				}
+				return
+			}
+		case davClientFinder:
+			// Finder以分块编码上传，在X-Expected-Entity-Length中给出文件大小，用于写入前的配额检查
+			if r.Method == http.MethodPut && r.ContentLength < 0 {
+				if n, err := strconv.ParseInt(r.Header.Get("X-Expected-Entity-Length"), 10, 64); err == nil && n >= 0 {
+					r.ContentLength = n
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cleanDAVPath 规范URL路径，保留末尾的斜杠
+func cleanDAVPath(p string) string {
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
+
+// serveDAVServerRoot 响应服务器根目录上的OPTIONS和PROPFIND。Windows资源管理器映射网络驱动器前
+// 会先查询服务器根目录，根目录不是WebDAV集合时拒绝映射
+func serveDAVServerRoot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, 2")
+	w.Header().Set("MS-Author-Via", "DAV")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(r.Body, 1<<20))
+	responses := davCollectionResponse("/", "")
+	if r.Header.Get("Depth") != "0" {
+		responses = append(responses, davCollectionResponse("/webdav/", "webdav")...)
+		if caldavEnabled {
+			responses = append(responses, davCollectionResponse(pimPrefix+"/", strings.TrimPrefix(pimPrefix, "/"))...)
+		}
+	}
+	writeMultistatus(w, responses)
+}
+
+// davCollectionResponse 返回描述一个集合的 DAV:response 元素
+func davCollectionResponse(urlPath, displayName string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<D:response><D:href>")
+	xml.EscapeText(&buf, []byte(urlPath))
+	buf.WriteString("</D:href><D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype><D:displayname>")
+	xml.EscapeText(&buf, []byte(displayName))
+	buf.WriteString("</D:displayname></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>")
+	return buf.Bytes()
+}
+
+// appleJunkName 判断文件名是否是Finder创建的AppleDouble（._*）或 .DS_Store 文件
+func appleJunkName(name string) bool {
+	return strings.HasPrefix(name, "._") || name == ".DS_Store"
+}
+
+// webdavHiddenFile 判断资源是否按 -webdav-apple-files 的设置对目录列表、搜索和同步报告隐藏，
+// name可以是WebDAV路径或磁盘路径
+func webdavHiddenFile(name string) bool {
+	return (webdavAppleFiles == appleFilesHide || webdavAppleFiles == appleFilesDiscard) && appleJunkName(filepath.Base(name))
+}
+
+// appleFilesFS 在目录列表中隐藏Finder的 ._* 和 .DS_Store 文件；dir不为空时这些文件保存在dir中，
+// 而不是WebDAV目录中，Finder仍能正常读写它们
+type appleFilesFS struct {
+	webdav.FileSystem
+	dir string
+}
+
+// discarded 判断WebDAV路径是否指向应保存在临时区域中的Finder文件
+func (fs *appleFilesFS) discarded(name string) bool {
+	return fs.dir != "" && appleJunkName(path.Base(name))
+}
+
+// shadowPath 返回Finder文件在临时区域中的位置，按资源的磁盘路径区分不同目录和用户
+func (fs *appleFilesFS) shadowPath(ctx context.Context, name string) (string, error) {
+	root, rel, err := webdavStorageRoot(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(filepath.Join(root, filepath.FromSlash(rel))))
+	return filepath.Join(fs.dir, hex.EncodeToString(sum[:])), nil
+}
+
+func (fs *appleFilesFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if fs.discarded(name) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *appleFilesFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if fs.discarded(name) {
+		// 与普通文件一样，所在目录必须存在
+		if fi, err := fs.FileSystem.Stat(ctx, path.Dir(path.Clean("/"+name))); err != nil {
+			return nil, err
+		} else if !fi.IsDir() {
+			return nil, os.ErrNotExist
+		}
+		p, err := fs.shadowPath(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(p, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		sf := &appleShadowFile{File: f, name: path.Base(name), ctx: ctx, path: p}
+		if fi, err := f.Stat(); err == nil {
+			sf.size = fi.Size()
+		}
+		return sf, nil
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &appleHidingFile{File: f}, nil
+}
+
+func (fs *appleFilesFS) RemoveAll(ctx context.Context, name string) error {
+	if !fs.discarded(name) {
+		return fs.FileSystem.RemoveAll(ctx, name)
+	}
+	p, err := fs.shadowPath(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (fs *appleFilesFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldDiscarded, newDiscarded := fs.discarded(oldName), fs.discarded(newName)
+	if !oldDiscarded && !newDiscarded {
+		return fs.FileSystem.Rename(ctx, oldName, newName)
+	}
+	if oldDiscarded != newDiscarded {
+		return os.ErrPermission
+	}
+	oldPath, err := fs.shadowPath(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := fs.shadowPath(ctx, newName)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (fs *appleFilesFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if !fs.discarded(name) {
+		return fs.FileSystem.Stat(ctx, name)
+	}
+	p, err := fs.shadowPath(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	return renamedFileInfo{FileInfo: fi, name: path.Base(name)}, nil
+}
+
+// appleHidingFile 在目录列表中隐藏Finder的 ._* 和 .DS_Store 文件
+type appleHidingFile struct {
+	webdav.File
+}
+
+func (f *appleHidingFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	result := infos[:0]
+	for _, fi := range infos {
+		if !appleJunkName(fi.Name()) {
+			result = append(result, fi)
+		}
+	}
+	return result, err
+}
+
+// appleShadowFile 保存在临时区域中的Finder文件，以其WebDAV文件名呈现
+type appleShadowFile struct {
+	*os.File
+	name     string
+	ctx      context.Context
+	path     string
+	size     int64 // 文件大小的上限估计：打开时的大小加上之后写入的字节数
+	exceeded bool
+}
+
+func (f *appleShadowFile) Write(p []byte) (int, error) {
+	if f.size+int64(len(p)) > appleFilesMaxBytes {
+		f.exceeded = true
+		markQuotaExceeded(f.ctx)
+		return 0, errAppleFileTooLarge
+	}
+	n, err := f.File.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *appleShadowFile) Close() error {
+	err := f.File.Close()
+	if f.exceeded {
+		os.Remove(f.path)
+	}
+	return err
+}
+
+func (f *appleShadowFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return renamedFileInfo{FileInfo: fi, name: f.name}, nil
+}
+
+// purgeAppleFiles 删除临时区域中超过 appleFilesExpiry 未被修改的Finder文件
+func purgeAppleFiles(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("⚠️ 无法读取Finder文件临时目录 %s: %v", dir, err)
+		return
+	}
+	cutoff := time.Now().Add(-appleFilesExpiry)
+	for _, entry := range entries {
+		if fi, err := entry.Info(); err == nil && fi.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+// setupWebDAVAppleFiles 按 -webdav-apple-files 的设置处理Finder创建的文件，keep时直接返回原文件系统
+func setupWebDAVAppleFiles(fs webdav.FileSystem) webdav.FileSystem {
+	switch webdavAppleFiles {
+	case appleFilesKeep:
+		return fs
+	case appleFilesHide:
+		fmt.Println("✅ Finder创建的 ._* 和 .DS_Store 文件将在WebDAV目录列表中隐藏")
+		return &appleFilesFS{FileSystem: fs}
+	case appleFilesDiscard:
+		dir := dataPath("webdav-apple-files")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Fatalf("无法创建Finder文件临时目录: %v", err)
+		}
+		go func() {
+			for {
+				purgeAppleFiles(dir)
+				time.Sleep(time.Hour)
+			}
+		}()
+		fmt.Printf("✅ Finder创建的 ._* 和 .DS_Store 文件不会写入WebDAV目录，%s 未修改后删除\n", appleFilesExpiry)
+		return &appleFilesFS{FileSystem: fs, dir: dir}
+	default:
+		log.Fatalf("无效的 -webdav-apple-files 取值: %s (可选: keep, hide, discard)", webdavAppleFiles)
+		return nil
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+// 用户主目录相关配置
+var (
+	webdavUserHomes bool
+	webdavShared    string
+)
+
+// userHomeFS 按请求的认证用户将WebDAV根目录解析为 <root>/users/<用户名>，
+// 并可在每个主目录中挂载共享文件夹
+type userHomeFS struct {
+	root   string
+	shared map[string]string // 共享文件夹名称 -> 实际目录
+
+	created sync.Map // 已确认存在的主目录
+}
+
+// parseSharedFolders 解析 "名称=目录,名称=目录" 格式的共享文件夹配置
+func parseSharedFolders(spec string) (map[string]string, error) {
+	shared := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, dir, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !validPathSegment(name) || strings.TrimSpace(dir) == "" {
+			return nil, fmt.Errorf("共享文件夹配置格式错误: %s (应为 名称=目录)", item)
+		}
+		dir = strings.TrimSpace(dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("无法创建共享文件夹 %s: %v", dir, err)
+		}
+		shared[name] = dir
+	}
+	return shared, nil
+}
+
+// validPathSegment 判断名称能否安全地作为单级目录名使用
+func validPathSegment(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+func newUserHomeFS(root string, shared map[string]string) *userHomeFS {
+	return &userHomeFS{root: root, shared: shared}
+}
+
+// homeDir 返回用户的主目录，首次访问时自动创建
+func (fs *userHomeFS) homeDir(user string) (string, error) {
+	dir := filepath.Join(fs.root, "users", user)
+	if _, ok := fs.created.Load(user); ok {
+		return dir, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	fs.created.Store(user, true)
+	return dir, nil
+}
+
+// resolve 将请求路径映射到实际的文件系统和其中的路径；
+// sharedRoot 表示路径正好是某个共享文件夹的挂载点
+func (fs *userHomeFS) resolve(ctx context.Context, name string) (dir webdav.Dir, rel string, sharedRoot bool, err error) {
+	id := contextIdentity(ctx)
+	if id == nil || !validPathSegment(id.Name) {
+		return "", "", false, os.ErrPermission
+	}
+
+	name = path.Clean("/" + name)
+	first, rest, _ := strings.Cut(strings.TrimPrefix(name, "/"), "/")
+	if sharedDir, ok := fs.shared[first]; ok {
+		return webdav.Dir(sharedDir), "/" + rest, rest == "", nil
+	}
+
+	home, err := fs.homeDir(id.Name)
+	if err != nil {
+		return "", "", false, err
+	}
+	return webdav.Dir(home), name, false, nil
+}
+
+// realPath 返回请求路径对应的实际文件路径
+func (fs *userHomeFS) realPath(ctx context.Context, name string) (string, error) {
+	dir, rel, _, err := fs.resolve(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(string(dir), filepath.FromSlash(rel)), nil
+}
+
+func (fs *userHomeFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	dir, rel, sharedRoot, err := fs.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if sharedRoot {
+		return os.ErrExist
+	}
+	return dir.Mkdir(ctx, rel, perm)
+}
+
+func (fs *userHomeFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	dir, rel, _, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := dir.OpenFile(ctx, rel, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if path.Clean("/"+name) == "/" && len(fs.shared) > 0 {
+		return &homeRootFile{File: f, fs: fs}, nil
+	}
+	return f, nil
+}
+
+func (fs *userHomeFS) RemoveAll(ctx context.Context, name string) error {
+	dir, rel, sharedRoot, err := fs.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if sharedRoot || rel == "/" {
+		return os.ErrPermission
+	}
+	return dir.RemoveAll(ctx, rel)
+}
+
+func (fs *userHomeFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldDir, oldRel, oldShared, err := fs.resolve(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	newDir, newRel, newShared, err := fs.resolve(ctx, newName)
+	if err != nil {
+		return err
+	}
+	if oldShared || newShared || oldRel == "/" || newRel == "/" {
+		return os.ErrPermission
+	}
+	if oldDir != newDir {
+		// 主目录与共享文件夹之间不支持直接重命名
+		return os.ErrPermission
+	}
+	return oldDir.Rename(ctx, oldRel, newRel)
+}
+
+func (fs *userHomeFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	dir, rel, sharedRoot, err := fs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := dir.Stat(ctx, rel)
+	if err != nil {
+		return nil, err
+	}
+	if sharedRoot {
+		return renamedFileInfo{FileInfo: fi, name: path.Base(path.Clean("/" + name))}, nil
+	}
+	return fi, nil
+}
+
+// homeRootFile 在列出主目录根时附加共享文件夹
+type homeRootFile struct {
+	webdav.File
+	fs   *userHomeFS
+	done bool
+}
+
+func (f *homeRootFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	if f.done || (err != nil && len(infos) == 0 && count <= 0) {
+		return infos, err
+	}
+	f.done = true
+
+	// 共享文件夹覆盖主目录中的同名条目
+	filtered := infos[:0]
+	for _, fi := range infos {
+		if _, ok := f.fs.shared[fi.Name()]; !ok {
+			filtered = append(filtered, fi)
+		}
+	}
+	names := make([]string, 0, len(f.fs.shared))
+	for name := range f.fs.shared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fi, err := os.Stat(f.fs.shared[name]); err == nil {
+			filtered = append(filtered, renamedFileInfo{FileInfo: fi, name: name})
+		}
+	}
+	return filtered, err
+}
+
+// renamedFileInfo 以不同的名称呈现文件信息
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi renamedFileInfo) Name() string { return fi.name }
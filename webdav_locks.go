@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// webdavLockMaxTimeout WebDAV锁的最长有效期，客户端请求无限期或更长的锁时按此截断，0表示不限制
+var webdavLockMaxTimeout time.Duration
+
+// davLock 持久化的WebDAV锁记录
+type davLock struct {
+	Token     string    `json:"token"`
+	Root      string    `json:"root"`
+	Key       string    `json:"key,omitempty"` // 判断冲突时使用的资源路径，为空时与Root相同
+	ZeroDepth bool      `json:"zero_depth"`
+	OwnerXML  string    `json:"owner_xml,omitempty"`
+	Timeout   int64     `json:"timeout"` // 秒，-1 表示无限期
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires,omitempty"`
+
+	held      bool // 正在被某个请求使用 (Confirm)，使用期间不会过期，也不能被解锁
+	transient bool // 处理器为没有锁令牌的写请求临时创建的锁，只保存在内存中
+}
+
+// transientLock 判断是否是 webdav.Handler 为没有If请求头的写请求创建的临时锁：
+// 无限期、零深度且没有所有者信息。这类锁在请求结束时立即释放，无需写入日志
+func transientLock(details webdav.LockDetails) bool {
+	return details.Duration < 0 && details.ZeroDepth && details.OwnerXML == ""
+}
+
+// details 转换为 webdav.LockDetails
+func (l *davLock) details() webdav.LockDetails {
+	d := time.Duration(l.Timeout) * time.Second
+	if l.Timeout < 0 {
+		d = -1
+	}
+	return webdav.LockDetails{Root: l.Root, Duration: d, OwnerXML: l.OwnerXML, ZeroDepth: l.ZeroDepth}
+}
+
+// setDuration 根据锁的时长设置过期时间，超过 -webdav-lock-timeout 时截断
+func (l *davLock) setDuration(now time.Time, d time.Duration) {
+	if webdavLockMaxTimeout > 0 && (d < 0 || d > webdavLockMaxTimeout) {
+		d = webdavLockMaxTimeout
+	}
+	if d < 0 {
+		l.Timeout = -1
+		l.Expires = time.Time{}
+		return
+	}
+	l.Timeout = int64(d / time.Second)
+	l.Expires = now.Add(d)
+}
+
+// expired 判断锁是否已过期，被持有的锁不会过期
+func (l *davLock) expired(now time.Time) bool {
+	return !l.held && !l.Expires.IsZero() && !now.Before(l.Expires)
+}
+
+// key 返回判断锁冲突时使用的资源路径
+func (l *davLock) key() string {
+	if l.Key != "" {
+		return l.Key
+	}
+	return l.Root
+}
+
+// covers 判断锁是否作用于指定资源（按key比较）：资源就是锁的根，或者锁为无限深度且资源在其下
+func (l *davLock) covers(key string) bool {
+	return lockCovers(l.key(), l.ZeroDepth, key)
+}
+
+// lockCovers 判断根为root的锁是否作用于name
+func lockCovers(root string, zeroDepth bool, name string) bool {
+	return name == root || (!zeroDepth && lockPathWithin(name, root))
+}
+
+// lockPathWithin 判断name是否是dir下的资源（不含dir本身）
+func lockPathWithin(name, dir string) bool {
+	if dir == "/" {
+		return name != "/"
+	}
+	return strings.HasPrefix(name, dir+"/")
+}
+
+// lockJournalEntry 日志文件中的一条记录：put 写入或更新锁，delete 删除锁
+type lockJournalEntry struct {
+	Op    string   `json:"op"`
+	Lock  *davLock `json:"lock,omitempty"`
+	Token string   `json:"token,omitempty"`
+}
+
+// fileLockSystem 实现 webdav.LockSystem，锁的变化以JSON行的形式追加到日志文件，
+// 启动时重放日志恢复未过期的锁，因此服务重启后客户端持有的锁仍然有效
+type fileLockSystem struct {
+	mu      sync.Mutex
+	journal jsonJournal
+	locks   map[string]*davLock
+}
+
+// davLocks WebDAV服务使用的锁管理器，未启用WebDAV时为nil
+var davLocks *fileLockSystem
+
+// newFileLockSystem 打开锁日志文件，重放其中的记录并压缩日志
+func newFileLockSystem(path string) (*fileLockSystem, error) {
+	ls := &fileLockSystem{journal: jsonJournal{path: path}, locks: make(map[string]*davLock)}
+	err := replayJournal(path, func(line []byte) error {
+		var entry lockJournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		switch {
+		case entry.Op == "put" && entry.Lock != nil && entry.Lock.Token != "":
+			ls.locks[entry.Lock.Token] = entry.Lock
+		case entry.Op == "delete":
+			delete(ls.locks, entry.Token)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ls.collectExpired(time.Now())
+	if err := ls.compact(); err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+// compact 用当前的锁重写日志文件，调用者需持有锁
+func (ls *fileLockSystem) compact() error {
+	var entries []interface{}
+	for _, l := range ls.sortedLocks() {
+		if !l.transient {
+			entries = append(entries, lockJournalEntry{Op: "put", Lock: l})
+		}
+	}
+	return ls.journal.rewrite(entries)
+}
+
+// append 在内存状态更新后追加一条日志记录，调用者需持有锁。
+// 写入失败只记录日志，锁在内存中仍然有效，避免磁盘问题导致客户端无法编辑文件
+func (ls *fileLockSystem) append(entry lockJournalEntry) {
+	if ls.journal.needsCompact(len(ls.locks)) {
+		// 压缩后的日志已包含本次变化
+		err := ls.compact()
+		if err == nil {
+			return
+		}
+		log.Printf("⚠️ 无法压缩WebDAV锁日志: %v", err)
+	}
+	if err := ls.journal.append(entry); err != nil {
+		log.Printf("⚠️ 无法写入WebDAV锁日志: %v", err)
+	}
+}
+
+// put 保存新建或刷新后的锁，调用者需持有锁
+func (ls *fileLockSystem) put(l *davLock) {
+	if _, ok := ls.locks[l.Token]; ok {
+		ls.journal.stale++
+	}
+	ls.locks[l.Token] = l
+	if l.transient {
+		return
+	}
+	ls.append(lockJournalEntry{Op: "put", Lock: l})
+}
+
+// remove 删除锁，调用者需持有锁
+func (ls *fileLockSystem) remove(token string) {
+	l := ls.locks[token]
+	delete(ls.locks, token)
+	if l == nil || l.transient {
+		return
+	}
+	ls.journal.stale += 2
+	ls.append(lockJournalEntry{Op: "delete", Token: token})
+}
+
+// collectExpired 删除已过期的锁，调用者需持有锁。
+// 过期的锁不写入日志，重放时会根据过期时间自动丢弃
+func (ls *fileLockSystem) collectExpired(now time.Time) {
+	for token, l := range ls.locks {
+		if l.expired(now) {
+			delete(ls.locks, token)
+			if !l.transient {
+				ls.journal.stale++
+			}
+		}
+	}
+}
+
+// sortedLocks 按资源路径排序返回所有锁，调用者需持有锁
+func (ls *fileLockSystem) sortedLocks() []*davLock {
+	result := make([]*davLock, 0, len(ls.locks))
+	for _, l := range ls.locks {
+		result = append(result, l)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Root != result[j].Root {
+			return result[i].Root < result[j].Root
+		}
+		return result[i].Created.Before(result[j].Created)
+	})
+	return result
+}
+
+// lookup 返回条件中与资源匹配且未被持有的锁，规则与 webdav.NewMemLS 相同，调用者需持有锁
+func (ls *fileLockSystem) lookup(name string, conditions ...webdav.Condition) *davLock {
+	for _, c := range conditions {
+		l := ls.locks[c.Token]
+		if l == nil || l.held {
+			continue
+		}
+		if l.covers(name) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Confirm 实现 webdav.LockSystem
+func (ls *fileLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	var l0, l1 *davLock
+	if name0 != "" {
+		if l0 = ls.lookup(cleanLockName(name0), conditions...); l0 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if name1 != "" {
+		if l1 = ls.lookup(cleanLockName(name1), conditions...); l1 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if l1 == l0 {
+		l1 = nil
+	}
+	if l0 != nil {
+		l0.held = true
+	}
+	if l1 != nil {
+		l1.held = true
+	}
+	return func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		if l1 != nil {
+			l1.held = false
+		}
+		if l0 != nil {
+			l0.held = false
+		}
+	}, nil
+}
+
+// Create 实现 webdav.LockSystem
+func (ls *fileLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	return ls.create(now, details, cleanLockName(details.Root))
+}
+
+// create 创建锁，key为判断冲突时使用的资源路径
+func (ls *fileLockSystem) create(now time.Time, details webdav.LockDetails, key string) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	root := cleanLockName(details.Root)
+	for _, l := range ls.locks {
+		// 资源本身已被锁定、祖先目录有无限深度的锁，或请求无限深度的锁而其下已有锁
+		if l.covers(key) || (!details.ZeroDepth && lockPathWithin(l.key(), key)) {
+			return "", webdav.ErrLocked
+		}
+	}
+	l := &davLock{
+		Token:     newLockToken(),
+		Root:      root,
+		ZeroDepth: details.ZeroDepth,
+		OwnerXML:  details.OwnerXML,
+		Created:   now,
+		transient: transientLock(details),
+	}
+	if key != root {
+		l.Key = key
+	}
+	l.setDuration(now, details.Duration)
+	ls.put(l)
+	return l.Token, nil
+}
+
+// Refresh 实现 webdav.LockSystem
+func (ls *fileLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	l := ls.locks[token]
+	if l == nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if l.held {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	l.setDuration(now, duration)
+	ls.put(l)
+	return l.details(), nil
+}
+
+// Unlock 实现 webdav.LockSystem
+func (ls *fileLockSystem) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	l := ls.locks[token]
+	if l == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if l.held {
+		return webdav.ErrLocked
+	}
+	ls.remove(token)
+	return nil
+}
+
+// list 返回当前有效的锁
+func (ls *fileLockSystem) list() []davLock {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(time.Now())
+	result := []davLock{}
+	for _, l := range ls.sortedLocks() {
+		result = append(result, *l)
+	}
+	return result
+}
+
+// count 返回当前有效的锁数量
+func (ls *fileLockSystem) count() int {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(time.Now())
+	return len(ls.locks)
+}
+
+// release 强制释放指定令牌的锁，或作用于指定资源的所有锁（包括祖先目录上的无限深度锁和其下的锁），
+// 返回释放的锁；正在被请求使用的锁不会被释放
+func (ls *fileLockSystem) release(token, name string) (released []davLock, busy int) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(time.Now())
+	for _, l := range ls.sortedLocks() {
+		match := l.Token == token
+		if name != "" {
+			match = lockCovers(l.Root, l.ZeroDepth, name) || lockPathWithin(l.Root, name)
+		}
+		if !match {
+			continue
+		}
+		if l.held {
+			busy++
+			continue
+		}
+		ls.remove(l.Token)
+		released = append(released, *l)
+	}
+	return released, busy
+}
+
+// scopedLockSystem 将请求中的WebDAV路径转换为存储路径后交给fileLockSystem，
+// 启用用户主目录时不同用户的同名路径是不同的文件，而共享文件夹中的同一文件对所有用户是同一个资源
+type scopedLockSystem struct {
+	ls  *fileLockSystem
+	ctx context.Context
+}
+
+// key 返回资源的存储路径，无法解析时使用WebDAV路径
+func (s *scopedLockSystem) key(name string) string {
+	if name == "" {
+		return ""
+	}
+	return webdavLockKey(s.ctx, name)
+}
+
+func (s *scopedLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	return s.ls.Confirm(now, s.key(name0), s.key(name1), conditions...)
+}
+
+func (s *scopedLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	return s.ls.create(now, details, s.key(details.Root))
+}
+
+func (s *scopedLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	return s.ls.Refresh(now, token, duration)
+}
+
+func (s *scopedLockSystem) Unlock(now time.Time, token string) error {
+	return s.ls.Unlock(now, token)
+}
+
+// webdavLockKey 返回WebDAV资源在锁管理器中的路径：启用用户主目录时为磁盘上的绝对路径，否则为WebDAV路径
+func webdavLockKey(ctx context.Context, name string) string {
+	if webdavHomes != nil {
+		if key, err := webdavAbsPath(ctx, name); err == nil {
+			return cleanLockName(filepath.ToSlash(key))
+		}
+	}
+	return cleanLockName(name)
+}
+
+// scopedLockHandler 启用用户主目录时为每个请求使用按存储路径区分资源的锁管理器
+func scopedLockHandler(handler *webdav.Handler) http.Handler {
+	if webdavHomes == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := *handler
+		h.LockSystem = &scopedLockSystem{ls: davLocks, ctx: r.Context()}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// cleanLockName 规范化锁的资源路径
+func cleanLockName(name string) string {
+	if name == "" {
+		return "/"
+	}
+	return path.Clean("/" + name)
+}
+
+// newLockToken 生成 urn:uuid 格式的锁令牌，重启后仍然唯一
+func newLockToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// setupWebDAVLocks 打开持久化的WebDAV锁日志
+func setupWebDAVLocks() webdav.LockSystem {
+	ls, err := newFileLockSystem(dataPath("webdav-locks.journal"))
+	if err != nil {
+		log.Fatalf("无法加载WebDAV锁: %v", err)
+	}
+	davLocks = ls
+	fmt.Printf("✅ WebDAV锁已持久化: %s (已恢复 %d 个锁)\n", ls.journal.path, len(ls.locks))
+	return ls
+}
+
+// davLockView 管理API中展示的锁
+type davLockView struct {
+	davLock
+	Path string `json:"path"`
+	Held bool   `json:"held"`
+}
+
+// webdavLocksAPIHandler 处理 /api/webdav/locks（仅管理员）：
+// GET 列出当前有效的锁，DELETE ?token= 或 ?path= 强制释放锁
+func webdavLocksAPIHandler(w http.ResponseWriter, r *http.Request) {
+	id := authenticatedUser(r)
+	if id == nil {
+		requestAuthentication(w, r)
+		return
+	}
+	if !isAdmin(id) {
+		http.Error(w, "需要管理员权限", http.StatusForbidden)
+		return
+	}
+	if davLocks == nil {
+		http.Error(w, "WebDAV服务未启用", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		locks := davLocks.list()
+		result := make([]davLockView, len(locks))
+		for i, l := range locks {
+			result[i] = davLockView{davLock: l, Path: path.Join("/webdav", l.Root), Held: l.held}
+		}
+		writeJSON(w, http.StatusOK, result)
+
+	case http.MethodDelete:
+		q := r.URL.Query()
+		token, name := q.Get("token"), q.Get("path")
+		if token == "" && name == "" {
+			http.Error(w, "需要指定 token 或 path", http.StatusBadRequest)
+			return
+		}
+		if name != "" {
+			name = cleanLockName(strings.TrimPrefix(cleanLockName(name), "/webdav"))
+		}
+		released, busy := davLocks.release(token, name)
+		if len(released) == 0 && busy == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		for _, l := range released {
+			log.Printf("🔓 管理员 %s 强制释放了WebDAV锁 %s (%s)", id.Name, l.Token, path.Join("/webdav", l.Root))
+		}
+		if busy > 0 {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"released": len(released),
+				"busy":     busy,
+				"error":    "部分锁正在被请求使用，请稍后重试",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"released": len(released)})
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// webdavPolicySpec WebDAV访问策略规则，格式: 用户=模式,@组=模式,/webdav/路径=模式
+var webdavPolicySpec string
+
+// WebDAV方法的权限类别
+const (
+	davPermRead  = "read"
+	davPermLock  = "lock"
+	davPermWrite = "write"
+)
+
+// davMethodPerms 每个WebDAV方法所需的权限类别，未列出的方法按写入处理
+var davMethodPerms = map[string]string{
+	"OPTIONS":   davPermRead,
+	"GET":       davPermRead,
+	"HEAD":      davPermRead,
+	"PROPFIND":  davPermRead,
+	"REPORT":    davPermRead,
+	"SEARCH":    davPermRead,
+	"LOCK":      davPermLock,
+	"UNLOCK":    davPermLock,
+	"PUT":       davPermWrite,
+	"POST":      davPermWrite,
+	"DELETE":    davPermWrite,
+	"MKCOL":     davPermWrite,
+	"COPY":      davPermWrite,
+	"MOVE":      davPermWrite,
+	"PROPPATCH": davPermWrite,
+}
+
+// davMethodPerm 返回方法所需的权限类别
+func davMethodPerm(method string) string {
+	if perm, ok := davMethodPerms[method]; ok {
+		return perm
+	}
+	return davPermWrite
+}
+
+// davMode WebDAV访问模式，数值越小限制越严格
+type davMode int
+
+const (
+	davModeReadOnlyStrict davMode = iota // 只读，拒绝LOCK
+	davModeReadOnly                      // 只读，LOCK返回不会阻止其他人的虚拟共享锁
+	davModeReadWrite                     // 读写
+)
+
+// davModeNames 配置中使用的模式名称
+var davModeNames = map[string]davMode{
+	"ro-strict": davModeReadOnlyStrict,
+	"ro":        davModeReadOnly,
+	"rw":        davModeReadWrite,
+}
+
+func (m davMode) String() string {
+	for name, mode := range davModeNames {
+		if mode == m {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// permits 判断模式是否允许某个权限类别
+func (m davMode) permits(perm string) bool {
+	switch perm {
+	case davPermRead:
+		return true
+	case davPermLock:
+		return m >= davModeReadOnly
+	}
+	return m == davModeReadWrite
+}
+
+// allowedMethods 返回模式下允许的方法，用于405响应的Allow头
+func (m davMode) allowedMethods() string {
+	var methods []string
+	for method, perm := range davMethodPerms {
+		if m.permits(perm) && method != "POST" {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// webdavPolicy 按用户、组和路径决定WebDAV的访问模式：
+// 用户的模式取用户规则、组规则或默认模式，再与最长匹配的路径规则取限制更严格的一方
+type webdavPolicy struct {
+	defaultMode davMode
+	users       map[string]davMode
+	groups      map[string]davMode
+	paths       map[string]davMode
+}
+
+var davPolicy = &webdavPolicy{defaultMode: davModeReadWrite}
+
+// parseWebDAVPolicy 解析策略规则
+func parseWebDAVPolicy(spec string, defaultMode davMode) (*webdavPolicy, error) {
+	p := &webdavPolicy{
+		defaultMode: defaultMode,
+		users:       make(map[string]davMode),
+		groups:      make(map[string]davMode),
+		paths:       make(map[string]davMode),
+	}
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		subject, modeName, ok := strings.Cut(item, "=")
+		subject = strings.TrimSpace(subject)
+		mode, known := davModeNames[strings.TrimSpace(modeName)]
+		if !ok || subject == "" || !known {
+			return nil, fmt.Errorf("策略规则格式错误: %s (应为 用户=模式、@组=模式 或 /webdav/路径=模式，模式为 rw、ro 或 ro-strict)", item)
+		}
+		switch {
+		case strings.HasPrefix(subject, "/"):
+			clean := path.Clean(subject)
+			if !pathWithin(clean, "/webdav") {
+				return nil, fmt.Errorf("策略路径必须位于 /webdav 下: %s", subject)
+			}
+			p.paths[clean] = mode
+		case strings.HasPrefix(subject, "@"):
+			p.groups[strings.TrimPrefix(subject, "@")] = mode
+		default:
+			p.users[subject] = mode
+		}
+	}
+	return p, nil
+}
+
+// principalMode 返回用户的访问模式：用户规则优先，其次是所属组中最宽松的组规则，最后是默认模式
+func (p *webdavPolicy) principalMode(id *identity) davMode {
+	if id == nil {
+		return p.defaultMode
+	}
+	if mode, ok := p.users[id.Name]; ok {
+		return mode
+	}
+	groups := id.Groups
+	if aclEnabled() {
+		groups = acl.userGroups(id)
+	}
+	found, best := false, davModeReadOnlyStrict
+	for _, g := range groups {
+		if mode, ok := p.groups[g]; ok && (!found || mode > best) {
+			found, best = true, mode
+		}
+	}
+	if found {
+		return best
+	}
+	return p.defaultMode
+}
+
+// pathMode 返回最长匹配的路径规则，没有匹配时不限制
+func (p *webdavPolicy) pathMode(urlPath string) davMode {
+	best, mode := "", davModeReadWrite
+	for prefix, m := range p.paths {
+		if pathWithin(urlPath, prefix) && len(prefix) > len(best) {
+			best, mode = prefix, m
+		}
+	}
+	return mode
+}
+
+// mode 返回用户访问某个URL路径时的模式
+func (p *webdavPolicy) mode(id *identity, urlPath string) davMode {
+	mode := p.principalMode(id)
+	if pm := p.pathMode(path.Clean("/" + urlPath)); pm < mode {
+		mode = pm
+	}
+	return mode
+}
+
+// setupWebDAVPolicy 解析WebDAV访问策略，-webdav-readonly 决定默认模式
+func setupWebDAVPolicy() {
+	defaultMode := davModeReadWrite
+	if webdavReadonly {
+		defaultMode = davModeReadOnly
+	}
+	p, err := parseWebDAVPolicy(webdavPolicySpec, defaultMode)
+	if err != nil {
+		log.Fatalf("-webdav-policy 配置错误: %v", err)
+	}
+	davPolicy = p
+	if n := len(p.users) + len(p.groups) + len(p.paths); n > 0 {
+		fmt.Printf("✅ WebDAV访问策略已启用 - 默认: %s, %d 条规则\n", p.defaultMode, n)
+	}
+}
+
+// webdavPolicyStatus 返回状态API中的访问策略信息
+func webdavPolicyStatus() map[string]interface{} {
+	return map[string]interface{}{
+		"default": davPolicy.defaultMode.String(),
+		"rules":   len(davPolicy.users) + len(davPolicy.groups) + len(davPolicy.paths),
+	}
+}
+
+// webdavPolicyMiddleware 按访问策略检查WebDAV请求：只读模式下拒绝写入方法并返回正确的Allow头，
+// OPTIONS响应只列出允许的方法，ro模式下的LOCK/UNLOCK返回虚拟共享锁，使先加锁再读取的客户端能正常打开文件
+func webdavPolicyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestIdentity(r)
+		mode := davPolicy.mode(id, r.URL.Path)
+		if r.Method == "MOVE" || r.Method == "COPY" {
+			if u, err := url.Parse(r.Header.Get("Destination")); err == nil && u.Path != "" {
+				destMode := davPolicy.mode(id, u.Path)
+				if r.Method == "COPY" {
+					// 复制只读取源资源
+					mode = destMode
+				} else if destMode < mode {
+					mode = destMode
+				}
+			}
+		}
+
+		perm := davMethodPerm(r.Method)
+		switch {
+		case r.Method == http.MethodOptions:
+			aw := &davAllowWriter{ResponseWriter: w, mode: mode, exists: true}
+			if mode != davModeReadWrite {
+				_, err := webdavFS.Stat(r.Context(), path.Clean("/"+strings.TrimPrefix(r.URL.Path, "/webdav")))
+				aw.exists = err == nil
+			}
+			next.ServeHTTP(aw, r)
+			aw.apply()
+		case mode == davModeReadWrite || perm == davPermRead:
+			next.ServeHTTP(w, r)
+		case perm == davPermLock && mode.permits(perm):
+			fakeLockHandler(w, r, mode)
+		default:
+			w.Header().Set("Allow", mode.allowedMethods())
+			http.Error(w, "WebDAV资源处于只读模式", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// davAllowWriter 从OPTIONS响应的Allow头中去掉当前模式不允许的方法；
+// ro-strict模式不支持锁，DAV头只声明第1级
+type davAllowWriter struct {
+	http.ResponseWriter
+	mode    davMode
+	exists  bool // 资源不存在时只读模式下也不能加锁（加锁会创建资源）
+	applied bool
+}
+
+func (w *davAllowWriter) apply() {
+	if w.applied {
+		return
+	}
+	w.applied = true
+	h := w.ResponseWriter.Header()
+	if allow := h.Get("Allow"); allow != "" {
+		var kept []string
+		for _, method := range strings.Split(allow, ",") {
+			method = strings.TrimSpace(method)
+			perm := davMethodPerm(method)
+			if w.mode.permits(perm) && (w.exists || perm != davPermLock || w.mode == davModeReadWrite) {
+				kept = append(kept, method)
+			}
+		}
+		h.Set("Allow", strings.Join(kept, ", "))
+	}
+	if !w.mode.permits(davPermLock) && h.Get("DAV") != "" {
+		h.Set("DAV", "1")
+	}
+}
+
+func (w *davAllowWriter) WriteHeader(code int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *davAllowWriter) Write(p []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(p)
+}
+
+// fakeLockPrefix 虚拟锁令牌的前缀，UNLOCK和刷新时据此识别，无需保存状态
+const fakeLockPrefix = "opaquelocktoken:sweb-readonly-"
+
+var ifHeaderToken = regexp.MustCompile(`<([^>]+)>`)
+
+// fakeLockInfo LOCK请求体中需要回显的部分
+type fakeLockInfo struct {
+	Owner struct {
+		InnerXML string `xml:",innerxml"`
+	} `xml:"owner"`
+}
+
+// fakeLockHandler 为只读客户端处理LOCK/UNLOCK：返回不会阻止任何人的共享锁，
+// 不写入锁管理器也不会创建资源
+func fakeLockHandler(w http.ResponseWriter, r *http.Request, mode davMode) {
+	name := strings.TrimPrefix(r.URL.Path, "/webdav")
+	if _, err := webdavFS.Stat(r.Context(), path.Clean("/"+name)); err != nil {
+		// 对不存在的资源加锁会创建空文件，属于写入
+		w.Header().Set("Allow", mode.allowedMethods())
+		http.Error(w, "WebDAV资源处于只读模式", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Method == "UNLOCK" {
+		if strings.HasPrefix(strings.Trim(r.Header.Get("Lock-Token"), "<> "), fakeLockPrefix) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, "锁令牌无效", http.StatusConflict)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, "无法读取请求", http.StatusBadRequest)
+		return
+	}
+	var token, owner string
+	if len(strings.TrimSpace(string(body))) == 0 {
+		// 刷新锁
+		for _, m := range ifHeaderToken.FindAllStringSubmatch(r.Header.Get("If"), -1) {
+			if strings.HasPrefix(m[1], fakeLockPrefix) {
+				token = m[1]
+			}
+		}
+		if token == "" {
+			http.Error(w, "锁令牌无效", http.StatusPreconditionFailed)
+			return
+		}
+	} else {
+		var info fakeLockInfo
+		if err := xml.Unmarshal(body, &info); err != nil {
+			http.Error(w, "LOCK请求格式错误", http.StatusBadRequest)
+			return
+		}
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		token, owner = fakeLockPrefix+hex.EncodeToString(b), info.Owner.InnerXML
+		w.Header().Set("Lock-Token", "<"+token+">")
+	}
+
+	timeout := strings.TrimSpace(strings.Split(r.Header.Get("Timeout"), ",")[0])
+	if !strings.HasPrefix(timeout, "Second-") {
+		timeout = "Infinite"
+	}
+	depth := "infinity"
+	if r.Header.Get("Depth") == "0" {
+		depth = "0"
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`+
+		`<D:locktype><D:write/></D:locktype><D:lockscope><D:shared/></D:lockscope>`+
+		`<D:depth>%s</D:depth><D:owner>%s</D:owner><D:timeout>%s</D:timeout>`+
+		`<D:locktoken><D:href>%s</D:href></D:locktoken><D:lockroot><D:href>%s</D:href></D:lockroot>`+
+		`</D:activelock></D:lockdiscovery></D:prop>`,
+		depth, owner, html.EscapeString(timeout), html.EscapeString(token), html.EscapeString(r.URL.EscapedPath()))
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/webdav"
+)
+
+// 单个资源的死属性总大小上限，防止客户端写入过大的属性占满内存和磁盘
+const deadPropsMaxBytes = 64 * 1024
+
+// storedProp 持久化的WebDAV死属性
+type storedProp struct {
+	Space string `json:"ns"`
+	Local string `json:"name"`
+	Lang  string `json:"lang,omitempty"`
+	XML   string `json:"xml"`
+}
+
+// propJournalEntry 属性日志中的一条记录：
+// set 替换资源的全部属性，delete 删除资源及其下所有资源的属性，move 将属性随资源一起移动到 to
+type propJournalEntry struct {
+	Op    string       `json:"op"`
+	Path  string       `json:"path"`
+	To    string       `json:"to,omitempty"`
+	Props []storedProp `json:"props,omitempty"`
+}
+
+// propStore 按文件的实际路径保存WebDAV死属性（PROPPATCH写入的属性），
+// 数据以日志形式保存在状态数据目录中，不会在WebDAV目录里留下额外的文件
+type propStore struct {
+	mu      sync.Mutex
+	journal jsonJournal
+	props   map[string]map[xml.Name]webdav.Property
+}
+
+// newPropStore 打开属性日志文件，重放记录并丢弃已不存在的文件的属性
+func newPropStore(path string) (*propStore, error) {
+	ps := &propStore{journal: jsonJournal{path: path}, props: make(map[string]map[xml.Name]webdav.Property)}
+	err := replayJournal(path, func(line []byte) error {
+		var entry propJournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		ps.apply(entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key := range ps.props {
+		// 在WebDAV之外被删除的文件
+		if _, err := os.Lstat(key); os.IsNotExist(err) {
+			delete(ps.props, key)
+		}
+	}
+	if err := ps.compact(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// apply 将一条记录应用到内存状态，返回受影响的资源数，调用者需持有锁
+func (ps *propStore) apply(entry propJournalEntry) int {
+	switch entry.Op {
+	case "set":
+		if len(entry.Props) == 0 {
+			delete(ps.props, entry.Path)
+			return 1
+		}
+		m := make(map[xml.Name]webdav.Property, len(entry.Props))
+		for _, p := range entry.Props {
+			name := xml.Name{Space: p.Space, Local: p.Local}
+			m[name] = webdav.Property{XMLName: name, Lang: p.Lang, InnerXML: []byte(p.XML)}
+		}
+		ps.props[entry.Path] = m
+		return 1
+	case "delete":
+		n := 0
+		for key := range ps.props {
+			if propPathWithin(key, entry.Path) {
+				delete(ps.props, key)
+				n++
+			}
+		}
+		return n
+	case "move":
+		moved := make(map[string]map[xml.Name]webdav.Property)
+		for key, m := range ps.props {
+			if propPathWithin(key, entry.Path) {
+				moved[entry.To+strings.TrimPrefix(key, entry.Path)] = m
+				delete(ps.props, key)
+			}
+		}
+		// 目标位置原有的属性已被覆盖
+		for key := range ps.props {
+			if propPathWithin(key, entry.To) {
+				delete(ps.props, key)
+			}
+		}
+		for key, m := range moved {
+			ps.props[key] = m
+		}
+		return len(moved)
+	}
+	return 0
+}
+
+// propPathWithin 判断key是否是dir本身或其下的文件
+func propPathWithin(key, dir string) bool {
+	return key == dir || strings.HasPrefix(key, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// storedProps 将属性转换为可序列化的形式，按名称排序
+func storedProps(m map[xml.Name]webdav.Property) []storedProp {
+	result := make([]storedProp, 0, len(m))
+	for name, p := range m {
+		result = append(result, storedProp{Space: name.Space, Local: name.Local, Lang: p.Lang, XML: string(p.InnerXML)})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Space != result[j].Space {
+			return result[i].Space < result[j].Space
+		}
+		return result[i].Local < result[j].Local
+	})
+	return result
+}
+
+// compact 用当前的属性重写日志文件，调用者需持有锁
+func (ps *propStore) compact() error {
+	keys := make([]string, 0, len(ps.props))
+	for key := range ps.props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, propJournalEntry{Op: "set", Path: key, Props: storedProps(ps.props[key])})
+	}
+	return ps.journal.rewrite(entries)
+}
+
+// record 应用一条记录并写入日志，调用者需持有锁
+func (ps *propStore) record(entry propJournalEntry) {
+	_, existed := ps.props[entry.Path]
+	n := ps.apply(entry)
+	if entry.Op == "set" && !existed {
+		n--
+	}
+	if n == 0 && entry.Op != "set" {
+		// 没有属性受影响，无需写入日志
+		return
+	}
+	ps.journal.stale += n
+	if ps.journal.needsCompact(len(ps.props)) {
+		err := ps.compact()
+		if err == nil {
+			return
+		}
+		log.Printf("⚠️ 无法压缩WebDAV属性日志: %v", err)
+	}
+	if err := ps.journal.append(entry); err != nil {
+		log.Printf("⚠️ 无法写入WebDAV属性日志: %v", err)
+	}
+}
+
+// get 返回资源的死属性
+func (ps *propStore) get(key string) map[xml.Name]webdav.Property {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	result := make(map[xml.Name]webdav.Property, len(ps.props[key]))
+	for name, p := range ps.props[key] {
+		result[name] = p
+	}
+	return result
+}
+
+// patch 按PROPPATCH请求设置或删除资源的死属性
+func (ps *propStore) patch(key string, patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	m := make(map[xml.Name]webdav.Property, len(ps.props[key]))
+	for name, p := range ps.props[key] {
+		m[name] = p
+	}
+	pstat := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+			if patch.Remove {
+				delete(m, p.XMLName)
+				continue
+			}
+			m[p.XMLName] = p
+		}
+	}
+
+	size := 0
+	for name, p := range m {
+		size += len(name.Space) + len(name.Local) + len(p.InnerXML)
+	}
+	if size > deadPropsMaxBytes {
+		pstat.Status = http.StatusInsufficientStorage
+		pstat.ResponseDescription = fmt.Sprintf("属性总大小超过 %d 字节", deadPropsMaxBytes)
+		return []webdav.Propstat{pstat}, nil
+	}
+	ps.record(propJournalEntry{Op: "set", Path: key, Props: storedProps(m)})
+	return []webdav.Propstat{pstat}, nil
+}
+
+// removeTree 删除资源及其下所有资源的属性
+func (ps *propStore) removeTree(key string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.record(propJournalEntry{Op: "delete", Path: key})
+}
+
+// moveTree 将资源及其下所有资源的属性移动到新位置
+func (ps *propStore) moveTree(from, to string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.record(propJournalEntry{Op: "move", Path: from, To: to})
+}
+
+// count 返回保存了属性的资源数量
+func (ps *propStore) count() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return len(ps.props)
+}
+
+// davProps WebDAV服务使用的属性存储，未启用WebDAV时为nil
+var davProps *propStore
+
+// propsFS 为文件系统返回的文件提供死属性支持（webdav.DeadPropsHolder），
+// 并在删除、移动资源时同步删除、移动其属性；复制时由webdav处理器通过Patch复制属性
+type propsFS struct {
+	webdav.FileSystem
+	store    *propStore
+	realPath func(ctx context.Context, name string) (string, error) // 为nil时使用WebDAV的路径映射
+}
+
+// key 返回资源属性的存储键
+func (fs *propsFS) key(ctx context.Context, name string) (string, error) {
+	if fs.realPath != nil {
+		return fs.realPath(ctx, name)
+	}
+	return webdavAbsPath(ctx, name)
+}
+
+func (fs *propsFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if flag == os.O_RDWR && errors.Is(err, syscall.EISDIR) {
+		// PROPPATCH以读写方式打开资源，目录无法这样打开；此时下层的访问控制已允许写入，改为只读打开
+		f, err = fs.FileSystem.OpenFile(ctx, name, os.O_RDONLY, perm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := fs.key(ctx, name)
+	if err != nil {
+		return f, nil
+	}
+	return &propsFile{File: f, store: fs.store, key: key}, nil
+}
+
+func (fs *propsFS) RemoveAll(ctx context.Context, name string) error {
+	key, keyErr := fs.key(ctx, name)
+	if err := fs.FileSystem.RemoveAll(ctx, name); err != nil {
+		return err
+	}
+	if keyErr == nil {
+		fs.store.removeTree(key)
+	}
+	return nil
+}
+
+func (fs *propsFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldKey, oldErr := fs.key(ctx, oldName)
+	newKey, newErr := fs.key(ctx, newName)
+	if err := fs.FileSystem.Rename(ctx, oldName, newName); err != nil {
+		return err
+	}
+	if oldErr == nil && newErr == nil {
+		fs.store.moveTree(oldKey, newKey)
+	}
+	return nil
+}
+
+// propsFile 实现 webdav.DeadPropsHolder
+type propsFile struct {
+	webdav.File
+	store *propStore
+	key   string
+}
+
+func (f *propsFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	return f.store.get(f.key), nil
+}
+
+func (f *propsFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return f.store.patch(f.key, patches)
+}
+
+// innerDeadProps 返回下层文件的死属性的副本，供在其上提供计算属性的各层添加属性
+func innerDeadProps(f webdav.File) (map[xml.Name]webdav.Property, error) {
+	props := make(map[xml.Name]webdav.Property)
+	if holder, ok := f.(webdav.DeadPropsHolder); ok {
+		m, err := holder.DeadProps()
+		if err != nil {
+			return nil, err
+		}
+		for name, p := range m {
+			props[name] = p
+		}
+	}
+	return props, nil
+}
+
+// patchWithProtected 处理PROPPATCH：请求修改protected的计算属性时整个请求失败，
+// 这些属性返回403，其余属性返回424；否则交给下层文件保存
+func patchWithProtected(f webdav.File, patches []webdav.Proppatch, protected func(xml.Name) bool) ([]webdav.Propstat, error) {
+	forbidden := webdav.Propstat{
+		Status:   http.StatusForbidden,
+		XMLError: `<D:cannot-modify-protected-property xmlns:D="DAV:"/>`,
+	}
+	failed := webdav.Propstat{Status: webdav.StatusFailedDependency}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			if protected(p.XMLName) {
+				forbidden.Props = append(forbidden.Props, webdav.Property{XMLName: p.XMLName})
+			} else {
+				failed.Props = append(failed.Props, webdav.Property{XMLName: p.XMLName})
+			}
+		}
+	}
+	if len(forbidden.Props) > 0 {
+		if len(failed.Props) == 0 {
+			return []webdav.Propstat{forbidden}, nil
+		}
+		return []webdav.Propstat{forbidden, failed}, nil
+	}
+	holder, ok := f.(webdav.DeadPropsHolder)
+	if !ok {
+		failed.Status = http.StatusForbidden
+		return []webdav.Propstat{failed}, nil
+	}
+	return holder.Patch(patches)
+}
+
+// webdavRealPath 将WebDAV请求路径映射为实际的文件路径，启用用户主目录时按请求的用户解析
+var webdavRealPath = webdavDirPath
+
+// webdavDirPath 返回WebDAV根目录中请求路径对应的文件路径
+func webdavDirPath(ctx context.Context, name string) (string, error) {
+	return filepath.Join(webdavDir, filepath.FromSlash(path.Clean("/"+name))), nil
+}
+
+// setupWebDAVProps 打开持久化的属性存储并包装文件系统
+func setupWebDAVProps(fs webdav.FileSystem) webdav.FileSystem {
+	ps, err := newPropStore(dataPath("webdav-props.journal"))
+	if err != nil {
+		log.Fatalf("无法加载WebDAV属性: %v", err)
+	}
+	davProps = ps
+	fmt.Printf("✅ WebDAV属性存储已启用: %s (%d 个资源)\n", ps.journal.path, len(ps.props))
+	return &propsFS{FileSystem: fs, store: ps}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// WebDAV配额配置
+var (
+	webdavQuotaSpec      string
+	webdavUserQuotasSpec string
+	webdavQuota          int64            // 整个WebDAV目录（启用用户主目录时为每个主目录）的配额，0表示不限制
+	webdavUserQuotas     map[string]int64 // 按用户覆盖的配额
+)
+
+// 已用空间缓存的有效期，超过后重新统计以反映在WebDAV之外发生的变化
+const quotaUsageTTL = time.Minute
+
+// errQuotaExceeded 写入超出配额
+var errQuotaExceeded = errors.New("超出存储配额")
+
+// RFC 4331 配额属性
+var (
+	quotaAvailableProp = xml.Name{Space: "DAV:", Local: "quota-available-bytes"}
+	quotaUsedProp      = xml.Name{Space: "DAV:", Local: "quota-used-bytes"}
+)
+
+// parseByteSize 解析 "500MB"、"10G"、"1.5TiB" 等格式的容量，单位按1024进位
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	upper := strings.ToUpper(s)
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	mult := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			mult = u.mult
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, u.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("无效的容量: %s", s)
+	}
+	return int64(n * mult), nil
+}
+
+// formatByteSize 以易读的形式显示容量
+func formatByteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// setupWebDAVQuota 解析配额配置
+func setupWebDAVQuota() {
+	var err error
+	if webdavQuotaSpec != "" {
+		if webdavQuota, err = parseByteSize(webdavQuotaSpec); err != nil {
+			log.Fatalf("-webdav-quota 配置错误: %v", err)
+		}
+	}
+	webdavUserQuotas = make(map[string]int64)
+	for _, item := range strings.Split(webdavUserQuotasSpec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		user, size, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(user) == "" {
+			log.Fatalf("-webdav-user-quotas 格式错误: %s (应为 用户=容量)", item)
+		}
+		n, err := parseByteSize(size)
+		if err != nil {
+			log.Fatalf("-webdav-user-quotas 配置错误: %v", err)
+		}
+		webdavUserQuotas[strings.TrimSpace(user)] = n
+	}
+	if len(webdavUserQuotas) > 0 && !webdavUserHomes {
+		log.Fatal("-webdav-user-quotas 需要同时启用 -webdav-user-homes")
+	}
+
+	switch {
+	case webdavUserHomes && (webdavQuota > 0 || len(webdavUserQuotas) > 0):
+		fmt.Printf("✅ WebDAV用户配额已启用 - 默认: %s, 单独设置: %d 个用户\n", quotaText(webdavQuota), len(webdavUserQuotas))
+	case webdavQuota > 0:
+		fmt.Printf("✅ WebDAV配额已启用: %s\n", formatByteSize(webdavQuota))
+	}
+}
+
+// quotaText 显示配额，0表示不限制
+func quotaText(n int64) string {
+	if n <= 0 {
+		return "不限制"
+	}
+	return formatByteSize(n)
+}
+
+// quotaUsage 缓存各配额根目录的已用空间
+type quotaUsage struct {
+	mu    sync.Mutex
+	roots map[string]*usageEntry
+}
+
+type usageEntry struct {
+	bytes    int64
+	computed time.Time
+}
+
+var davQuotaUsage = &quotaUsage{roots: make(map[string]*usageEntry)}
+
+// get 返回目录的已用空间，缓存过期时重新统计
+func (q *quotaUsage) get(root string) int64 {
+	q.mu.Lock()
+	if e, ok := q.roots[root]; ok && time.Since(e.computed) < quotaUsageTTL {
+		q.mu.Unlock()
+		return e.bytes
+	}
+	q.mu.Unlock()
+
+	n := treeSize(root)
+	q.mu.Lock()
+	q.roots[root] = &usageEntry{bytes: n, computed: time.Now()}
+	q.mu.Unlock()
+	return n
+}
+
+// add 写入文件后调整已用空间
+func (q *quotaUsage) add(root string, delta int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e, ok := q.roots[root]; ok {
+		e.bytes += delta
+		if e.bytes < 0 {
+			e.bytes = 0
+		}
+	}
+}
+
+// invalidate 删除文件后使缓存失效，下次访问时重新统计
+func (q *quotaUsage) invalidate(root string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.roots, root)
+}
+
+// treeSize 统计文件或目录下所有普通文件的大小
+func treeSize(root string) int64 {
+	var total int64
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == trashDirName && filepath.Dir(p) == root {
+			// 回收站中的内容不占用配额
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// quotaRoot 返回资源所属的配额根目录和配额，limit为0表示不限制。
+// 启用用户主目录时配额作用于用户的主目录，共享文件夹不受配额限制
+func quotaRoot(ctx context.Context, name string) (root string, limit int64) {
+	if !webdavUserHomes {
+		root, _ = filepath.Abs(webdavDir)
+		return root, webdavQuota
+	}
+	id := contextIdentity(ctx)
+	real, err := webdavRealPath(ctx, name)
+	if id == nil || err != nil {
+		return "", 0
+	}
+	real, _ = filepath.Abs(real)
+	root, _ = filepath.Abs(filepath.Join(webdavDir, "users", id.Name))
+	if !propPathWithin(real, root) {
+		return "", 0
+	}
+	if n, ok := webdavUserQuotas[id.Name]; ok {
+		return root, n
+	}
+	return root, webdavQuota
+}
+
+// quotaRemaining 返回资源所在位置的剩余配额，limited为false表示不受配额限制
+func quotaRemaining(ctx context.Context, name string) (remaining int64, limited bool) {
+	root, limit := quotaRoot(ctx, name)
+	if limit <= 0 {
+		return 0, false
+	}
+	remaining = limit - davQuotaUsage.get(root)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// quotaState 记录一次请求中是否发生了超出配额的写入，以及下层是否已丢弃未完成的内容
+type quotaState struct {
+	mu        sync.Mutex
+	exceeded  bool
+	discarded bool
+}
+
+type quotaStateKey struct{}
+
+// markQuotaExceeded 标记请求因超出配额而失败
+func markQuotaExceeded(ctx context.Context) {
+	if st, ok := ctx.Value(quotaStateKey{}).(*quotaState); ok {
+		st.mu.Lock()
+		st.exceeded = true
+		st.mu.Unlock()
+	}
+}
+
+// discardOnQuotaExceeded 供先将内容写入临时文件的下层在关闭文件时调用：请求超出配额时返回true，
+// 下层应丢弃临时文件并保留原文件，quotaFile随后不再删除该文件
+func discardOnQuotaExceeded(ctx context.Context) bool {
+	st, ok := ctx.Value(quotaStateKey{}).(*quotaState)
+	if !ok {
+		return false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.exceeded {
+		st.discarded = true
+	}
+	return st.exceeded
+}
+
+// quotaWriteDiscarded 判断下层是否已丢弃超出配额的写入内容
+func quotaWriteDiscarded(ctx context.Context) bool {
+	st, ok := ctx.Value(quotaStateKey{}).(*quotaState)
+	if !ok {
+		return false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.discarded
+}
+
+// quotaFS 为目录提供RFC 4331配额属性，并在写入文件时执行配额限制
+type quotaFS struct {
+	webdav.FileSystem
+}
+
+func (fs *quotaFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	root, limit := "", int64(0)
+	var oldSize int64
+	writing := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC) != 0
+	if writing {
+		root, limit = quotaRoot(ctx, name)
+		if fi, err := fs.FileSystem.Stat(ctx, name); err == nil && !fi.IsDir() && flag&os.O_TRUNC != 0 {
+			oldSize = fi.Size()
+		}
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	qf := &quotaFile{File: f, fs: fs, ctx: ctx, name: name, root: root, limit: limit}
+	if writing && root != "" {
+		// 截断后原有内容不再占用配额
+		davQuotaUsage.add(root, -oldSize)
+		if limit > 0 {
+			qf.remaining = limit - davQuotaUsage.get(root)
+		}
+	}
+	return qf, nil
+}
+
+func (fs *quotaFS) RemoveAll(ctx context.Context, name string) error {
+	root, _ := quotaRoot(ctx, name)
+	err := fs.FileSystem.RemoveAll(ctx, name)
+	if root != "" {
+		davQuotaUsage.invalidate(root)
+	}
+	return err
+}
+
+// quotaFile 统计写入的字节数，超出剩余配额时拒绝写入并在关闭时删除不完整的文件
+type quotaFile struct {
+	webdav.File
+	fs        *quotaFS
+	ctx       context.Context
+	name      string
+	root      string
+	limit     int64
+	remaining int64
+	written   int64
+	exceeded  bool
+}
+
+func (f *quotaFile) Write(p []byte) (int, error) {
+	if f.limit > 0 && f.written+int64(len(p)) > f.remaining {
+		f.exceeded = true
+		markQuotaExceeded(f.ctx)
+		return 0, errQuotaExceeded
+	}
+	n, err := f.File.Write(p)
+	f.written += int64(n)
+	if f.root != "" {
+		davQuotaUsage.add(f.root, int64(n))
+	}
+	return n, err
+}
+
+func (f *quotaFile) Close() error {
+	err := f.File.Close()
+	if f.exceeded {
+		// 下层已丢弃临时文件时原文件保持不变，否则删除不完整的文件
+		removed := quotaWriteDiscarded(f.ctx)
+		if !removed {
+			removed = f.fs.FileSystem.RemoveAll(f.ctx, f.name) == nil
+		}
+		if removed && f.root != "" {
+			davQuotaUsage.add(f.root, -f.written)
+		}
+		log.Printf("WebDAV写入超出配额: %s (配额 %s)", f.name, formatByteSize(f.limit))
+	}
+	return err
+}
+
+// DeadProps 在底层属性之外为目录提供只读的配额属性
+func (f *quotaFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props, err := innerDeadProps(f.File)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.File.Stat(); err == nil && fi.IsDir() {
+		if used, available, ok := quotaUsedAvailable(f.ctx, f.name); ok {
+			props[quotaUsedProp] = webdav.Property{XMLName: quotaUsedProp, InnerXML: []byte(strconv.FormatInt(used, 10))}
+			props[quotaAvailableProp] = webdav.Property{XMLName: quotaAvailableProp, InnerXML: []byte(strconv.FormatInt(available, 10))}
+		}
+	}
+	return props, nil
+}
+
+// Patch 拒绝修改配额属性，其余属性交给底层保存
+func (f *quotaFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return patchWithProtected(f.File, patches, func(name xml.Name) bool {
+		return name == quotaUsedProp || name == quotaAvailableProp
+	})
+}
+
+// quotaUsedAvailable 计算配额属性：配置了配额时按配额计算，否则报告文件系统的使用情况
+func quotaUsedAvailable(ctx context.Context, name string) (used, available int64, ok bool) {
+	real, err := webdavRealPath(ctx, name)
+	if err != nil {
+		return 0, 0, false
+	}
+	free, fsUsed, err := diskSpace(real)
+	if err != nil {
+		return 0, 0, false
+	}
+	root, limit := quotaRoot(ctx, name)
+	if limit <= 0 {
+		return int64(fsUsed), int64(free), true
+	}
+	used = davQuotaUsage.get(root)
+	available = limit - used
+	if available < 0 {
+		available = 0
+	}
+	if uint64(available) > free {
+		available = int64(free)
+	}
+	return used, available, true
+}
+
+// quotaResponseWriter 在写入因超出配额失败时将错误响应替换为507
+type quotaResponseWriter struct {
+	http.ResponseWriter
+	state      *quotaState
+	suppressed bool
+}
+
+func (w *quotaResponseWriter) WriteHeader(code int) {
+	w.state.mu.Lock()
+	exceeded := w.state.exceeded
+	w.state.mu.Unlock()
+	if exceeded && code >= 400 {
+		w.ResponseWriter.Header().Del("Content-Length")
+		w.ResponseWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.ResponseWriter.WriteHeader(http.StatusInsufficientStorage)
+		w.ResponseWriter.Write([]byte("超出存储配额\n"))
+		w.suppressed = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *quotaResponseWriter) Write(p []byte) (int, error) {
+	if w.suppressed {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// webdavQuotaMiddleware 在写入前检查PUT的Content-Length和COPY的源大小，
+// 超出配额时直接返回507；写入过程中超出配额（例如分块上传）时同样返回507
+func webdavQuotaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != "COPY" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		name := strings.TrimPrefix(r.URL.Path, "/webdav")
+		var need int64
+		target := name
+		switch r.Method {
+		case http.MethodPut:
+			if webdavAppleFiles == appleFilesDiscard && appleJunkName(path.Base(name)) && r.ContentLength > appleFilesMaxBytes {
+				http.Error(w, fmt.Sprintf("Finder文件超出大小限制 %s", formatByteSize(appleFilesMaxBytes)), http.StatusInsufficientStorage)
+				return
+			}
+			need = r.ContentLength
+			if fi, err := webdavFS.Stat(ctx, name); err == nil && !fi.IsDir() {
+				need -= fi.Size()
+			}
+		case "COPY":
+			if u, err := url.Parse(r.Header.Get("Destination")); err == nil {
+				target = strings.TrimPrefix(u.Path, "/webdav")
+			}
+			if real, err := webdavRealPath(ctx, name); err == nil {
+				need = treeSize(real)
+			}
+			if real, err := webdavRealPath(ctx, target); err == nil && r.Header.Get("Overwrite") != "F" {
+				need -= treeSize(real)
+			}
+		}
+		if remaining, limited := quotaRemaining(ctx, path.Clean("/"+target)); limited && need > remaining {
+			http.Error(w, fmt.Sprintf("超出存储配额: 需要 %s，剩余 %s", formatByteSize(need), formatByteSize(remaining)),
+				http.StatusInsufficientStorage)
+			return
+		}
+
+		st := &quotaState{}
+		r = r.WithContext(context.WithValue(ctx, quotaStateKey{}, st))
+		next.ServeHTTP(&quotaResponseWriter{ResponseWriter: w, state: st}, r)
+	})
+}
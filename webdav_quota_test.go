@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"100B", 100, false},
+		{"1K", 1 << 10, false},
+		{"1kb", 1 << 10, false},
+		{"500MB", 500 << 20, false},
+		{"10G", 10 << 30, false},
+		{"2GiB", 2 << 30, false},
+		{"1.5TiB", 3 << 39, false},
+		{" 1.5 GB ", 3 << 29, false},
+		{"", 0, true},
+		{"GB", 0, true},
+		{"-1G", 0, true},
+		{"10X", 0, true},
+		{"1,5G", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, 期望 %d, 出错 %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{500 << 20, "500.0 MB"},
+		{3 << 39, "1.5 TB"},
+	}
+	for _, tt := range tests {
+		if got := formatByteSize(tt.in); got != tt.want {
+			t.Errorf("formatByteSize(%d) = %q, 期望 %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestQuotaAccounting(t *testing.T) {
+	dir := t.TempDir()
+	oldDir, oldQuota := webdavDir, webdavQuota
+	webdavDir, webdavQuota = dir, 100
+	davQuotaUsage = &quotaUsage{roots: make(map[string]*usageEntry)}
+	defer func() {
+		webdavDir, webdavQuota = oldDir, oldQuota
+		davQuotaUsage = &quotaUsage{roots: make(map[string]*usageEntry)}
+	}()
+	// 回收站中的内容不占用配额
+	if err := os.MkdirAll(filepath.Join(dir, trashDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, trashDirName, "old"), make([]byte, 500), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &quotaFS{FileSystem: webdav.Dir(dir)}
+	root, _ := filepath.Abs(dir)
+	put := func(name string, size int) error {
+		ctx := context.WithValue(context.Background(), quotaStateKey{}, &quotaState{})
+		f, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		_, werr := f.Write([]byte(strings.Repeat("x", size)))
+		if err := f.Close(); werr == nil {
+			werr = err
+		}
+		return werr
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		size    int
+		wantErr bool
+		used    int64
+		exists  bool
+	}{
+		{"配额内写入", "/a.txt", 60, false, 60, true},
+		{"超出剩余配额", "/b.txt", 41, true, 60, false},
+		{"恰好用完配额", "/b.txt", 40, false, 100, true},
+		{"覆盖时原内容不计入", "/a.txt", 50, false, 90, true},
+		{"覆盖时超出配额，没有暂存时删除不完整的文件", "/a.txt", 70, true, 40, false},
+		{"空文件", "/c.txt", 0, false, 40, true},
+	}
+	for _, tt := range tests {
+		err := put(tt.file, tt.size)
+		if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, errQuotaExceeded)) {
+			t.Errorf("%s: 错误 = %v, 期望出错 %v", tt.name, err, tt.wantErr)
+		}
+		if used := davQuotaUsage.get(root); used != tt.used {
+			t.Errorf("%s: 已用空间 = %d, 期望 %d", tt.name, used, tt.used)
+		}
+		if used := treeSize(root); used != tt.used {
+			t.Errorf("%s: 实际占用 = %d, 期望 %d", tt.name, used, tt.used)
+		}
+		if _, err := os.Stat(filepath.Join(dir, tt.file)); (err == nil) != tt.exists {
+			t.Errorf("%s: 文件存在 = %v, 期望 %v", tt.name, err == nil, tt.exists)
+		}
+	}
+
+	if remaining, limited := quotaRemaining(context.Background(), "/"); !limited || remaining != 60 {
+		t.Errorf("剩余配额 = %d, %v, 期望 60, true", remaining, limited)
+	}
+	if err := fs.RemoveAll(context.Background(), "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if used := davQuotaUsage.get(root); used != 0 {
+		t.Errorf("删除后已用空间 = %d, 期望 0", used)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// REPORT请求体的大小上限
+const davReportMaxBytes = 1 << 20
+
+// davReportHandler 处理一种REPORT（RFC 3253），body 为完整的请求体
+type davReportHandler func(rs *davReportSet, w http.ResponseWriter, r *http.Request, body []byte)
+
+// davReportSet 一个WebDAV处理器支持的REPORT，按请求体根元素的名称分派
+type davReportSet struct {
+	prefix  string
+	fs      webdav.FileSystem
+	handler http.Handler // 生成资源属性的webdav处理器
+	reports map[xml.Name]davReportHandler
+}
+
+// newDAVReportSet 创建挂载在 prefix 下的REPORT集合
+func newDAVReportSet(prefix string) *davReportSet {
+	return &davReportSet{prefix: prefix, reports: make(map[xml.Name]davReportHandler)}
+}
+
+// webdavReports /webdav 支持的REPORT
+var webdavReports = newDAVReportSet("/webdav")
+
+// supportedReportSetProp 集合的 DAV:supported-report-set 属性
+var supportedReportSetProp = xml.Name{Space: "DAV:", Local: "supported-report-set"}
+
+// name 返回请求路径在文件系统中的名称
+func (rs *davReportSet) name(urlPath string) string {
+	return path.Clean("/" + strings.TrimPrefix(urlPath, rs.prefix))
+}
+
+// supportedReportSet 返回 DAV:supported-report-set 属性的内容
+func (rs *davReportSet) supportedReportSet() []byte {
+	names := make([]xml.Name, 0, len(rs.reports))
+	for name := range rs.reports {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return names[i].Space < names[j].Space
+		}
+		return names[i].Local < names[j].Local
+	})
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, `<supported-report><report><%s xmlns="%s"/></report></supported-report>`, name.Local, name.Space)
+	}
+	return buf.Bytes()
+}
+
+// middleware 处理REPORT请求，并在集合的OPTIONS响应中声明REPORT方法
+func (rs *davReportSet) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "REPORT":
+			rs.serve(w, r)
+		case "OPTIONS":
+			fi, err := rs.fs.Stat(r.Context(), rs.name(r.URL.Path))
+			if err != nil || !fi.IsDir() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rw := &davHeaderWriter{ResponseWriter: w, fix: func(h http.Header) {
+				if allow := h.Get("Allow"); allow != "" {
+					h.Set("Allow", allow+", REPORT")
+				}
+			}}
+			next.ServeHTTP(rw, r)
+			rw.apply()
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// serve 读取REPORT请求体并交给对应的处理器
+func (rs *davReportSet) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, davReportMaxBytes+1))
+	if err != nil {
+		http.Error(w, "无法读取请求", http.StatusBadRequest)
+		return
+	}
+	if len(body) > davReportMaxBytes {
+		http.Error(w, "请求体过大", http.StatusRequestEntityTooLarge)
+		return
+	}
+	root, err := xmlRootName(body)
+	if err != nil {
+		http.Error(w, "无效的REPORT请求", http.StatusBadRequest)
+		return
+	}
+	handler, ok := rs.reports[root]
+	if !ok {
+		writeDAVError(w, http.StatusForbidden, "supported-report", "")
+		return
+	}
+	handler(rs, w, r, body)
+}
+
+// xmlRootName 返回XML文档根元素的名称
+func xmlRootName(body []byte) (xml.Name, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.Name{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name, nil
+		}
+	}
+}
+
+// writeDAVError 返回带有前置条件或后置条件元素的 DAV:error 响应（RFC 4918 16节）
+func writeDAVError(w http.ResponseWriter, status int, condition, namespace string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	if namespace == "" || namespace == "DAV:" {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<D:error xmlns:D="DAV:"><D:%s/></D:error>`, condition)
+		return
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<D:error xmlns:D="DAV:"><%s xmlns="%s"/></D:error>`, condition, namespace)
+}
+
+// davHeaderWriter 在响应头发送前调整webdav处理器设置的响应头
+type davHeaderWriter struct {
+	http.ResponseWriter
+	fix     func(h http.Header)
+	applied bool
+}
+
+func (w *davHeaderWriter) apply() {
+	if w.applied {
+		return
+	}
+	w.applied = true
+	w.fix(w.ResponseWriter.Header())
+}
+
+func (w *davHeaderWriter) WriteHeader(code int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *davHeaderWriter) Write(p []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(p)
+}
+
+// davPropNames 解析请求体中 DAV:prop 元素列出的属性名
+func davPropNames(body []byte) ([]xml.Name, error) {
+	var req struct {
+		Prop *struct {
+			Names []struct {
+				XMLName xml.Name
+			} `xml:",any"`
+		} `xml:"DAV: prop"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if req.Prop == nil {
+		return nil, nil
+	}
+	names := make([]xml.Name, len(req.Prop.Names))
+	for i, n := range req.Prop.Names {
+		names[i] = n.XMLName
+	}
+	return names, nil
+}
+
+// davResponseBuffer 缓存内部请求的响应
+type davResponseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *davResponseBuffer) Header() http.Header { return b.header }
+
+func (b *davResponseBuffer) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *davResponseBuffer) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// propResponse 通过内部的Depth: 0 PROPFIND请求生成资源的 DAV:response 元素，
+// 使REPORT返回的属性与PROPFIND完全一致（包括死属性、配额等属性和访问控制）；
+// 资源不存在时ok为false
+func (rs *davReportSet) propResponse(r *http.Request, urlPath string, props []xml.Name) (response []byte, ok bool) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="UTF-8"?><propfind xmlns="DAV:"><prop>`)
+	for _, name := range props {
+		fmt.Fprintf(&body, `<%s xmlns="%s"/>`, name.Local, name.Space)
+	}
+	body.WriteString(`</prop></propfind>`)
+
+	sub := r.Clone(r.Context())
+	sub.Method = "PROPFIND"
+	sub.URL = &url.URL{Path: urlPath}
+	sub.RequestURI = sub.URL.RequestURI()
+	sub.Header.Set("Depth", "0")
+	sub.Header.Del("If")
+	sub.Body = io.NopCloser(bytes.NewReader(body.Bytes()))
+	sub.ContentLength = int64(body.Len())
+
+	rec := &davResponseBuffer{header: make(http.Header)}
+	rs.handler.ServeHTTP(rec, sub)
+	if rec.status != webdav.StatusMulti {
+		return nil, false
+	}
+	// webdav处理器以D为DAV:命名空间的前缀，在根元素上声明
+	data := rec.body.Bytes()
+	start := bytes.Index(data, []byte("<D:response>"))
+	end := bytes.LastIndex(data, []byte("</D:response>"))
+	if start < 0 || end < start {
+		return nil, false
+	}
+	return data[start : end+len("</D:response>")], true
+}
+
+// writeMultistatus 写入由 DAV:response 元素组成的多状态响应
+func writeMultistatus(w http.ResponseWriter, responses []byte) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(webdav.StatusMulti)
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<D:multistatus xmlns:D="DAV:">`)
+	w.Write(responses)
+	io.WriteString(w, `</D:multistatus>`)
+}
+
+// davStatusResponse 返回只包含状态的 DAV:response 元素
+func davStatusResponse(urlPath string, status int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<D:response><D:href>")
+	xml.EscapeText(&buf, []byte((&url.URL{Path: urlPath}).EscapedPath()))
+	fmt.Fprintf(&buf, "</D:href><D:status>HTTP/1.1 %d %s</D:status></D:response>", status, http.StatusText(status))
+	return buf.Bytes()
+}
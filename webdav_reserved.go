@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/net/webdav"
+)
+
+// webdavReservedDirs 不能通过WebDAV访问的目录（绝对路径）。默认的状态数据目录 .sweb 位于默认的WebDAV目录中，
+// 其中的令牌、密钥和双因素认证数据被客户端读取或修改会危及整个服务
+var webdavReservedDirs []string
+
+// reserveWebDAVDir 禁止通过WebDAV访问目录dir，dir位于WebDAV目录或共享文件夹中时输出警告
+func reserveWebDAVDir(dir, desc string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatalf("无法解析%s %s: %v", desc, dir, err)
+	}
+	webdavReservedDirs = append(webdavReservedDirs, abs)
+	roots := []string{webdavDir}
+	if webdavHomes != nil {
+		for _, shared := range webdavHomes.shared {
+			roots = append(roots, shared)
+		}
+	}
+	for _, root := range roots {
+		if r, err := filepath.Abs(root); err == nil && propPathWithin(abs, r) {
+			fmt.Printf("⚠️ %s %s 位于WebDAV目录 %s 中，已禁止通过WebDAV访问；建议将其移到WebDAV目录之外\n", desc, dir, root)
+		}
+	}
+}
+
+// refuseInStaticDir 在目录位于静态文件目录中时拒绝启动：静态文件服务会公开其中的所有文件
+func refuseInStaticDir(dir, desc, webDir, flagName string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	if root, err := filepath.Abs(webDir); err == nil && propPathWithin(abs, root) {
+		log.Fatalf("%s %s 位于静态文件目录 %s 中，其中的文件会被公开，请使用 %s 指定其他目录", desc, dir, webDir, flagName)
+	}
+}
+
+// webdavReservedPath 判断磁盘路径（绝对路径）是否位于禁止通过WebDAV访问的目录中
+func webdavReservedPath(key string) bool {
+	for _, dir := range webdavReservedDirs {
+		if propPathWithin(key, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedFS 对WebDAV客户端隐藏 webdavReservedDirs 中的目录：不能列出、读取、写入、移动或删除，
+// 也不能删除或移动包含它们的上级目录。该层位于回收站和历史版本层之上，因为这些层直接操作磁盘
+type reservedFS struct {
+	webdav.FileSystem
+}
+
+// reserved 判断WebDAV路径是否指向保留目录或其中的文件
+func (fs *reservedFS) reserved(ctx context.Context, name string) bool {
+	key, err := webdavAbsPath(ctx, name)
+	return err == nil && webdavReservedPath(key)
+}
+
+// covers 判断WebDAV路径是否指向保留目录、其中的文件或包含保留目录的上级目录
+func (fs *reservedFS) covers(ctx context.Context, name string) bool {
+	key, err := webdavAbsPath(ctx, name)
+	if err != nil {
+		return false
+	}
+	for _, dir := range webdavReservedDirs {
+		if propPathWithin(key, dir) || propPathWithin(dir, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *reservedFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if fs.reserved(ctx, name) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *reservedFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	key, keyErr := webdavAbsPath(ctx, name)
+	if keyErr == nil && webdavReservedPath(key) {
+		return nil, os.ErrNotExist
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if keyErr == nil {
+		for _, dir := range webdavReservedDirs {
+			if filepath.Dir(dir) == key {
+				return &reservedHidingFile{File: f, dir: key}, nil
+			}
+		}
+	}
+	return f, nil
+}
+
+func (fs *reservedFS) RemoveAll(ctx context.Context, name string) error {
+	if fs.covers(ctx, name) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fs *reservedFS) Rename(ctx context.Context, oldName, newName string) error {
+	if fs.covers(ctx, oldName) || fs.covers(ctx, newName) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs *reservedFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if fs.reserved(ctx, name) {
+		return nil, os.ErrNotExist
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+// reservedHidingFile 在保留目录的上级目录的列表中隐藏保留目录
+type reservedHidingFile struct {
+	webdav.File
+	dir string
+}
+
+func (f *reservedHidingFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	result := infos[:0]
+	for _, fi := range infos {
+		if !webdavReservedPath(filepath.Join(f.dir, fi.Name())) {
+			result = append(result, fi)
+		}
+	}
+	return result, err
+}
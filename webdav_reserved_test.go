@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestReservedFS(t *testing.T) {
+	root := t.TempDir()
+	oldDir, oldReserved := webdavDir, webdavReservedDirs
+	webdavDir, webdavReservedDirs = root, nil
+	defer func() { webdavDir, webdavReservedDirs = oldDir, oldReserved }()
+	for _, dir := range []string{".sweb", "docs"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(root, ".sweb", "api-tokens.json"), []byte("{}"), 0600)
+	os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("a"), 0644)
+	reserveWebDAVDir(filepath.Join(root, ".sweb"), "状态数据目录")
+
+	fs := &reservedFS{FileSystem: webdav.Dir(root)}
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		op   func() error
+		want error // nil表示操作应成功
+	}{
+		{"Stat保留目录", func() error { _, err := fs.Stat(ctx, "/.sweb"); return err }, os.ErrNotExist},
+		{"Stat保留文件", func() error { _, err := fs.Stat(ctx, "/.sweb/api-tokens.json"); return err }, os.ErrNotExist},
+		{"读取保留文件", func() error { _, err := fs.OpenFile(ctx, "/.sweb/api-tokens.json", os.O_RDONLY, 0); return err }, os.ErrNotExist},
+		{"写入保留文件", func() error {
+			_, err := fs.OpenFile(ctx, "/.sweb/api-tokens.json", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			return err
+		}, os.ErrNotExist},
+		{"在保留目录中创建目录", func() error { return fs.Mkdir(ctx, "/.sweb/x", 0755) }, os.ErrPermission},
+		{"删除保留目录", func() error { return fs.RemoveAll(ctx, "/.sweb") }, os.ErrPermission},
+		{"删除包含保留目录的根目录", func() error { return fs.RemoveAll(ctx, "/") }, os.ErrPermission},
+		{"移出保留目录", func() error { return fs.Rename(ctx, "/.sweb/api-tokens.json", "/x.json") }, os.ErrPermission},
+		{"移入保留目录", func() error { return fs.Rename(ctx, "/docs/a.txt", "/.sweb/a.txt") }, os.ErrPermission},
+		{"普通文件", func() error { _, err := fs.Stat(ctx, "/docs/a.txt"); return err }, nil},
+		{"移动普通文件", func() error { return fs.Rename(ctx, "/docs/a.txt", "/docs/b.txt") }, nil},
+	}
+	for _, tt := range tests {
+		err := tt.op()
+		if (tt.want == nil) != (err == nil) || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: err = %v, 期望 %v", tt.name, err, tt.want)
+		}
+	}
+
+	f, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range infos {
+		if fi.Name() == ".sweb" {
+			t.Error("根目录列表中不应包含保留目录")
+		}
+	}
+	if len(infos) != 1 {
+		t.Errorf("根目录列表 = %d 项，期望 1 项", len(infos))
+	}
+	if _, err := os.Stat(filepath.Join(root, ".sweb", "api-tokens.json")); err != nil {
+		t.Errorf("保留文件被修改: %v", err)
+	}
+}
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 一次搜索最多返回的结果数
+const searchMaxResults = 1000
+
+// searchItem 搜索的候选资源，来自同步索引
+type searchItem struct {
+	href string // URL路径，目录以 / 结尾
+	name string
+	size int64
+	mod  time.Time
+	dir  bool
+}
+
+// contentType 按扩展名返回文件的内容类型，目录没有内容类型
+func (it *searchItem) contentType() string {
+	if it.dir {
+		return ""
+	}
+	if t := mime.TypeByExtension(path.Ext(it.name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// searchPredicate 搜索条件
+type searchPredicate func(it *searchItem) bool
+
+// searchOrder 结果的一个排序键
+type searchOrder struct {
+	prop string
+	desc bool
+}
+
+// searchProps 可用于查询条件和排序的属性（均为 DAV: 命名空间）
+var searchProps = map[string]bool{
+	"displayname":      true,
+	"getcontentlength": true,
+	"getlastmodified":  true,
+	"getcontenttype":   true,
+}
+
+// searchCandidates 从同步索引中取出范围内的资源：depth 为 "0" 时只有范围本身，
+// "1" 时为直接成员，否则为所有下级成员。启用用户主目录时，主目录根的范围包括共享文件夹
+func searchCandidates(r *http.Request, urlPath, depth string) ([]searchItem, error) {
+	ctx := r.Context()
+	name := path.Clean("/" + strings.TrimPrefix(urlPath, "/webdav"))
+	fi, err := webdavFS.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	base := path.Join("/webdav", name)
+	statItem := func(href string, fi os.FileInfo) searchItem {
+		it := searchItem{href: href, name: fi.Name(), mod: fi.ModTime(), dir: fi.IsDir()}
+		if it.dir {
+			it.href += "/"
+		} else {
+			it.size = fi.Size()
+		}
+		return it
+	}
+	if depth == "0" || !fi.IsDir() {
+		return []searchItem{statItem(base, fi)}, nil
+	}
+	infinite := depth != "1"
+
+	dir, err := webdavAbsPath(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	type scopeRoot struct{ href, dir string }
+	roots := []scopeRoot{{base, dir}}
+	var items []searchItem
+	sharedRoot := webdavHomes != nil && name == "/"
+	if sharedRoot {
+		names := make([]string, 0, len(webdavHomes.shared))
+		for sname := range webdavHomes.shared {
+			names = append(names, sname)
+		}
+		sort.Strings(names)
+		for _, sname := range names {
+			mfi, err := webdavFS.Stat(ctx, "/"+sname)
+			if err != nil {
+				continue
+			}
+			items = append(items, statItem(path.Join(base, sname), mfi))
+			if abs, err := filepath.Abs(webdavHomes.shared[sname]); err == nil && infinite {
+				roots = append(roots, scopeRoot{path.Join(base, sname), abs})
+			}
+		}
+	}
+	for i, root := range roots {
+		for _, c := range davSync.members(root.dir, infinite) {
+			rel, err := filepath.Rel(root.dir, c.key)
+			if err != nil {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+			if first, _, _ := strings.Cut(rel, "/"); i == 0 && sharedRoot && webdavHomes.shared[first] != "" {
+				// 主目录中与共享文件夹同名的条目被共享文件夹覆盖
+				continue
+			}
+			it := searchItem{
+				href: path.Join(root.href, rel),
+				name: path.Base(rel),
+				size: c.entry.Size,
+				mod:  time.Unix(0, c.entry.ModTime),
+				dir:  c.entry.Dir,
+			}
+			if it.dir {
+				it.href, it.size = it.href+"/", 0
+			}
+			items = append(items, it)
+		}
+	}
+	return items, nil
+}
+
+// runSearch 在范围内查找满足条件的资源并排序；结果超过 limit 时截断并返回 truncated
+func runSearch(r *http.Request, urlPath, depth string, pred searchPredicate, orders []searchOrder, limit int) (result []searchItem, truncated bool, err error) {
+	items, err := searchCandidates(r, urlPath, depth)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := range items {
+		if (pred == nil || pred(&items[i])) && aclAllowed(r, items[i].href, aclRead) {
+			result = append(result, items[i])
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		for _, o := range orders {
+			c := searchCompare(&result[i], &result[j], o.prop)
+			if o.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return result[i].href < result[j].href
+	})
+	if limit <= 0 || limit > searchMaxResults {
+		limit = searchMaxResults
+	}
+	if len(result) > limit {
+		return result[:limit], true, nil
+	}
+	return result, false, nil
+}
+
+// searchCompare 按属性比较两个资源
+func searchCompare(a, b *searchItem, prop string) int {
+	switch prop {
+	case "getcontentlength":
+		return cmp.Compare(a.size, b.size)
+	case "getlastmodified":
+		return a.mod.Compare(b.mod)
+	case "getcontenttype":
+		return strings.Compare(a.contentType(), b.contentType())
+	default:
+		return strings.Compare(strings.ToLower(a.name), strings.ToLower(b.name))
+	}
+}
+
+// parseSearchTime 解析查询中的时间，支持HTTP日期、RFC 3339 和 YYYY-MM-DD
+func parseSearchTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := http.ParseTime(s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// searchExpr basicsearch 的 DAV:where 中的一个条件（RFC 5323 5.5节）
+type searchExpr struct {
+	XMLName  xml.Name
+	Caseless string `xml:"caseless,attr"`
+	Prop     *struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+	Literal  *string      `xml:"DAV: literal"`
+	Children []searchExpr `xml:",any"`
+}
+
+// prop 返回条件中的属性名
+func (e *searchExpr) prop() (string, error) {
+	if e.Prop == nil || len(e.Prop.Names) != 1 {
+		return "", fmt.Errorf("%s 需要一个属性", e.XMLName.Local)
+	}
+	name := e.Prop.Names[0].XMLName
+	if name.Space != "DAV:" || !searchProps[name.Local] {
+		return "", fmt.Errorf("不支持搜索属性 %s", name.Local)
+	}
+	return name.Local, nil
+}
+
+// literal 返回条件中的比较值
+func (e *searchExpr) literal() (string, error) {
+	if e.Literal == nil {
+		return "", fmt.Errorf("%s 需要 DAV:literal", e.XMLName.Local)
+	}
+	return *e.Literal, nil
+}
+
+// compile 将条件转换为判断函数，不支持的条件返回错误
+func (e *searchExpr) compile() (searchPredicate, error) {
+	if e.XMLName.Space != "DAV:" {
+		return nil, fmt.Errorf("不支持的条件 %s", e.XMLName.Local)
+	}
+	switch op := e.XMLName.Local; op {
+	case "and", "or":
+		if len(e.Children) == 0 {
+			return nil, fmt.Errorf("%s 需要至少一个条件", op)
+		}
+		preds := make([]searchPredicate, len(e.Children))
+		for i := range e.Children {
+			p, err := e.Children[i].compile()
+			if err != nil {
+				return nil, err
+			}
+			preds[i] = p
+		}
+		return func(it *searchItem) bool {
+			for _, p := range preds {
+				if p(it) == (op == "or") {
+					return op == "or"
+				}
+			}
+			return op == "and"
+		}, nil
+
+	case "not":
+		if len(e.Children) != 1 {
+			return nil, fmt.Errorf("not 需要一个条件")
+		}
+		p, err := e.Children[0].compile()
+		if err != nil {
+			return nil, err
+		}
+		return func(it *searchItem) bool { return !p(it) }, nil
+
+	case "is-collection":
+		return func(it *searchItem) bool { return it.dir }, nil
+
+	case "is-defined":
+		prop, err := e.prop()
+		if err != nil {
+			return nil, err
+		}
+		return func(it *searchItem) bool {
+			return !it.dir || (prop != "getcontentlength" && prop != "getcontenttype")
+		}, nil
+
+	case "eq", "lt", "gt", "lte", "gte":
+		prop, err := e.prop()
+		if err != nil {
+			return nil, err
+		}
+		lit, err := e.literal()
+		if err != nil {
+			return nil, err
+		}
+		match := func(c int) bool {
+			switch op {
+			case "eq":
+				return c == 0
+			case "lt":
+				return c < 0
+			case "gt":
+				return c > 0
+			case "lte":
+				return c <= 0
+			}
+			return c >= 0
+		}
+		switch prop {
+		case "getcontentlength":
+			n, err := strconv.ParseInt(strings.TrimSpace(lit), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("无效的大小: %s", lit)
+			}
+			return func(it *searchItem) bool { return !it.dir && match(cmp.Compare(it.size, n)) }, nil
+		case "getlastmodified":
+			t, err := parseSearchTime(lit)
+			if err != nil {
+				return nil, fmt.Errorf("无效的时间: %s", lit)
+			}
+			return func(it *searchItem) bool { return match(it.mod.Compare(t)) }, nil
+		}
+		caseless := e.Caseless != "no"
+		if caseless {
+			lit = strings.ToLower(lit)
+		}
+		return func(it *searchItem) bool {
+			v := searchString(it, prop)
+			if it.dir && prop == "getcontenttype" {
+				return false
+			}
+			if caseless {
+				v = strings.ToLower(v)
+			}
+			return match(strings.Compare(v, lit))
+		}, nil
+
+	case "like":
+		prop, err := e.prop()
+		if err != nil {
+			return nil, err
+		}
+		if prop != "displayname" && prop != "getcontenttype" {
+			return nil, fmt.Errorf("like 不支持属性 %s", prop)
+		}
+		lit, err := e.literal()
+		if err != nil {
+			return nil, err
+		}
+		re, err := likePattern(lit, e.Caseless != "no")
+		if err != nil {
+			return nil, err
+		}
+		return func(it *searchItem) bool {
+			return !(it.dir && prop == "getcontenttype") && re.MatchString(searchString(it, prop))
+		}, nil
+	}
+	return nil, fmt.Errorf("不支持的条件 %s", e.XMLName.Local)
+}
+
+// searchString 返回资源的字符串属性
+func searchString(it *searchItem, prop string) string {
+	if prop == "getcontenttype" {
+		return it.contentType()
+	}
+	return it.name
+}
+
+// likePattern 将 like 的模式转换为正则表达式：% 匹配任意字符串，_ 匹配单个字符，\ 转义
+func likePattern(pattern string, caseless bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if caseless {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("无效的like模式: %s", pattern)
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// searchRequest SEARCH请求（RFC 5323），只支持 DAV:basicsearch
+type searchRequest struct {
+	Basic *struct {
+		Select struct {
+			Prop *struct {
+				Names []struct {
+					XMLName xml.Name
+				} `xml:",any"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: select"`
+		From struct {
+			Scopes []struct {
+				Href  string `xml:"DAV: href"`
+				Depth string `xml:"DAV: depth"`
+			} `xml:"DAV: scope"`
+		} `xml:"DAV: from"`
+		Where *struct {
+			Exprs []searchExpr `xml:",any"`
+		} `xml:"DAV: where"`
+		OrderBy struct {
+			Orders []struct {
+				Prop struct {
+					Names []struct {
+						XMLName xml.Name
+					} `xml:",any"`
+				} `xml:"DAV: prop"`
+				Descending *struct{} `xml:"DAV: descending"`
+			} `xml:"DAV: order"`
+		} `xml:"DAV: orderby"`
+		Limit *struct {
+			NResults string `xml:"DAV: nresults"`
+		} `xml:"DAV: limit"`
+	} `xml:"DAV: basicsearch"`
+}
+
+// searchDefaultProps 请求未选择属性（或使用 DAV:allprop）时返回的属性
+var searchDefaultProps = []xml.Name{
+	{Space: "DAV:", Local: "displayname"},
+	{Space: "DAV:", Local: "resourcetype"},
+	{Space: "DAV:", Local: "getcontentlength"},
+	{Space: "DAV:", Local: "getcontenttype"},
+	{Space: "DAV:", Local: "getlastmodified"},
+	{Space: "DAV:", Local: "getetag"},
+}
+
+// webdavSearchMiddleware 处理SEARCH请求，并在OPTIONS响应中通过DASL头声明支持的查询语法
+func webdavSearchMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "SEARCH":
+			serveSearch(w, r)
+		case "OPTIONS":
+			rw := &davHeaderWriter{ResponseWriter: w, fix: func(h http.Header) {
+				h.Set("DASL", "<DAV:basicsearch>")
+				if allow := h.Get("Allow"); strings.Contains(allow, "PROPFIND") {
+					h.Set("Allow", allow+", SEARCH")
+				}
+			}}
+			next.ServeHTTP(rw, r)
+			rw.apply()
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// serveSearch 执行 DAV:basicsearch 查询，以多状态响应返回匹配资源的属性
+func serveSearch(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, davReportMaxBytes+1))
+	if err != nil || len(body) > davReportMaxBytes {
+		http.Error(w, "无法读取请求", http.StatusBadRequest)
+		return
+	}
+	var req searchRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		http.Error(w, "无效的SEARCH请求", http.StatusBadRequest)
+		return
+	}
+	bs := req.Basic
+	if bs == nil {
+		http.Error(w, "只支持 DAV:basicsearch 查询", http.StatusUnprocessableEntity)
+		return
+	}
+
+	scope, depth := r.URL.Path, "infinity"
+	switch len(bs.From.Scopes) {
+	case 0:
+	case 1:
+		s := bs.From.Scopes[0]
+		u, err := r.URL.Parse(strings.TrimSpace(s.Href))
+		if err != nil {
+			http.Error(w, "无效的搜索范围", http.StatusBadRequest)
+			return
+		}
+		scope = u.Path
+		if d := strings.ToLower(strings.TrimSpace(s.Depth)); d != "" {
+			depth = d
+		}
+	default:
+		http.Error(w, "只支持一个搜索范围", http.StatusUnprocessableEntity)
+		return
+	}
+	scope = path.Clean(scope)
+	if scope != "/webdav" && !strings.HasPrefix(scope, "/webdav/") {
+		http.Error(w, "搜索范围必须位于 /webdav 下", http.StatusBadRequest)
+		return
+	}
+	if depth != "0" && depth != "1" && depth != "infinity" {
+		http.Error(w, "无效的搜索深度", http.StatusBadRequest)
+		return
+	}
+	if !aclAllowed(r, scope, aclRead) {
+		http.NotFound(w, r)
+		return
+	}
+
+	var pred searchPredicate
+	if bs.Where != nil {
+		if len(bs.Where.Exprs) != 1 {
+			http.Error(w, "DAV:where 需要一个条件", http.StatusBadRequest)
+			return
+		}
+		if pred, err = bs.Where.Exprs[0].compile(); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	var orders []searchOrder
+	for _, o := range bs.OrderBy.Orders {
+		if len(o.Prop.Names) != 1 || o.Prop.Names[0].XMLName.Space != "DAV:" || !searchProps[o.Prop.Names[0].XMLName.Local] {
+			http.Error(w, "不支持的排序属性", http.StatusUnprocessableEntity)
+			return
+		}
+		orders = append(orders, searchOrder{prop: o.Prop.Names[0].XMLName.Local, desc: o.Descending != nil})
+	}
+	limit := 0
+	if bs.Limit != nil {
+		n, err := strconv.Atoi(strings.TrimSpace(bs.Limit.NResults))
+		if err != nil || n <= 0 {
+			http.Error(w, "无效的nresults", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	props := searchDefaultProps
+	if bs.Select.Prop != nil && len(bs.Select.Prop.Names) > 0 {
+		props = make([]xml.Name, len(bs.Select.Prop.Names))
+		for i, n := range bs.Select.Prop.Names {
+			props[i] = n.XMLName
+		}
+	}
+
+	items, truncated, err := runSearch(r, scope, depth, pred, orders, limit)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var buf bytes.Buffer
+	for _, it := range items {
+		// 索引与文件系统之间可能短暂不一致，已不存在的资源不返回
+		if resp, ok := webdavReports.propResponse(r, it.href, props); ok {
+			buf.Write(resp)
+		}
+	}
+	if truncated {
+		buf.WriteString("<D:response><D:href>")
+		xml.EscapeText(&buf, []byte((&url.URL{Path: r.URL.Path}).EscapedPath()))
+		buf.WriteString("</D:href><D:status>HTTP/1.1 507 Insufficient Storage</D:status><D:error><D:number-of-matches-within-limits/></D:error></D:response>")
+	}
+	writeMultistatus(w, buf.Bytes())
+}
+
+// searchResult /api/search 返回的一个结果
+type searchResult struct {
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	Dir         bool      `json:"dir"`
+	Size        int64     `json:"size"`
+	Modified    time.Time `json:"modified"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+// searchAPIHandler 处理 /api/search：GET 在WebDAV目录中按名称、内容类型、大小和修改时间搜索。
+// 参数：path 搜索范围，q 名称（包含关系，或带 * ? 的通配符），type 内容类型前缀，
+// min_size/max_size 字节数，after/before 修改时间，kind=file|dir，sort=name|size|modified（前加 - 表示降序），limit 数量
+func searchAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if !webdavEnabled {
+		http.Error(w, "WebDAV服务未启用", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	scope := path.Clean("/" + q.Get("path"))
+	if q.Get("path") == "" {
+		scope = "/webdav"
+	}
+	if scope != "/webdav" && !strings.HasPrefix(scope, "/webdav/") {
+		http.Error(w, "path 必须位于 /webdav 下", http.StatusBadRequest)
+		return
+	}
+	if !aclAllowed(r, scope, aclRead) {
+		aclDenied(w, r, scope)
+		return
+	}
+
+	var preds []searchPredicate
+	if v := strings.ToLower(q.Get("q")); v != "" {
+		if strings.ContainsAny(v, "*?[") {
+			if _, err := path.Match(v, ""); err != nil {
+				http.Error(w, "无效的通配符", http.StatusBadRequest)
+				return
+			}
+			preds = append(preds, func(it *searchItem) bool {
+				ok, _ := path.Match(v, strings.ToLower(it.name))
+				return ok
+			})
+		} else {
+			preds = append(preds, func(it *searchItem) bool { return strings.Contains(strings.ToLower(it.name), v) })
+		}
+	}
+	if v := strings.ToLower(q.Get("type")); v != "" {
+		preds = append(preds, func(it *searchItem) bool { return !it.dir && strings.HasPrefix(strings.ToLower(it.contentType()), v) })
+	}
+	if v := q.Get("min_size"); v != "" {
+		n, err := parseByteSize(v)
+		if err != nil {
+			http.Error(w, "无效的 min_size", http.StatusBadRequest)
+			return
+		}
+		preds = append(preds, func(it *searchItem) bool { return !it.dir && it.size >= n })
+	}
+	if v := q.Get("max_size"); v != "" {
+		n, err := parseByteSize(v)
+		if err != nil {
+			http.Error(w, "无效的 max_size", http.StatusBadRequest)
+			return
+		}
+		preds = append(preds, func(it *searchItem) bool { return !it.dir && it.size <= n })
+	}
+	if v := q.Get("after"); v != "" {
+		t, err := parseSearchTime(v)
+		if err != nil {
+			http.Error(w, "无效的 after", http.StatusBadRequest)
+			return
+		}
+		preds = append(preds, func(it *searchItem) bool { return it.mod.After(t) })
+	}
+	if v := q.Get("before"); v != "" {
+		t, err := parseSearchTime(v)
+		if err != nil {
+			http.Error(w, "无效的 before", http.StatusBadRequest)
+			return
+		}
+		preds = append(preds, func(it *searchItem) bool { return it.mod.Before(t) })
+	}
+	switch q.Get("kind") {
+	case "":
+	case "file":
+		preds = append(preds, func(it *searchItem) bool { return !it.dir })
+	case "dir":
+		preds = append(preds, func(it *searchItem) bool { return it.dir })
+	default:
+		http.Error(w, "kind 只能是 file 或 dir", http.StatusBadRequest)
+		return
+	}
+
+	var orders []searchOrder
+	if v := q.Get("sort"); v != "" {
+		o := searchOrder{desc: strings.HasPrefix(v, "-")}
+		switch strings.TrimPrefix(v, "-") {
+		case "name":
+			o.prop = "displayname"
+		case "size":
+			o.prop = "getcontentlength"
+		case "modified":
+			o.prop = "getlastmodified"
+		default:
+			http.Error(w, "sort 只能是 name、size 或 modified", http.StatusBadRequest)
+			return
+		}
+		orders = append(orders, o)
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "无效的 limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	items, truncated, err := runSearch(r, scope, "infinity", func(it *searchItem) bool {
+		for _, p := range preds {
+			if !p(it) {
+				return false
+			}
+		}
+		return true
+	}, orders, limit)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	results := make([]searchResult, len(items))
+	for i, it := range items {
+		results[i] = searchResult{Path: it.href, Name: it.name, Dir: it.dir, Size: it.size, Modified: it.mod, ContentType: it.contentType()}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results, "truncated": truncated})
+}
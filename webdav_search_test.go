@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchExprCompile(t *testing.T) {
+	items := []searchItem{
+		{href: "/webdav/docs/", name: "docs", dir: true, mod: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{href: "/webdav/docs/Report.PDF", name: "Report.PDF", size: 2048, mod: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{href: "/webdav/docs/notes.txt", name: "notes.txt", size: 100, mod: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{href: "/webdav/docs/100%_done.txt", name: "100%_done.txt", size: 0, mod: time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	tests := []struct {
+		name    string
+		where   string
+		want    string // 匹配的资源名，以逗号分隔
+		wantErr bool
+	}{
+		{"是目录", `<D:is-collection/>`, "docs", false},
+		{"不是目录", `<D:not><D:is-collection/></D:not>`, "Report.PDF,notes.txt,100%_done.txt", false},
+		{"目录没有大小", `<D:is-defined><D:prop><D:getcontentlength/></D:prop></D:is-defined>`, "Report.PDF,notes.txt,100%_done.txt", false},
+		{"目录有名称", `<D:is-defined><D:prop><D:displayname/></D:prop></D:is-defined>`, "docs,Report.PDF,notes.txt,100%_done.txt", false},
+		{"大小大于", `<D:gt><D:prop><D:getcontentlength/></D:prop><D:literal>100</D:literal></D:gt>`, "Report.PDF", false},
+		{"大小不小于", `<D:gte><D:prop><D:getcontentlength/></D:prop><D:literal> 100 </D:literal></D:gte>`, "Report.PDF,notes.txt", false},
+		{"大小等于0不包括目录", `<D:eq><D:prop><D:getcontentlength/></D:prop><D:literal>0</D:literal></D:eq>`, "100%_done.txt", false},
+		{"修改时间早于日期", `<D:lt><D:prop><D:getlastmodified/></D:prop><D:literal>2024-02-10T00:00:00Z</D:literal></D:lt>`, "docs,notes.txt", false},
+		{"修改时间HTTP日期", `<D:gte><D:prop><D:getlastmodified/></D:prop><D:literal>Fri, 01 Mar 2024 00:00:00 GMT</D:literal></D:gte>`, "Report.PDF", false},
+		{"名称相等默认不区分大小写", `<D:eq><D:prop><D:displayname/></D:prop><D:literal>report.pdf</D:literal></D:eq>`, "Report.PDF", false},
+		{"名称相等区分大小写", `<D:eq caseless="no"><D:prop><D:displayname/></D:prop><D:literal>report.pdf</D:literal></D:eq>`, "", false},
+		{"like后缀", `<D:like><D:prop><D:displayname/></D:prop><D:literal>%.txt</D:literal></D:like>`, "notes.txt,100%_done.txt", false},
+		{"like单个字符", `<D:like><D:prop><D:displayname/></D:prop><D:literal>do_s</D:literal></D:like>`, "docs", false},
+		{"like转义", `<D:like><D:prop><D:displayname/></D:prop><D:literal>100\%\_%</D:literal></D:like>`, "100%_done.txt", false},
+		{"like区分大小写", `<D:like caseless="no"><D:prop><D:displayname/></D:prop><D:literal>%.pdf</D:literal></D:like>`, "", false},
+		{"like内容类型不包括目录", `<D:like><D:prop><D:getcontenttype/></D:prop><D:literal>%</D:literal></D:like>`, "Report.PDF,notes.txt,100%_done.txt", false},
+		{"like正则字符按字面匹配", `<D:like><D:prop><D:displayname/></D:prop><D:literal>notes.tx.</D:literal></D:like>`, "", false},
+		{"and", `<D:and><D:like><D:prop><D:displayname/></D:prop><D:literal>%.txt</D:literal></D:like><D:gt><D:prop><D:getcontentlength/></D:prop><D:literal>0</D:literal></D:gt></D:and>`, "notes.txt", false},
+		{"or", `<D:or><D:is-collection/><D:gt><D:prop><D:getcontentlength/></D:prop><D:literal>1000</D:literal></D:gt></D:or>`, "docs,Report.PDF", false},
+		{"空的and", `<D:and/>`, "", true},
+		{"not需要一个条件", `<D:not><D:is-collection/><D:is-collection/></D:not>`, "", true},
+		{"不支持的条件", `<D:contains>x</D:contains>`, "", true},
+		{"其他命名空间的条件", `<X:eq xmlns:X="urn:x"><D:prop><D:displayname/></D:prop><D:literal>a</D:literal></X:eq>`, "", true},
+		{"不支持的属性", `<D:eq><D:prop><D:getetag/></D:prop><D:literal>a</D:literal></D:eq>`, "", true},
+		{"其他命名空间的属性", `<D:eq><D:prop><X:displayname xmlns:X="urn:x"/></D:prop><D:literal>a</D:literal></D:eq>`, "", true},
+		{"多个属性", `<D:eq><D:prop><D:displayname/><D:getcontenttype/></D:prop><D:literal>a</D:literal></D:eq>`, "", true},
+		{"缺少literal", `<D:eq><D:prop><D:displayname/></D:prop></D:eq>`, "", true},
+		{"无效的大小", `<D:gt><D:prop><D:getcontentlength/></D:prop><D:literal>1KB</D:literal></D:gt>`, "", true},
+		{"无效的时间", `<D:gt><D:prop><D:getlastmodified/></D:prop><D:literal>昨天</D:literal></D:gt>`, "", true},
+		{"like不支持大小", `<D:like><D:prop><D:getcontentlength/></D:prop><D:literal>1%</D:literal></D:like>`, "", true},
+		{"like末尾转义", `<D:like><D:prop><D:displayname/></D:prop><D:literal>a\</D:literal></D:like>`, "", true},
+		{"子条件出错", `<D:or><D:is-collection/><D:like><D:prop><D:displayname/></D:prop></D:like></D:or>`, "", true},
+	}
+	for _, tt := range tests {
+		var where struct {
+			Exprs []searchExpr `xml:",any"`
+		}
+		if err := xml.Unmarshal([]byte(`<D:where xmlns:D="DAV:">`+tt.where+`</D:where>`), &where); err != nil || len(where.Exprs) != 1 {
+			t.Fatalf("%s: 无法解析条件: %v", tt.name, err)
+		}
+		pred, err := where.Exprs[0].compile()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: 错误 = %v, 期望出错 %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var got []string
+		for i := range items {
+			if pred(&items[i]) {
+				got = append(got, items[i].name)
+			}
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: 匹配 %q, 期望 %q", tt.name, strings.Join(got, ","), tt.want)
+		}
+	}
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// 保留的删除记录数上限，超出时丢弃最早的删除记录，更早的同步令牌随之失效
+const syncMaxTombstones = 10000
+
+// 同步令牌的前缀，令牌格式为 <前缀><索引标识>:<序号>
+const syncTokenPrefix = "urn:x-sweb:sync:"
+
+// webdavSyncScan 扫描WebDAV目录以发现在WebDAV之外发生的修改的间隔，0表示只在启动时扫描
+var webdavSyncScan time.Duration
+
+// syncEntry 同步索引中的一个文件或目录，记录其最后一次变化的序号和当时的状态
+type syncEntry struct {
+	Seq     uint64 `json:"seq"`
+	Size    int64  `json:"size,omitempty"`
+	ModTime int64  `json:"mtime,omitempty"`
+	Dir     bool   `json:"dir,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// sameState 判断文件状态是否与记录一致
+func (e *syncEntry) sameState(fi os.FileInfo) bool {
+	return !e.Deleted && e.Dir == fi.IsDir() && e.ModTime == fi.ModTime().UnixNano() && (e.Dir || e.Size == fi.Size())
+}
+
+// syncJournalEntry 同步日志中的一条记录：epoch 设置索引标识和最早有效的序号，set 设置一个文件的状态
+type syncJournalEntry struct {
+	Op    string     `json:"op"`
+	Path  string     `json:"path,omitempty"`
+	Entry *syncEntry `json:"entry,omitempty"`
+	Epoch string     `json:"epoch,omitempty"`
+	Min   uint64     `json:"min,omitempty"`
+}
+
+// syncIndex 记录WebDAV目录中每个文件最后一次变化的序号（RFC 6578 同步令牌），
+// 键为文件的实际路径；删除的文件保留删除记录，使客户端能得知哪些文件被删除。
+// 索引同时作为扫描时比较的快照，服务停止期间的修改会在下次启动扫描时发现
+type syncIndex struct {
+	mu      sync.Mutex
+	journal jsonJournal
+	epoch   string // 索引标识，索引重建后旧的令牌失效
+	seq     uint64 // 当前序号
+	min     uint64 // 早于该序号的令牌已无法提供完整的变化
+	entries map[string]*syncEntry
+}
+
+// davSync WebDAV同步索引，未启用WebDAV时为nil
+var davSync *syncIndex
+
+// newSyncIndex 打开同步日志并重放记录
+func newSyncIndex(path string) (*syncIndex, error) {
+	si := &syncIndex{journal: jsonJournal{path: path}, entries: make(map[string]*syncEntry)}
+	err := replayJournal(path, func(line []byte) error {
+		var entry syncJournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		si.apply(entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if si.epoch == "" {
+		si.epoch = newShareLinkID()
+	}
+	if err := si.compact(); err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// apply 将一条记录应用到内存状态，调用者需持有锁
+func (si *syncIndex) apply(entry syncJournalEntry) {
+	switch entry.Op {
+	case "epoch":
+		si.epoch, si.min = entry.Epoch, entry.Min
+		if si.seq < entry.Min {
+			si.seq = entry.Min
+		}
+	case "set":
+		if entry.Entry == nil {
+			return
+		}
+		si.entries[entry.Path] = entry.Entry
+		if entry.Entry.Seq > si.seq {
+			si.seq = entry.Entry.Seq
+		}
+	}
+}
+
+// compact 用当前状态重写日志，删除记录过多时丢弃最早的部分，调用者需持有锁
+func (si *syncIndex) compact() error {
+	var tombstones []string
+	for key, e := range si.entries {
+		if e.Deleted {
+			tombstones = append(tombstones, key)
+		}
+	}
+	if len(tombstones) > syncMaxTombstones {
+		sort.Slice(tombstones, func(i, j int) bool { return si.entries[tombstones[i]].Seq < si.entries[tombstones[j]].Seq })
+		for _, key := range tombstones[:len(tombstones)-syncMaxTombstones] {
+			if seq := si.entries[key].Seq; seq > si.min {
+				si.min = seq
+			}
+			delete(si.entries, key)
+		}
+	}
+
+	keys := make([]string, 0, len(si.entries))
+	for key := range si.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]interface{}, 0, len(keys)+1)
+	entries = append(entries, syncJournalEntry{Op: "epoch", Epoch: si.epoch, Min: si.min})
+	for _, key := range keys {
+		entries = append(entries, syncJournalEntry{Op: "set", Path: key, Entry: si.entries[key]})
+	}
+	return si.journal.rewrite(entries)
+}
+
+// commit 为一批变化分配新的序号并写入日志，调用者需持有锁
+func (si *syncIndex) commit(changes map[string]*syncEntry) {
+	if len(changes) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		si.seq++
+		e := changes[key]
+		e.Seq = si.seq
+		if _, ok := si.entries[key]; ok {
+			si.journal.stale++
+		}
+		si.entries[key] = e
+		entries = append(entries, syncJournalEntry{Op: "set", Path: key, Entry: e})
+	}
+	if si.journal.needsCompact(len(si.entries)) {
+		err := si.compact()
+		if err == nil {
+			return
+		}
+		log.Printf("⚠️ 无法压缩WebDAV同步日志: %v", err)
+	}
+	if err := si.journal.appendAll(entries); err != nil {
+		log.Printf("⚠️ 无法写入WebDAV同步日志: %v", err)
+	}
+}
+
+// stateEntry 根据文件信息生成索引条目
+func stateEntry(fi os.FileInfo) *syncEntry {
+	return &syncEntry{Size: fi.Size(), ModTime: fi.ModTime().UnixNano(), Dir: fi.IsDir()}
+}
+
+// touch 记录文件被修改（包括只修改了属性的情况），并更新其所在目录的状态
+func (si *syncIndex) touch(key string) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	changes := make(map[string]*syncEntry)
+	fi, err := os.Stat(key)
+	if err != nil {
+		si.removedLocked(key, changes)
+	} else {
+		changes[key] = stateEntry(fi)
+	}
+	si.parentLocked(key, changes)
+	si.commit(changes)
+}
+
+// touchTree 记录文件或目录及其下所有文件的当前状态，用于移动、还原后出现在新位置的文件
+func (si *syncIndex) touchTree(key string) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	changes := make(map[string]*syncEntry)
+	filepath.Walk(key, func(p string, fi os.FileInfo, err error) error {
+		if err == nil {
+			changes[p] = stateEntry(fi)
+		}
+		return nil
+	})
+	si.parentLocked(key, changes)
+	si.commit(changes)
+}
+
+// remove 记录文件或目录及其下所有文件被删除
+func (si *syncIndex) remove(key string) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	changes := make(map[string]*syncEntry)
+	si.removedLocked(key, changes)
+	si.parentLocked(key, changes)
+	si.commit(changes)
+}
+
+// removedLocked 为文件及其下所有尚未删除的文件生成删除记录，调用者需持有锁
+func (si *syncIndex) removedLocked(key string, changes map[string]*syncEntry) {
+	for k, e := range si.entries {
+		if !e.Deleted && propPathWithin(k, key) {
+			changes[k] = &syncEntry{Dir: e.Dir, Deleted: true}
+		}
+	}
+}
+
+// parentLocked 目录的修改时间随其中文件的增删变化，同时更新已记录的上级目录的状态，调用者需持有锁
+func (si *syncIndex) parentLocked(key string, changes map[string]*syncEntry) {
+	parent := filepath.Dir(key)
+	e, ok := si.entries[parent]
+	if !ok {
+		return
+	}
+	if fi, err := os.Stat(parent); err == nil && !e.sameState(fi) {
+		changes[parent] = stateEntry(fi)
+	}
+}
+
+// scan 扫描存储根目录，记录与索引中的状态不一致的文件；skip 中的目录（回收站、状态数据目录）不扫描
+func (si *syncIndex) scan(roots []string, skip map[string]bool) int {
+	seen := make(map[string]os.FileInfo)
+	for _, root := range roots {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() && (skip[p] || (d.Name() == trashDirName && filepath.Dir(p) == root)) {
+				return filepath.SkipDir
+			}
+			if p == root {
+				return nil
+			}
+			if fi, err := d.Info(); err == nil {
+				seen[p] = fi
+			}
+			return nil
+		})
+	}
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	changes := make(map[string]*syncEntry)
+	for p, fi := range seen {
+		if e, ok := si.entries[p]; !ok || !e.sameState(fi) {
+			changes[p] = stateEntry(fi)
+		}
+	}
+	for key, e := range si.entries {
+		if e.Deleted || seen[key] != nil {
+			continue
+		}
+		for _, root := range roots {
+			if propPathWithin(key, root) && key != root {
+				changes[key] = &syncEntry{Dir: e.Dir, Deleted: true}
+				break
+			}
+		}
+	}
+	si.commit(changes)
+	return len(changes)
+}
+
+// token 返回序号对应的同步令牌
+func (si *syncIndex) token(seq uint64) string {
+	return fmt.Sprintf("%s%s:%d", syncTokenPrefix, si.epoch, seq)
+}
+
+// currentToken 返回当前的同步令牌
+func (si *syncIndex) currentToken() string {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return si.token(si.seq)
+}
+
+// parseToken 解析同步令牌，返回其序号；令牌属于其他索引或已过期时ok为false
+func (si *syncIndex) parseToken(token string) (seq uint64, ok bool) {
+	rest, found := strings.CutPrefix(strings.TrimSpace(token), syncTokenPrefix)
+	if !found {
+		return 0, false
+	}
+	epoch, num, found := strings.Cut(rest, ":")
+	if !found || epoch != si.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || seq < si.min || seq > si.seq {
+		return 0, false
+	}
+	return seq, true
+}
+
+// syncChange 同步报告中的一项变化
+type syncChange struct {
+	key   string
+	entry syncEntry
+}
+
+// changes 返回目录中序号大于since的变化，按序号排列；infinite为false时只包括直接成员。
+// 初次同步（since为0）不包括已删除的文件
+func (si *syncIndex) changes(dir string, infinite bool, since uint64) (result []syncChange, seq uint64) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	for key, e := range si.entries {
+		if e.Seq <= since || key == dir || !propPathWithin(key, dir) || (since == 0 && e.Deleted) || webdavHiddenFile(key) || webdavReservedPath(key) {
+			continue
+		}
+		if !infinite && filepath.Dir(key) != dir {
+			continue
+		}
+		result = append(result, syncChange{key: key, entry: *e})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].entry.Seq < result[j].entry.Seq })
+	return result, si.seq
+}
+
+// members 返回目录下索引中现存的文件和目录，infinite为false时只包括直接成员，按路径排序
+func (si *syncIndex) members(dir string, infinite bool) []syncChange {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	var result []syncChange
+	for key, e := range si.entries {
+		if e.Deleted || key == dir || !propPathWithin(key, dir) || webdavHiddenFile(key) || webdavReservedPath(key) {
+			continue
+		}
+		if !infinite && filepath.Dir(key) != dir {
+			continue
+		}
+		result = append(result, syncChange{key: key, entry: *e})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].key < result[j].key })
+	return result
+}
+
+// count 返回索引中的文件数（不包括删除记录）
+func (si *syncIndex) count() int {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	n := 0
+	for _, e := range si.entries {
+		if !e.Deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// syncTracked 判断文件是否位于WebDAV存储目录中
+func syncTracked(key string) bool {
+	if webdavReservedPath(key) {
+		return false
+	}
+	if root, err := filepath.Abs(webdavDir); err == nil && propPathWithin(key, root) {
+		return true
+	}
+	if webdavHomes != nil {
+		for _, dir := range webdavHomes.shared {
+			if root, err := filepath.Abs(dir); err == nil && propPathWithin(key, root) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordSyncChange 记录在WebDAV之外（如网页上传、还原历史版本）修改的文件，tree为true时包括目录下的所有文件
+func recordSyncChange(file string, tree bool) {
+	if davSync == nil {
+		return
+	}
+	key, err := filepath.Abs(file)
+	if err != nil || !syncTracked(key) {
+		return
+	}
+	if tree {
+		davSync.touchTree(key)
+	} else {
+		davSync.touch(key)
+	}
+}
+
+// syncScanSkip 返回扫描时跳过的目录：状态数据目录等保留目录可能位于WebDAV目录中
+func syncScanSkip() map[string]bool {
+	skip := make(map[string]bool)
+	for _, dir := range webdavReservedDirs {
+		skip[dir] = true
+	}
+	return skip
+}
+
+// setupWebDAVSync 打开同步索引，扫描WebDAV目录并按间隔定期扫描
+func setupWebDAVSync() {
+	si, err := newSyncIndex(dataPath("webdav-sync.journal"))
+	if err != nil {
+		log.Fatalf("无法加载WebDAV同步索引: %v", err)
+	}
+	davSync = si
+	webdavReports.reports[xml.Name{Space: "DAV:", Local: "sync-collection"}] = syncCollectionReport
+	changed := si.scan(webdavStorageRoots(), syncScanSkip())
+	fmt.Printf("✅ WebDAV同步（sync-collection）已启用: %d 个文件，启动扫描发现 %d 处变化\n", si.count(), changed)
+	if webdavSyncScan > 0 {
+		go func() {
+			for range time.Tick(webdavSyncScan) {
+				si.scan(webdavStorageRoots(), syncScanSkip())
+			}
+		}()
+	}
+}
+
+// syncFS 将WebDAV中的修改记录到同步索引，并为集合提供 DAV:sync-token 和 DAV:supported-report-set 属性
+type syncFS struct {
+	webdav.FileSystem
+}
+
+func (fs *syncFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.FileSystem.Mkdir(ctx, name, perm); err != nil {
+		return err
+	}
+	if key, err := webdavAbsPath(ctx, name); err == nil {
+		davSync.touch(key)
+	}
+	return nil
+}
+
+func (fs *syncFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := webdavAbsPath(ctx, name)
+	return &syncFile{File: f, key: key, written: flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC|os.O_CREATE) != 0 && flag != os.O_RDWR}, nil
+}
+
+func (fs *syncFS) RemoveAll(ctx context.Context, name string) error {
+	key, keyErr := webdavAbsPath(ctx, name)
+	if err := fs.FileSystem.RemoveAll(ctx, name); err != nil {
+		return err
+	}
+	if keyErr == nil {
+		davSync.remove(key)
+	}
+	return nil
+}
+
+func (fs *syncFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldKey, oldErr := webdavAbsPath(ctx, oldName)
+	newKey, newErr := webdavAbsPath(ctx, newName)
+	if err := fs.FileSystem.Rename(ctx, oldName, newName); err != nil {
+		return err
+	}
+	if oldErr == nil {
+		davSync.remove(oldKey)
+	}
+	if newErr == nil {
+		davSync.touchTree(newKey)
+	}
+	return nil
+}
+
+// syncFile 写入的文件在关闭时记录修改；PROPPATCH以O_RDWR打开资源，属性修改成功时记录
+type syncFile struct {
+	webdav.File
+	key     string
+	written bool
+}
+
+func (f *syncFile) Close() error {
+	err := f.File.Close()
+	if f.written && f.key != "" {
+		davSync.touch(f.key)
+	}
+	return err
+}
+
+// syncTokenProp 集合的 DAV:sync-token 属性（RFC 6578 4节）
+var syncTokenProp = xml.Name{Space: "DAV:", Local: "sync-token"}
+
+func (f *syncFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props, err := innerDeadProps(f.File)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.File.Stat(); err == nil && fi.IsDir() {
+		var token bytes.Buffer
+		xml.EscapeText(&token, []byte(davSync.currentToken()))
+		props[syncTokenProp] = webdav.Property{XMLName: syncTokenProp, InnerXML: token.Bytes()}
+		props[supportedReportSetProp] = webdav.Property{XMLName: supportedReportSetProp, InnerXML: webdavReports.supportedReportSet()}
+	}
+	return props, nil
+}
+
+// Patch 拒绝修改同步属性，其余属性交给底层保存并记录修改
+func (f *syncFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	pstats, err := patchWithProtected(f.File, patches, func(name xml.Name) bool {
+		return name == syncTokenProp || name == supportedReportSetProp
+	})
+	if err == nil && f.key != "" && len(pstats) > 0 && pstats[0].Status == http.StatusOK {
+		davSync.touch(f.key)
+	}
+	return pstats, err
+}
+
+// syncCollectionRequest sync-collection REPORT的请求体（RFC 6578 6.1节）
+type syncCollectionRequest struct {
+	Token string `xml:"DAV: sync-token"`
+	Level string `xml:"DAV: sync-level"`
+	Limit *struct {
+		NResults string `xml:"DAV: nresults"`
+	} `xml:"DAV: limit"`
+}
+
+// syncCollectionReport 处理 DAV:sync-collection REPORT，返回集合中自令牌以来发生变化的成员
+func syncCollectionReport(rs *davReportSet, w http.ResponseWriter, r *http.Request, body []byte) {
+	var req syncCollectionRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		http.Error(w, "无效的sync-collection请求", http.StatusBadRequest)
+		return
+	}
+	if depth := r.Header.Get("Depth"); depth != "" && depth != "0" {
+		http.Error(w, "sync-collection请求的Depth必须为0", http.StatusBadRequest)
+		return
+	}
+	infinite := false
+	switch strings.TrimSpace(req.Level) {
+	case "1":
+	case "infinite":
+		infinite = true
+	default:
+		http.Error(w, "无效的sync-level", http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if req.Limit != nil {
+		n, err := strconv.Atoi(strings.TrimSpace(req.Limit.NResults))
+		if err != nil || n <= 0 {
+			http.Error(w, "无效的nresults", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	props, err := davPropNames(body)
+	if err != nil {
+		http.Error(w, "无效的sync-collection请求", http.StatusBadRequest)
+		return
+	}
+	if len(props) == 0 {
+		props = []xml.Name{{Space: "DAV:", Local: "getetag"}}
+	}
+
+	name := rs.name(r.URL.Path)
+	fi, err := rs.fs.Stat(r.Context(), name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !fi.IsDir() {
+		writeDAVError(w, http.StatusForbidden, "sync-collection-not-supported", "")
+		return
+	}
+	dir, err := webdavAbsPath(r.Context(), name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var since uint64
+	if token := strings.TrimSpace(req.Token); token != "" {
+		var ok bool
+		if since, ok = davSync.parseToken(token); !ok {
+			writeDAVError(w, http.StatusForbidden, "valid-sync-token", "")
+			return
+		}
+	}
+
+	changes, seq := davSync.changes(dir, infinite, since)
+	truncated := false
+	if limit > 0 && len(changes) > limit {
+		changes, seq, truncated = changes[:limit], changes[limit-1].entry.Seq, true
+	}
+
+	collection := path.Join(rs.prefix, name)
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<D:multistatus xmlns:D="DAV:">`)
+	for _, c := range changes {
+		rel, err := filepath.Rel(dir, c.key)
+		if err != nil {
+			continue
+		}
+		href := path.Join(collection, filepath.ToSlash(rel))
+		if c.entry.Dir {
+			href += "/"
+		}
+		if !aclAllowed(r, href, aclRead) {
+			continue
+		}
+		if !c.entry.Deleted {
+			if resp, ok := rs.propResponse(r, href, props); ok {
+				buf.Write(resp)
+				continue
+			}
+		}
+		buf.Write(davStatusResponse(href, http.StatusNotFound))
+	}
+	if truncated {
+		// 结果被截断，客户端应使用返回的令牌继续同步（RFC 6578 3.6节）
+		buf.WriteString("<D:response><D:href>")
+		xml.EscapeText(&buf, []byte((&url.URL{Path: collection + "/"}).EscapedPath()))
+		buf.WriteString("</D:href><D:status>HTTP/1.1 507 Insufficient Storage</D:status><D:error><D:number-of-matches-within-limits/></D:error></D:response>")
+	}
+	buf.WriteString("<D:sync-token>")
+	xml.EscapeText(&buf, []byte(davSync.token(seq)))
+	buf.WriteString("</D:sync-token></D:multistatus>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(webdav.StatusMulti)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncParseToken(t *testing.T) {
+	si := &syncIndex{epoch: "e1", seq: 20, min: 5, entries: make(map[string]*syncEntry)}
+	tests := []struct {
+		name  string
+		token string
+		want  uint64
+		ok    bool
+	}{
+		{"当前令牌", si.token(20), 20, true},
+		{"最早有效的令牌", si.token(5), 5, true},
+		{"前后空白", "  " + si.token(10) + "\n", 10, true},
+		{"早于最早有效序号", si.token(4), 0, false},
+		{"晚于当前序号", si.token(21), 0, false},
+		{"其他索引的令牌", syncTokenPrefix + "e2:10", 0, false},
+		{"缺少前缀", "e1:10", 0, false},
+		{"缺少序号", syncTokenPrefix + "e1", 0, false},
+		{"序号不是数字", syncTokenPrefix + "e1:abc", 0, false},
+		{"负数序号", syncTokenPrefix + "e1:-1", 0, false},
+		{"空令牌", "", 0, false},
+	}
+	for _, tt := range tests {
+		seq, ok := si.parseToken(tt.token)
+		if ok != tt.ok || seq != tt.want {
+			t.Errorf("%s: parseToken(%q) = %d, %v, 期望 %d, %v", tt.name, tt.token, seq, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSyncTombstoneTrim(t *testing.T) {
+	dir := t.TempDir()
+	journal := filepath.Join(dir, "sync.jsonl")
+	si, err := newSyncIndex(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "webdav")
+	changes := map[string]*syncEntry{filepath.Join(root, "keep.txt"): {Size: 1}}
+	for i := 0; i < syncMaxTombstones+10; i++ {
+		changes[filepath.Join(root, fmt.Sprintf("gone%05d.txt", i))] = &syncEntry{Deleted: true}
+	}
+	si.mu.Lock()
+	si.commit(changes)
+	if err := si.compact(); err != nil {
+		si.mu.Unlock()
+		t.Fatal(err)
+	}
+	si.mu.Unlock()
+
+	// commit 按路径顺序分配序号：gone00000..gone10009 为 1..10010，keep.txt 为 10011
+	tests := []struct {
+		name  string
+		key   string
+		exist bool
+	}{
+		{"最早的删除记录被丢弃", "gone00000.txt", false},
+		{"超出上限的最后一条被丢弃", "gone00009.txt", false},
+		{"上限内最早的删除记录保留", "gone00010.txt", true},
+		{"最新的删除记录保留", "gone10009.txt", true},
+		{"现存文件不计入上限", "keep.txt", true},
+	}
+	for _, tt := range tests {
+		if _, ok := si.entries[filepath.Join(root, tt.key)]; ok != tt.exist {
+			t.Errorf("%s: %s 存在 = %v, 期望 %v", tt.name, tt.key, ok, tt.exist)
+		}
+	}
+	if si.min != 10 {
+		t.Errorf("最早有效序号 = %d, 期望 10", si.min)
+	}
+	if _, ok := si.parseToken(si.token(9)); ok {
+		t.Error("被丢弃的删除记录之前的令牌应失效")
+	}
+	if _, ok := si.parseToken(si.token(10)); !ok {
+		t.Error("最后一条被丢弃的删除记录的令牌应仍然有效")
+	}
+	if changes, _ := si.changes(root, true, 10); len(changes) != syncMaxTombstones+1 {
+		t.Errorf("令牌10之后的变化数 = %d, 期望 %d", len(changes), syncMaxTombstones+1)
+	}
+	if changes, _ := si.changes(root, true, 0); len(changes) != 1 {
+		t.Errorf("初次同步的变化数 = %d, 期望 1（不包括删除记录）", len(changes))
+	}
+
+	reopened, err := newSyncIndex(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.epoch != si.epoch || reopened.min != si.min || reopened.seq != si.seq || len(reopened.entries) != len(si.entries) {
+		t.Errorf("重新打开后 epoch=%s min=%d seq=%d 条目数=%d, 期望 epoch=%s min=%d seq=%d 条目数=%d",
+			reopened.epoch, reopened.min, reopened.seq, len(reopened.entries), si.epoch, si.min, si.seq, len(si.entries))
+	}
+}
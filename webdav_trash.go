@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// 回收站目录名，位于每个存储根目录（WebDAV目录、用户主目录或共享文件夹）下，对WebDAV客户端隐藏
+const trashDirName = ".trash"
+
+// webdavTrashRetention 回收站中内容的保留时间，0表示不使用回收站，直接永久删除
+var webdavTrashRetention time.Duration
+
+// webdavHomes 启用用户主目录时使用的文件系统，用于解析资源所在的存储根目录
+var webdavHomes *userHomeFS
+
+// trashItem 回收站中的一项，元数据保存在 <根目录>/.trash/<id>.json，内容保存在 <根目录>/.trash/<id>
+type trashItem struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Path    string    `json:"path"` // 删除时的WebDAV路径
+	Rel     string    `json:"rel"`  // 相对存储根目录的路径
+	Deleted time.Time `json:"deleted"`
+	User    string    `json:"user,omitempty"`
+	Reason  string    `json:"reason"` // delete 或 overwrite
+	Size    int64     `json:"size"`
+	Dir     bool      `json:"dir"`
+
+	root string
+}
+
+// expires 返回该项被自动清除的时间
+func (item trashItem) expires() time.Time {
+	return item.Deleted.Add(webdavTrashRetention)
+}
+
+// webdavStorageRoot 返回WebDAV路径所在的存储根目录，以及资源相对该目录的路径
+func webdavStorageRoot(ctx context.Context, name string) (root, rel string, err error) {
+	if webdavHomes != nil {
+		var dir webdav.Dir
+		if dir, rel, _, err = webdavHomes.resolve(ctx, name); err != nil {
+			return "", "", err
+		}
+		root = string(dir)
+	} else {
+		root, rel = webdavDir, path.Clean("/"+name)
+	}
+	root, err = filepath.Abs(root)
+	return root, rel, err
+}
+
+// trashInternal 判断相对路径是否位于回收站目录中
+func trashInternal(rel string) bool {
+	first, _, _ := strings.Cut(strings.TrimPrefix(rel, "/"), "/")
+	return first == trashDirName
+}
+
+// trashFS 将删除和覆盖的内容移入回收站而不是直接删除，并对客户端隐藏回收站目录
+type trashFS struct {
+	webdav.FileSystem
+}
+
+// internal 判断WebDAV路径是否指向回收站目录
+func (fs *trashFS) internal(ctx context.Context, name string) bool {
+	_, rel, err := webdavStorageRoot(ctx, name)
+	return err == nil && trashInternal(rel)
+}
+
+func (fs *trashFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if fs.internal(ctx, name) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *trashFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	root, rel, err := webdavStorageRoot(ctx, name)
+	if err == nil && trashInternal(rel) {
+		return nil, os.ErrNotExist
+	}
+	if err == nil && flag&os.O_TRUNC != 0 && flag&os.O_CREATE != 0 && fileVersions == nil {
+		// 覆盖已有文件时先写入临时文件，写入完成后才将原内容移入回收站并替换；
+		// 启用历史版本时原内容保存为历史版本
+		if fi, statErr := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); statErr == nil && fi.Mode().IsRegular() && fi.Size() > 0 {
+			return openTrashStagedFile(ctx, root, rel, name, perm)
+		}
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "/" {
+		return &trashHidingFile{File: f}, nil
+	}
+	return f, nil
+}
+
+func (fs *trashFS) RemoveAll(ctx context.Context, name string) error {
+	root, rel, err := webdavStorageRoot(ctx, name)
+	if err != nil {
+		return err
+	}
+	if trashInternal(rel) || rel == "/" {
+		return os.ErrPermission
+	}
+	if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(rel))); os.IsNotExist(err) {
+		return nil
+	}
+	return moveToTrash(ctx, root, rel, name, "delete")
+}
+
+func (fs *trashFS) Rename(ctx context.Context, oldName, newName string) error {
+	if fs.internal(ctx, oldName) || fs.internal(ctx, newName) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs *trashFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if fs.internal(ctx, name) {
+		return nil, os.ErrNotExist
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+// trashHidingFile 在存储根目录的列表中隐藏回收站目录
+type trashHidingFile struct {
+	webdav.File
+}
+
+func (f *trashHidingFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	result := infos[:0]
+	for _, fi := range infos {
+		if fi.Name() != trashDirName {
+			result = append(result, fi)
+		}
+	}
+	return result, err
+}
+
+// trashUploadSuffix 覆盖写入时回收站目录中临时文件的后缀
+const trashUploadSuffix = ".upload"
+
+// trashStagedFile 覆盖已有文件时写入的临时文件，位于回收站目录中以便原子地替换原文件
+type trashStagedFile struct {
+	*os.File
+	ctx  context.Context
+	root string
+	rel  string
+	name string
+}
+
+// openTrashStagedFile 为覆盖WebDAV路径name创建临时文件
+func openTrashStagedFile(ctx context.Context, root, rel, name string, perm os.FileMode) (webdav.File, error) {
+	dir := filepath.Join(root, trashDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, newShareLinkID()+trashUploadSuffix), os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &trashStagedFile{File: f, ctx: ctx, root: root, rel: rel, name: name}, nil
+}
+
+func (f *trashStagedFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return renamedFileInfo{FileInfo: fi, name: path.Base(f.rel)}, nil
+}
+
+// Close 写入成功时将原文件移入回收站并用临时文件替换；写入因超出配额失败时丢弃临时文件，原文件保持不变
+func (f *trashStagedFile) Close() error {
+	tmp := f.File.Name()
+	if err := f.File.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if discardOnQuotaExceeded(f.ctx) {
+		return os.Remove(tmp)
+	}
+	if err := moveToTrash(f.ctx, f.root, f.rel, f.name, "overwrite"); err != nil && !os.IsNotExist(err) {
+		log.Printf("⚠️ 无法将被覆盖的文件移入回收站 %s: %v", f.name, err)
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(f.root, filepath.FromSlash(f.rel)))
+}
+
+// moveToTrash 将资源移入其存储根目录下的回收站并记录元数据
+func moveToTrash(ctx context.Context, root, rel, name, reason string) error {
+	src := filepath.Join(root, filepath.FromSlash(rel))
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, trashDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	item := trashItem{
+		ID:      newShareLinkID(),
+		Name:    path.Base(rel),
+		Path:    path.Join("/webdav", name),
+		Rel:     rel,
+		Deleted: time.Now().UTC(),
+		Reason:  reason,
+		Dir:     fi.IsDir(),
+	}
+	if id := contextIdentity(ctx); id != nil {
+		item.User = id.Name
+	}
+	if fi.IsDir() {
+		item.Size = treeSize(src)
+	} else {
+		item.Size = fi.Size()
+	}
+
+	// 先写元数据再移动内容，移动失败时撤销元数据；没有内容的元数据在列出时被忽略
+	meta := filepath.Join(dir, item.ID+".json")
+	if err := writeJSONFile(meta, item); err != nil {
+		return err
+	}
+	if err := os.Rename(src, filepath.Join(dir, item.ID)); err != nil {
+		os.Remove(meta)
+		return err
+	}
+	return nil
+}
+
+// webdavStorageRoots 返回所有WebDAV存储根目录：WebDAV目录，或所有用户主目录和共享文件夹
+func webdavStorageRoots() []string {
+	var roots []string
+	if webdavHomes == nil {
+		roots = append(roots, webdavDir)
+	} else {
+		entries, _ := os.ReadDir(filepath.Join(webdavDir, "users"))
+		for _, e := range entries {
+			if e.IsDir() {
+				roots = append(roots, filepath.Join(webdavDir, "users", e.Name()))
+			}
+		}
+		for _, dir := range webdavHomes.shared {
+			roots = append(roots, dir)
+		}
+	}
+	return absPaths(roots)
+}
+
+// userTrashRoots 返回用户可以看到的存储根目录：自己的主目录和共享文件夹，未启用用户主目录时为WebDAV目录
+func userTrashRoots(id *identity) []string {
+	if webdavHomes == nil {
+		return webdavStorageRoots()
+	}
+	var roots []string
+	if id != nil && validPathSegment(id.Name) {
+		roots = append(roots, filepath.Join(webdavDir, "users", id.Name))
+	}
+	for _, dir := range webdavHomes.shared {
+		roots = append(roots, dir)
+	}
+	return absPaths(roots)
+}
+
+// absPaths 将目录转换为绝对路径，与配额等按绝对路径记录的状态保持一致
+func absPaths(dirs []string) []string {
+	for i, dir := range dirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dirs[i] = abs
+		}
+	}
+	return dirs
+}
+
+// listTrash 列出存储根目录下回收站中的所有项
+func listTrash(root string) []trashItem {
+	dir := filepath.Join(root, trashDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var items []trashItem
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, id)); err != nil {
+			continue
+		}
+		var item trashItem
+		if err := readJSONFile(filepath.Join(dir, e.Name()), &item); err != nil || item.ID != id || item.Rel == "" {
+			continue
+		}
+		item.root = root
+		items = append(items, item)
+	}
+	return items
+}
+
+// contentPath 返回回收站中保存该项内容的路径
+func (item trashItem) contentPath() string {
+	return filepath.Join(item.root, trashDirName, item.ID)
+}
+
+// purge 永久删除回收站中的一项
+func (item trashItem) purge() error {
+	if err := os.RemoveAll(item.contentPath()); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(item.root, trashDirName, item.ID+".json"))
+}
+
+// errTrashRestoreConflict 还原位置已存在同名资源
+var errTrashRestoreConflict = errors.New("原位置已存在同名文件")
+
+// restore 将回收站中的一项移回原位置，必要时重新创建上级目录
+func (item trashItem) restore() error {
+	target := filepath.Join(item.root, filepath.FromSlash(item.Rel))
+	if _, err := os.Lstat(target); err == nil {
+		return errTrashRestoreConflict
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(item.contentPath(), target); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(item.root, trashDirName, item.ID+".json"))
+}
+
+// purgeExpiredTrash 清除所有超过保留时间的回收站内容
+func purgeExpiredTrash() {
+	now := time.Now()
+	for _, root := range webdavStorageRoots() {
+		// 服务中断时遗留的覆盖写入临时文件
+		dir := filepath.Join(root, trashDirName)
+		if entries, err := os.ReadDir(dir); err == nil {
+			for _, e := range entries {
+				if fi, err := e.Info(); err == nil && strings.HasSuffix(e.Name(), trashUploadSuffix) && now.Sub(fi.ModTime()) > 24*time.Hour {
+					os.Remove(filepath.Join(dir, e.Name()))
+				}
+			}
+		}
+		for _, item := range listTrash(root) {
+			if now.Before(item.expires()) {
+				continue
+			}
+			if err := item.purge(); err != nil {
+				log.Printf("⚠️ 无法清除过期的回收站内容 %s: %v", item.Path, err)
+				continue
+			}
+			davQuotaUsage.invalidate(root)
+		}
+	}
+}
+
+// setupWebDAVTrash 启用回收站并定期清除过期内容，未启用时直接返回原文件系统
+func setupWebDAVTrash(fs webdav.FileSystem) webdav.FileSystem {
+	if webdavTrashRetention <= 0 {
+		return fs
+	}
+	fmt.Printf("✅ WebDAV回收站已启用，删除的内容保留 %s\n", webdavTrashRetention)
+	go func() {
+		for {
+			purgeExpiredTrash()
+			time.Sleep(time.Hour)
+		}
+	}()
+	return &trashFS{FileSystem: fs}
+}
+
+// trashItemAllowed 判断请求者能否查看（write为false）或还原、清除回收站中的一项
+func trashItemAllowed(r *http.Request, item trashItem, write bool) bool {
+	id := requestIdentity(r)
+	if isAdmin(id) {
+		return true
+	}
+	if !write {
+		return aclAllowed(r, item.Path, aclRead)
+	}
+	return aclAllowed(r, item.Path, aclWrite) && davPolicy.mode(id, item.Path) == davModeReadWrite
+}
+
+// trashView 回收站项的API表示
+type trashView struct {
+	trashItem
+	Expires time.Time `json:"expires"`
+}
+
+// webdavTrashAPIHandler 处理 /api/webdav/trash：
+// GET 列出回收站（管理员可用 ?all=1 列出所有用户的回收站），
+// POST ?id= 还原，DELETE ?id= 永久删除（不指定id时清空可见的全部内容）
+func webdavTrashAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if webdavTrashRetention <= 0 || !webdavEnabled {
+		http.Error(w, "WebDAV回收站未启用", http.StatusNotFound)
+		return
+	}
+	id := requestIdentity(r)
+	roots := userTrashRoots(id)
+	if r.URL.Query().Get("all") == "1" {
+		if !isAdmin(id) {
+			http.Error(w, "需要管理员权限", http.StatusForbidden)
+			return
+		}
+		roots = webdavStorageRoots()
+	}
+	var items []trashItem
+	for _, root := range roots {
+		items = append(items, listTrash(root)...)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Deleted.After(items[j].Deleted) })
+	itemID := r.URL.Query().Get("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		result := []trashView{}
+		for _, item := range items {
+			if trashItemAllowed(r, item, false) {
+				result = append(result, trashView{trashItem: item, Expires: item.expires()})
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+
+	case http.MethodPost:
+		if itemID == "" {
+			http.Error(w, "需要指定 id", http.StatusBadRequest)
+			return
+		}
+		for _, item := range items {
+			if item.ID != itemID || !trashItemAllowed(r, item, false) {
+				continue
+			}
+			if !trashItemAllowed(r, item, true) {
+				http.Error(w, "没有权限还原该文件", http.StatusForbidden)
+				return
+			}
+			if err := item.restore(); err != nil {
+				if errors.Is(err, errTrashRestoreConflict) {
+					http.Error(w, err.Error(), http.StatusConflict)
+					return
+				}
+				http.Error(w, fmt.Sprintf("还原失败: %v", err), http.StatusInternalServerError)
+				return
+			}
+			davQuotaUsage.invalidate(item.root)
+			recordSyncChange(filepath.Join(item.root, filepath.FromSlash(item.Rel)), true)
+			log.Printf("♻️ 从回收站还原了 %s", item.Path)
+			writeJSON(w, http.StatusOK, map[string]interface{}{"restored": item.Path})
+			return
+		}
+		http.NotFound(w, r)
+
+	case http.MethodDelete:
+		purged := 0
+		for _, item := range items {
+			if itemID != "" && item.ID != itemID {
+				continue
+			}
+			if !trashItemAllowed(r, item, true) {
+				continue
+			}
+			if err := item.purge(); err != nil {
+				http.Error(w, fmt.Sprintf("删除失败: %v", err), http.StatusInternalServerError)
+				return
+			}
+			davQuotaUsage.invalidate(item.root)
+			purged++
+		}
+		if itemID != "" && purged == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"purged": purged})
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// trashStatus 返回状态API中回收站的信息
+func trashStatus() map[string]interface{} {
+	status := map[string]interface{}{
+		"enabled":   webdavTrashRetention > 0,
+		"retention": webdavTrashRetention.String(),
+	}
+	if webdavEnabled && webdavTrashRetention > 0 {
+		count := 0
+		for _, root := range webdavStorageRoots() {
+			count += len(listTrash(root))
+		}
+		status["items"] = count
+	}
+	return status
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// setupTrashTest 使用临时WebDAV目录启用回收站，返回回收站文件系统和存储根目录
+func setupTrashTest(t *testing.T) (*trashFS, string) {
+	t.Helper()
+	dir := t.TempDir()
+	oldDir, oldHomes, oldRetention := webdavDir, webdavHomes, webdavTrashRetention
+	webdavDir, webdavHomes, webdavTrashRetention = dir, nil, time.Hour
+	t.Cleanup(func() { webdavDir, webdavHomes, webdavTrashRetention = oldDir, oldHomes, oldRetention })
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &trashFS{FileSystem: webdav.Dir(dir)}, root
+}
+
+// trashWrite 通过文件系统覆盖写入文件
+func trashWrite(ctx context.Context, fs webdav.FileSystem, name, content string) error {
+	f, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, werr := f.Write([]byte(content))
+	if err := f.Close(); werr == nil {
+		werr = err
+	}
+	return werr
+}
+
+func TestTrashRestore(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		file    string
+		after   func(root string) // 删除后对原位置的修改
+		wantErr error
+	}{
+		{"还原文件", "/a.txt", nil, nil},
+		{"原位置已存在同名文件", "/a.txt", func(root string) {
+			os.WriteFile(filepath.Join(root, "a.txt"), []byte("new"), 0644)
+		}, errTrashRestoreConflict},
+		{"原位置已存在同名目录", "/a.txt", func(root string) {
+			os.Mkdir(filepath.Join(root, "a.txt"), 0755)
+		}, errTrashRestoreConflict},
+		{"重新创建上级目录", "/docs/sub/b.txt", func(root string) {
+			os.RemoveAll(filepath.Join(root, "docs"))
+		}, nil},
+		{"还原目录", "/docs", nil, nil},
+	}
+	for _, tt := range tests {
+		fs, root := setupTrashTest(t)
+		target := filepath.Join(root, filepath.FromSlash(tt.file))
+		content := filepath.Join(root, "docs", "sub", "b.txt")
+		if tt.file == "/a.txt" {
+			content = target
+		}
+		if err := os.MkdirAll(filepath.Dir(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(content, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.RemoveAll(ctx, tt.file); err != nil {
+			t.Fatalf("%s: 删除失败: %v", tt.name, err)
+		}
+		if _, err := os.Lstat(target); !os.IsNotExist(err) {
+			t.Fatalf("%s: 删除后原位置仍然存在", tt.name)
+		}
+		if tt.after != nil {
+			tt.after(root)
+		}
+		items := listTrash(root)
+		if len(items) != 1 || items[0].Rel != tt.file || items[0].Reason != "delete" {
+			t.Fatalf("%s: 回收站内容 = %+v", tt.name, items)
+		}
+
+		err := items[0].restore()
+		if err != tt.wantErr {
+			t.Errorf("%s: restore 错误 = %v, 期望 %v", tt.name, err, tt.wantErr)
+		}
+		if remaining := len(listTrash(root)); (err == nil) != (remaining == 0) {
+			t.Errorf("%s: 还原后回收站中剩余 %d 项", tt.name, remaining)
+		}
+		if err != nil {
+			continue
+		}
+		if data, err := os.ReadFile(content); err != nil || string(data) != "old" {
+			t.Errorf("%s: 还原后的内容 = %q, %v", tt.name, data, err)
+		}
+	}
+}
+
+func TestTrashRestoreSamePath(t *testing.T) {
+	fs, root := setupTrashTest(t)
+	ctx := context.Background()
+	for _, content := range []string{"v1", "v2"} {
+		if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.RemoveAll(ctx, "/a.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items := listTrash(root)
+	if len(items) != 2 {
+		t.Fatalf("回收站中有 %d 项, 期望 2", len(items))
+	}
+	if err := items[0].restore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := items[1].restore(); err != errTrashRestoreConflict {
+		t.Errorf("还原同一位置的第二项: 错误 = %v, 期望 %v", err, errTrashRestoreConflict)
+	}
+	if len(listTrash(root)) != 1 {
+		t.Error("发生冲突的项应保留在回收站中")
+	}
+}
+
+func TestTrashStagedOverwrite(t *testing.T) {
+	fs, root := setupTrashTest(t)
+	file := filepath.Join(root, "a.txt")
+	if err := os.WriteFile(file, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超出配额时丢弃临时文件，原文件保持不变且不进入回收站
+	st := &quotaState{}
+	ctx := context.WithValue(context.Background(), quotaStateKey{}, st)
+	f, err := fs.OpenFile(ctx, "/a.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := f.Stat(); err != nil || fi.Name() != "a.txt" {
+		t.Errorf("临时文件的名称 = %v, %v, 期望 a.txt", fi, err)
+	}
+	f.Write([]byte("partial"))
+	markQuotaExceeded(ctx)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !quotaWriteDiscarded(ctx) {
+		t.Error("应记录临时文件已被丢弃")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "old" {
+		t.Errorf("超出配额后原文件内容 = %q, 期望 old", data)
+	}
+	if items := listTrash(root); len(items) != 0 {
+		t.Errorf("超出配额时回收站中有 %d 项, 期望 0", len(items))
+	}
+
+	// 写入成功时原内容移入回收站
+	if err := trashWrite(context.Background(), fs, "/a.txt", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "new" {
+		t.Errorf("覆盖后的内容 = %q, 期望 new", data)
+	}
+	items := listTrash(root)
+	if len(items) != 1 || items[0].Reason != "overwrite" {
+		t.Fatalf("覆盖后的回收站内容 = %+v", items)
+	}
+	if data, _ := os.ReadFile(items[0].contentPath()); string(data) != "old" {
+		t.Errorf("回收站中的内容 = %q, 期望 old", data)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, trashDirName))
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), trashUploadSuffix) {
+			t.Errorf("遗留了临时文件 %s", e.Name())
+		}
+	}
+
+	// 空文件和新文件直接写入，不产生回收站内容
+	if err := trashWrite(context.Background(), fs, "/b.txt", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if items := listTrash(root); len(items) != 1 {
+		t.Errorf("创建新文件后回收站中有 %d 项, 期望 1", len(items))
+	}
+}
+
+func TestTrashHidden(t *testing.T) {
+	fs, root := setupTrashTest(t)
+	ctx := context.Background()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll(ctx, "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"Stat", func() error { _, err := fs.Stat(ctx, "/.trash"); return err }()},
+		{"OpenFile", func() error { _, err := fs.OpenFile(ctx, "/.trash/x", os.O_RDONLY, 0); return err }()},
+		{"Mkdir", fs.Mkdir(ctx, "/.trash/x", 0755)},
+		{"RemoveAll", fs.RemoveAll(ctx, "/.trash")},
+		{"删除根目录", fs.RemoveAll(ctx, "/")},
+		{"Rename", fs.Rename(ctx, "/b.txt", "/.trash/b.txt")},
+	}
+	for _, tt := range tests {
+		if tt.err == nil {
+			t.Errorf("%s: 期望拒绝访问回收站目录", tt.name)
+		}
+	}
+	f, err := fs.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	infos, _ := f.Readdir(0)
+	for _, fi := range infos {
+		if fi.Name() == trashDirName {
+			t.Error("根目录列表中不应包含回收站目录")
+		}
+	}
+}